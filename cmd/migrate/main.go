@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"forum/internal/config"
+	"forum/internal/db"
+	"log"
+	"os"
+)
+
+// migrate applies or rolls back schema migrations outside of the server.
+//
+//	go run ./cmd/migrate status
+//	go run ./cmd/migrate up
+//	go run ./cmd/migrate down -to 1
+func main() {
+	logger := log.New(os.Stdout, "migrate: ", log.LstdFlags)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	target := fs.Int("to", -1, "target schema version for down")
+	fs.Parse(os.Args[2:])
+
+	cfg := config.Load()
+	repo, err := db.NewRepository(cfg)
+	if err != nil {
+		logger.Fatalf("Database initialization error: %v", err)
+	}
+	defer repo.Close()
+
+	switch command {
+	case "up":
+		if err := repo.RunMigrations(); err != nil {
+			logger.Fatalf("Migration error: %v", err)
+		}
+	case "down":
+		if *target < 0 {
+			current, err := repo.SchemaVersion()
+			if err != nil {
+				logger.Fatalf("Migration error: %v", err)
+			}
+			*target = current - 1
+		}
+		if err := repo.MigrateDown(*target); err != nil {
+			logger.Fatalf("Rollback error: %v", err)
+		}
+	case "status":
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	statuses, err := repo.MigrationStatuses()
+	if err != nil {
+		logger.Fatalf("Status error: %v", err)
+	}
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [-to VERSION] | status")
+}
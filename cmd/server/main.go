@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"forum/internal/automod"
 	"forum/internal/config"
 	"forum/internal/db"
 	"forum/internal/handlers"
+	"forum/internal/mail"
 	"forum/internal/middleware"
+	"forum/internal/throttle"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -31,26 +36,81 @@ func main() {
 		logger.Fatalf("Migration error: %v", err)
 	}
 
-	// Start periodic session cleanup
+	// Start periodic cleanup of expired sessions, login throttles, sent mail,
+	// password reset and email verification tokens, login challenges and the
+	// trash. It also runs at startup, so frequent restarts do not keep putting
+	// it off.
 	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
+		cleanup := func() {
 			if err := repo.CleanExpiredSessions(); err != nil {
 				logger.Printf("Session cleanup error: %v", err)
 			}
+			if err := repo.DeleteStaleLoginThrottles(time.Now().Add(-throttle.DefaultUserPolicy.Window)); err != nil {
+				logger.Printf("Login throttle cleanup error: %v", err)
+			}
+			if _, err := repo.DeleteOldMail(time.Now().Add(-mailRetention)); err != nil {
+				logger.Printf("Mail outbox cleanup error: %v", err)
+			}
+			if err := repo.DeleteExpiredPasswordResets(time.Now().Add(-tokenRetention)); err != nil {
+				logger.Printf("Password reset cleanup error: %v", err)
+			}
+			if err := repo.DeleteExpiredEmailVerifications(time.Now().Add(-tokenRetention)); err != nil {
+				logger.Printf("Email verification cleanup error: %v", err)
+			}
+			if err := repo.DeleteExpiredLoginChallenges(); err != nil {
+				logger.Printf("Login challenge cleanup error: %v", err)
+			}
+			purgeTrash(repo, logger, filepath.Join(cfg.ProjectRoot, "static", "uploads"), cfg.TrashRetention)
+		}
+		cleanup()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanup()
 		}
 	}()
 
+	// Start the mail worker; without SMTP settings mail is only logged
+	var transport mail.Transport = mail.LogTransport{Log: logger}
+	if cfg.Mail.SMTPHost != "" {
+		transport = &mail.SMTPTransport{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		}
+	}
+	mailer := mail.NewMailer(repo, transport, logger, cfg.BaseURL)
+	go mailer.Run(context.Background())
+
+	authPolicy := middleware.Policy{TwoFactorRoles: cfg.TwoFactorRoles}
+	cookies := middleware.CookiePolicy{Secure: cfg.Security.SecureCookies}
+
+	// Automatic moderation of new and edited posts and comments
+	engine := automod.New(repo, automod.Premoderation{AccountAge: cfg.Premoderation.AccountAge, MinApproved: cfg.Premoderation.MinApproved})
+
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(repo, logger, cfg.ProjectRoot)
-	postHandler := handlers.NewPostHandler(repo, logger, cfg.ProjectRoot)
+	authHandler := handlers.NewAuthHandler(repo, logger, cfg.ProjectRoot, mailer, handlers.AuthOptions{
+		SessionTTL:     cfg.SessionTTL,
+		RememberMeTTL:  cfg.RememberMeTTL,
+		TwoFactorRoles: cfg.TwoFactorRoles,
+		Cookies:        cookies,
+	})
+	postHandler := handlers.NewPostHandler(repo, logger, cfg.ProjectRoot, cfg.CommentMaxDepth, engine)
 	likeHandler := handlers.NewLikeHandler(repo, logger, cfg.ProjectRoot)
-	commentHandler := handlers.NewCommentHandler(repo, logger, cfg.ProjectRoot)
+	commentHandler := handlers.NewCommentHandler(repo, logger, cfg.ProjectRoot, engine)
 	categoryHandler := handlers.NewCategoryHandler(repo, logger, cfg.ProjectRoot)
 	notificationsHandler := handlers.NewNotificationsHandler(repo, logger, cfg.ProjectRoot)
-	reportHandler := handlers.NewReportHandler(repo, logger, cfg.ProjectRoot)
+	reportHandler := handlers.NewReportHandler(repo, logger, cfg.ProjectRoot, mailer)
+	queueHandler := handlers.NewQueueHandler(repo, logger, cfg.ProjectRoot)
+	trashHandler := handlers.NewTrashHandler(repo, logger, cfg.ProjectRoot, cfg.TrashRetention)
 	profileHandler := handlers.NewProfileHandler(repo, logger, cfg.ProjectRoot)
+	searchHandler := handlers.NewSearchHandler(repo, logger, cfg.ProjectRoot)
+	revisionHandler := handlers.NewRevisionHandler(repo, logger, cfg.ProjectRoot)
+	apiHandler := handlers.NewAPIHandler(repo, logger, engine)
+	adminHandler := handlers.NewAdminHandler(repo, logger, cfg.ProjectRoot, mailer)
+	banHandler := handlers.NewBanHandler(repo, logger, cfg.ProjectRoot, mailer)
 
 	// Set up routes
 	mux := http.NewServeMux()
@@ -63,31 +123,120 @@ func main() {
 	})
 	mux.HandleFunc("/register", authHandler.Register)
 	mux.HandleFunc("/login", authHandler.Login)
+	mux.HandleFunc("/login/2fa", authHandler.LoginTwoFactor)
 	mux.HandleFunc("/logout", authHandler.Logout)
+	mux.HandleFunc("/verify", authHandler.Verify)
+	mux.HandleFunc("/forgot-password", authHandler.ForgotPassword)
+	mux.HandleFunc("/reset-password", authHandler.ResetPassword)
+	mux.Handle("/verify/resend", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(authHandler.ResendVerification)))
 	mux.HandleFunc("/posts", postHandler.Posts) // Posts page
-	mux.HandleFunc("/post", postHandler.Post)   // Single post page
-	mux.Handle("/create-post", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(postHandler.CreatePost)))
-	mux.Handle("/create-post/", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(postHandler.CreatePost)))
-	mux.Handle("/like", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(likeHandler.Like)))
-	mux.Handle("/comment", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(commentHandler.AddComment)))
-	mux.Handle("/delete-comment", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(commentHandler.DeleteComment)))
-	mux.Handle("/edit-comment", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(commentHandler.EditComment)))
-	mux.Handle("/categories", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(categoryHandler.CreateCategory)))
+	mux.Handle("/post", middleware.OptionalAuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.Post)))
+	mux.HandleFunc("/search", searchHandler.Search)
+	mux.Handle("/create-post", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.CreatePost)))
+	mux.Handle("/create-post/", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.CreatePost)))
+	mux.Handle("/like", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(likeHandler.Like)))
+	mux.Handle("/comment", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(commentHandler.AddComment)))
+	mux.Handle("/delete-comment", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(commentHandler.DeleteComment)))
+	mux.Handle("/edit-comment", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(commentHandler.EditComment)))
+	mux.Handle("/categories", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(categoryHandler.CreateCategory)))
 	mux.HandleFunc("/categories-list", categoryHandler.ListCategories)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(cfg.ProjectRoot, "static")))))
-	mux.Handle("/edit-post", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(postHandler.EditPost)))
-	mux.Handle("/delete-post", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(postHandler.DeletePost)))
-	mux.Handle("/notifications", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(notificationsHandler.ListNotifications)))
-	mux.Handle("/report", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(reportHandler.ReportForm)))
-	mux.Handle("/submit-report", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(reportHandler.SubmitReport)))
-	mux.Handle("/reports", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(reportHandler.ListReports)))
-	mux.Handle("/close-report", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(reportHandler.CloseReport)))
-	mux.Handle("/profile", middleware.AuthMiddleware(repo, logger)(http.HandlerFunc(profileHandler.Activity)))
+	mux.Handle("/edit-post", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.EditPost)))
+	mux.Handle("/post/flags", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.SetPostFlag)))
+	mux.Handle("/announcements/dismiss", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.DismissAnnouncement)))
+	mux.Handle("/delete-post", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(postHandler.DeletePost)))
+	mux.Handle("/history", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(revisionHandler.History)))
+	mux.Handle("/rollback-revision", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(revisionHandler.Rollback)))
+	mux.Handle("/notifications", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(notificationsHandler.ListNotifications)))
+	mux.Handle("/notifications/stream", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(notificationsHandler.Stream)))
+	mux.Handle("/notifications/read", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(notificationsHandler.MarkRead)))
+	mux.Handle("/notifications/read-all", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(notificationsHandler.MarkAllRead)))
+	mux.Handle("/report", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(reportHandler.ReportForm)))
+	mux.Handle("/submit-report", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(reportHandler.SubmitReport)))
+	mux.Handle("/reports", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(reportHandler.ListReports)))
+	mux.Handle("/reports/resolve", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(reportHandler.ResolveReport)))
+	mux.Handle("/moderation/queue", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(queueHandler.Queue)))
+	mux.Handle("/moderation/queue/approve", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(queueHandler.Approve)))
+	mux.Handle("/moderation/queue/reject", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(queueHandler.Reject)))
+	mux.Handle("/moderation/trash", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(trashHandler.Trash)))
+	mux.Handle("/moderation/trash/restore", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(trashHandler.Restore)))
+	mux.HandleFunc("/user", profileHandler.Public)
+	mux.Handle("/profile", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.Activity)))
+	mux.Handle("/profile/tokens", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.CreateToken)))
+	mux.Handle("/profile/tokens/revoke", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.RevokeToken)))
+	mux.Handle("/profile/sessions", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.Sessions)))
+	mux.Handle("/profile/sessions/revoke", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.RevokeSession)))
+	mux.Handle("/profile/sessions/revoke-others", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.RevokeOtherSessions)))
+	mux.Handle("/profile/2fa/setup", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.SetupTwoFactor)))
+	mux.Handle("/profile/2fa/enable", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.EnableTwoFactor)))
+	mux.Handle("/profile/2fa/disable", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.DisableTwoFactor)))
+	mux.Handle("/profile/2fa/recovery-codes", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(profileHandler.RegenerateRecoveryCodes)))
+	mux.Handle("/bans", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(banHandler.Bans)))
+	mux.Handle("/bans/create", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(banHandler.CreateBan)))
+	mux.Handle("/bans/lift", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(banHandler.LiftBan)))
+	mux.Handle("/banned", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(banHandler.Banned)))
+	mux.Handle("/admin/lockouts", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.Lockouts)))
+	mux.Handle("/admin/lockouts/clear", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.ClearLockout)))
+	mux.Handle("/admin/users", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.Users)))
+	mux.Handle("/admin/user", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.User)))
+	mux.Handle("/admin/users/role", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.ChangeRole)))
+	mux.Handle("/admin/users/reset-password", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.ForcePasswordReset)))
+	mux.Handle("/admin/users/revoke-sessions", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.RevokeSessions)))
+	mux.Handle("/admin/automod", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.Automod)))
+	mux.Handle("/admin/automod/save", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.SaveAutomodRule)))
+	mux.Handle("/admin/automod/delete", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.DeleteAutomodRule)))
+	mux.Handle("/admin/audit", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.Audit)))
+	mux.Handle("/admin/audit/export", middleware.AuthMiddleware(repo, logger, authPolicy)(http.HandlerFunc(adminHandler.ExportAudit)))
+
+	// JSON API. Authentication is optional at the middleware level; each
+	// endpoint decides whether it needs a user and answers 401 itself.
+	apiMethods := map[string][]string{}
+	api := func(pattern string, h http.HandlerFunc) {
+		method, path, _ := strings.Cut(pattern, " ")
+		apiMethods[path] = append(apiMethods[path], method)
+		mux.Handle(pattern, middleware.OptionalAuthMiddleware(repo, logger, authPolicy)(h))
+	}
+	api("GET /api/v1/posts", apiHandler.ListPosts)
+	api("POST /api/v1/posts", apiHandler.CreatePost)
+	api("GET /api/v1/posts/{id}", apiHandler.GetPost)
+	api("PATCH /api/v1/posts/{id}", apiHandler.UpdatePost)
+	api("DELETE /api/v1/posts/{id}", apiHandler.DeletePost)
+	api("POST /api/v1/posts/{id}/votes", apiHandler.VotePost)
+	api("GET /api/v1/posts/{id}/comments", apiHandler.ListComments)
+	api("POST /api/v1/posts/{id}/comments", apiHandler.CreateComment)
+	api("GET /api/v1/comments/{id}", apiHandler.GetComment)
+	api("PATCH /api/v1/comments/{id}", apiHandler.UpdateComment)
+	api("DELETE /api/v1/comments/{id}", apiHandler.DeleteComment)
+	api("POST /api/v1/comments/{id}/votes", apiHandler.VoteComment)
+	api("GET /api/v1/categories", apiHandler.ListCategories)
+	api("POST /api/v1/categories", apiHandler.CreateCategory)
+	api("GET /api/v1/notifications", apiHandler.ListNotifications)
+	api("POST /api/v1/notifications/{id}/read", apiHandler.MarkNotificationRead)
+	api("POST /api/v1/notifications/read-all", apiHandler.MarkAllNotificationsRead)
+	api("GET /api/v1/users/autocomplete", apiHandler.AutocompleteUsers)
+	api("GET /api/v1/reports", apiHandler.ListReports)
+	api("POST /api/v1/reports", apiHandler.CreateReport)
+	api("POST /api/v1/reports/{id}/close", apiHandler.CloseReport)
+	for path, methods := range apiMethods {
+		mux.Handle(path, apiHandler.MethodNotAllowed(methods))
+	}
+	mux.HandleFunc("/api/", apiHandler.NotFound)
 
 	// Start server
 	logger.Printf("Server started at http://localhost:8080")
 
-	if err := http.ListenAndServe(":"+cfg.Port, mux); err != nil {
+	// Every state-changing request must carry the CSRF token, and every
+	// response gets the security headers
+	handler := middleware.CSRF(logger, cookies, handlers.CSRFRejected(cfg.ProjectRoot))(mux)
+	handler = middleware.SecurityHeaders(logger, middleware.SecurityOptions{
+		ContentSecurityPolicy: cfg.Security.ContentSecurityPolicy,
+		FrameAncestors:        cfg.Security.FrameAncestors,
+		HSTS:                  cfg.Security.HSTS,
+		ReferrerPolicy:        cfg.Security.ReferrerPolicy,
+		NoSniff:               cfg.Security.NoSniff,
+	})(handler)
+
+	if err := http.ListenAndServe(":"+cfg.Port, handler); err != nil {
 		logger.Fatalf("Server start error: %v", err)
 	}
 }
@@ -98,3 +247,44 @@ func serveCustom404(w http.ResponseWriter, r *http.Request, projectRoot string)
 	w.WriteHeader(http.StatusNotFound)
 	http.ServeFile(w, r, filepath.Join(projectRoot, "static/404.html"))
 }
+
+// mailRetention is how long sent and failed mail stays in the outbox.
+const mailRetention = 7 * 24 * time.Hour
+
+// tokenRetention is how long used and expired single-use tokens are kept.
+// It covers the windows over which issuing them is rate limited.
+const tokenRetention = 24 * time.Hour
+
+// purgeTrash removes posts and comments that have been in the trash longer
+// than retention, then deletes the image files of the purged posts that no
+// other post or comment still shows. Other files in the upload directory are
+// never touched.
+func purgeTrash(repo *db.Repository, logger *log.Logger, uploadDir string, retention time.Duration) {
+	posts, comments, images, err := repo.PurgeDeleted(time.Now().Add(-retention))
+	if err != nil {
+		logger.Printf("Trash purge error: %v", err)
+		return
+	}
+	if posts > 0 || comments > 0 {
+		logger.Printf("Purged %d posts and %d comments from the trash", posts, comments)
+	}
+
+	for _, path := range images {
+		used, err := repo.ImageInUse(path)
+		if err != nil {
+			logger.Printf("Upload cleanup error: %v", err)
+			continue
+		}
+		if used {
+			continue
+		}
+		name := filepath.Base(path)
+		if err := os.Remove(filepath.Join(uploadDir, name)); err != nil {
+			if !os.IsNotExist(err) {
+				logger.Printf("Upload cleanup error: %v", err)
+			}
+			continue
+		}
+		logger.Printf("Removed upload %s of a purged post", name)
+	}
+}
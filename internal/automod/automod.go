@@ -0,0 +1,489 @@
+// Package automod screens new and edited posts and comments against rules
+// the admins set up. A matching rule can reject the content outright, hold
+// it in the moderation queue until a moderator approves it, or let it
+// through and file a report. When several rules match, the strictest action
+// wins. Every match is logged.
+//
+// New content from users under premoderation goes to the queue as well,
+// whether or not a rule matched.
+package automod
+
+import (
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rule kinds. Pattern and Threshold of a rule mean different things for
+// each kind.
+const (
+	// KindWords matches any of the words or phrases in Pattern, one per line
+	// or separated by commas, ignoring case
+	KindWords = "words"
+	// KindRegex matches the regular expression in Pattern
+	KindRegex = "regex"
+	// KindLinks matches content with more than Threshold links
+	KindLinks = "links"
+	// KindCaps matches content with at least capsMinLetters letters of which
+	// more than Threshold percent are capitals
+	KindCaps = "caps"
+	// KindRepetition matches a character or word repeated more than
+	// Threshold times in a row
+	KindRepetition = "repetition"
+	// KindDuplicate matches text the author already posted in the last
+	// Threshold hours
+	KindDuplicate = "duplicate"
+)
+
+// Kinds lists the rule kinds in the order the admin page offers them.
+var Kinds = []string{KindWords, KindRegex, KindLinks, KindCaps, KindRepetition, KindDuplicate}
+
+// Actions, from mildest to strictest.
+const (
+	ActionReport = "report"
+	ActionHold   = "hold"
+	ActionReject = "reject"
+)
+
+// Actions lists the actions from strictest to mildest.
+var Actions = []string{ActionReject, ActionHold, ActionReport}
+
+// capsMinLetters keeps short shouts like "OK" or "LOL" from matching caps
+// rules.
+const capsMinLetters = 20
+
+// excerptLength caps the text of a match kept in the log.
+const excerptLength = 200
+
+var (
+	ErrUnknownKind   = errors.New("unknown rule kind")
+	ErrUnknownAction = errors.New("unknown rule action")
+	ErrEmptyName     = errors.New("rule name is empty")
+	ErrEmptyPattern  = errors.New("rule needs a word list or pattern")
+	ErrBadThreshold  = errors.New("threshold is out of range")
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+// Premoderation says whose new posts and comments wait in the moderation
+// queue: users whose account is younger than AccountAge or who have fewer
+// than MinApproved published posts and comments. A zero value turns the
+// condition off.
+type Premoderation struct {
+	AccountAge  time.Duration
+	MinApproved int
+}
+
+// Store loads rules and carries out verdicts. *db.Repository implements it.
+type Store interface {
+	GetAutomodRules() ([]*models.AutomodRule, error)
+	GetUserByID(id int) (*models.User, error)
+	// CountApprovedContent counts the user's published posts and comments
+	CountApprovedContent(userID int) (int, error)
+	// CountDuplicateContent counts the user's posts and comments since the
+	// given time with the same text, skipping the one being edited
+	CountDuplicateContent(userID int, content string, since time.Time, targetType string, targetID int) (int, error)
+	RecordAutomodMatch(m *models.AutomodMatch) error
+	CreateReport(reporterID int, postID, commentID *int, reason string) error
+	SetPostVisibility(postID int, visibility string) error
+	SetCommentVisibility(commentID int, visibility string) error
+}
+
+// Content is a post or comment about to be saved.
+type Content struct {
+	// Type is models.RevisionPost or models.RevisionComment
+	Type string
+	// ID is the post or comment being edited, or 0 for new content
+	ID       int
+	AuthorID int
+	// Title is empty for comments
+	Title string
+	Text  string
+}
+
+// Match is a rule that matched, with the part of the content that
+// triggered it.
+type Match struct {
+	Rule    *models.AutomodRule
+	Excerpt string
+}
+
+// Verdict is the outcome of checking a piece of content. The zero value
+// lets the content through untouched.
+type Verdict struct {
+	Content Content
+	Matches []Match
+	// Premoderated is set for new content from a user under premoderation
+	Premoderated bool
+	// Unchecked is set when the rules or premoderation could not be
+	// checked; the content is then held rather than published
+	Unchecked bool
+}
+
+// Action returns the strictest action of the matched rules, or "" if no
+// rule matched.
+func (v *Verdict) Action() string {
+	action := ""
+	for _, m := range v.Matches {
+		if severity(m.Rule.Action) > severity(action) {
+			action = m.Rule.Action
+		}
+	}
+	return action
+}
+
+// Rejected reports whether the content must not be saved.
+func (v *Verdict) Rejected() bool {
+	return v.Action() == ActionReject
+}
+
+// Held reports whether the content waits for a moderator because a rule
+// holds it or the rules could not be checked.
+func (v *Verdict) Held() bool {
+	action := v.Action()
+	return action == ActionHold || (v.Unchecked && action != ActionReject)
+}
+
+// fail marks the verdict unchecked and returns it with err.
+func (v *Verdict) fail(err error) (*Verdict, error) {
+	v.Unchecked = true
+	return v, err
+}
+
+// Queued reports whether the content waits in the moderation queue, held by
+// a rule or premoderated.
+func (v *Verdict) Queued() bool {
+	return v.Held() || v.Premoderated
+}
+
+// Visibility returns the visibility new content is saved with.
+func (v *Verdict) Visibility() string {
+	if v.Queued() {
+		return models.VisibilityPending
+	}
+	return models.VisibilityVisible
+}
+
+// RuleNames lists the names of the matched rules, quoted and separated by
+// commas.
+func (v *Verdict) RuleNames() string {
+	names := make([]string, 0, len(v.Matches))
+	for _, m := range v.Matches {
+		names = append(names, fmt.Sprintf("%q", m.Rule.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+func severity(action string) int {
+	switch action {
+	case ActionReport:
+		return 1
+	case ActionHold:
+		return 2
+	case ActionReject:
+		return 3
+	}
+	return 0
+}
+
+// Validate checks a rule before it is saved.
+func Validate(rule *models.AutomodRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return ErrEmptyName
+	}
+	if severity(rule.Action) == 0 {
+		return ErrUnknownAction
+	}
+	if rule.MaxAccountAgeDays < 0 {
+		return ErrBadThreshold
+	}
+	switch rule.Kind {
+	case KindWords:
+		if len(wordList(rule.Pattern)) == 0 {
+			return ErrEmptyPattern
+		}
+	case KindRegex:
+		if strings.TrimSpace(rule.Pattern) == "" {
+			return ErrEmptyPattern
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return err
+		}
+	case KindLinks, KindDuplicate:
+		if rule.Threshold < 0 || (rule.Kind == KindDuplicate && rule.Threshold == 0) {
+			return ErrBadThreshold
+		}
+	case KindCaps:
+		if rule.Threshold < 0 || rule.Threshold >= 100 {
+			return ErrBadThreshold
+		}
+	case KindRepetition:
+		if rule.Threshold < 1 {
+			return ErrBadThreshold
+		}
+	default:
+		return ErrUnknownKind
+	}
+	return nil
+}
+
+// Engine checks content against the enabled rules. Rules are loaded from
+// the store on every check, so edits take effect at once. It is safe for
+// concurrent use.
+type Engine struct {
+	store  Store
+	premod Premoderation
+	// mu guards regexps, compiled patterns keyed by their source
+	mu      sync.Mutex
+	regexps map[string]*regexp.Regexp
+	now     func() time.Time
+}
+
+// New creates an Engine.
+func New(store Store, premod Premoderation) *Engine {
+	return &Engine{store: store, premod: premod, regexps: map[string]*regexp.Regexp{}, now: time.Now}
+}
+
+// Check runs the enabled rules against c and, for new content, decides
+// whether it is premoderated. It has no side effects; pass the verdict to
+// Apply once the outcome is known. If anything cannot be checked, the error
+// is returned with an Unchecked verdict that holds the content, so it fails
+// closed; premoderation is still decided if the rules alone fail to load.
+func (e *Engine) Check(c Content) (*Verdict, error) {
+	v := &Verdict{Content: c}
+	premod := c.ID == 0 && (e.premod.AccountAge > 0 || e.premod.MinApproved > 0)
+	rules, rulesErr := e.store.GetAutomodRules()
+	if rulesErr == nil && len(rules) == 0 && !premod {
+		return v, nil
+	}
+	author, err := e.store.GetUserByID(c.AuthorID)
+	if err != nil {
+		return v.fail(err)
+	}
+	now := e.now()
+	if premod {
+		if v.Premoderated, err = e.premoderated(author, now); err != nil {
+			return v.fail(err)
+		}
+	}
+	if rulesErr != nil {
+		return v.fail(rulesErr)
+	}
+	text := c.Text
+	if c.Title != "" {
+		text = c.Title + "\n" + c.Text
+	}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.MaxAccountAgeDays > 0 && author.CreatedAt.Before(now.AddDate(0, 0, -rule.MaxAccountAgeDays)) {
+			continue
+		}
+		excerpt, err := e.match(rule, c, text, now)
+		if err != nil {
+			return v.fail(fmt.Errorf("rule %d: %w", rule.ID, err))
+		}
+		if excerpt != "" {
+			v.Matches = append(v.Matches, Match{Rule: rule, Excerpt: truncate(excerpt, excerptLength)})
+		}
+	}
+	return v, nil
+}
+
+// premoderated reports whether the author's new content goes to the queue.
+func (e *Engine) premoderated(author *models.User, now time.Time) (bool, error) {
+	if e.premod.AccountAge > 0 && author.CreatedAt.After(now.Add(-e.premod.AccountAge)) {
+		return true, nil
+	}
+	if e.premod.MinApproved > 0 {
+		n, err := e.store.CountApprovedContent(author.ID)
+		if err != nil {
+			return false, err
+		}
+		return n < e.premod.MinApproved, nil
+	}
+	return false, nil
+}
+
+// match returns what in the content made the rule match, or "" if it did
+// not.
+func (e *Engine) match(rule *models.AutomodRule, c Content, text string, now time.Time) (string, error) {
+	switch rule.Kind {
+	case KindWords:
+		return matchWords(wordList(rule.Pattern), text), nil
+	case KindRegex:
+		re, err := e.regexp(rule.Pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.FindString(text), nil
+	case KindLinks:
+		if n := len(linkPattern.FindAllStringIndex(text, -1)); n > rule.Threshold {
+			return fmt.Sprintf("%d links", n), nil
+		}
+	case KindCaps:
+		letters, upper := 0, 0
+		for _, r := range text {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		if letters >= capsMinLetters && upper*100 > rule.Threshold*letters {
+			return fmt.Sprintf("%d%% capitals", upper*100/letters), nil
+		}
+	case KindRepetition:
+		return matchRepetition(text, rule.Threshold), nil
+	case KindDuplicate:
+		since := now.Add(-time.Duration(rule.Threshold) * time.Hour)
+		n, err := e.store.CountDuplicateContent(c.AuthorID, c.Text, since, c.Type, c.ID)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			return fmt.Sprintf("posted %d times in the last %d hours", n, rule.Threshold), nil
+		}
+	}
+	return "", nil
+}
+
+func (e *Engine) regexp(pattern string) (*regexp.Regexp, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if re, ok := e.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	e.regexps[pattern] = re
+	return re, nil
+}
+
+// Apply carries out a verdict for content saved under the given ID, or
+// rejected if id is 0: it logs every match, moves edited content that is
+// held back to the queue and files a report for reported content. The
+// report is filed in the name of the admin who created the matching rule.
+func (e *Engine) Apply(v *Verdict, id int) error {
+	if len(v.Matches) == 0 && !v.Unchecked {
+		return nil
+	}
+	var targetID *int
+	if id != 0 {
+		targetID = &id
+	}
+	action := v.Action()
+	var reporter *models.AutomodRule
+	for _, m := range v.Matches {
+		ruleID := m.Rule.ID
+		if err := e.store.RecordAutomodMatch(&models.AutomodMatch{
+			RuleID:     &ruleID,
+			RuleName:   m.Rule.Name,
+			UserID:     v.Content.AuthorID,
+			TargetType: v.Content.Type,
+			TargetID:   targetID,
+			Action:     action,
+			Excerpt:    m.Excerpt,
+		}); err != nil {
+			return err
+		}
+		if reporter == nil && m.Rule.Action == action {
+			reporter = m.Rule
+		}
+	}
+	if id == 0 {
+		return nil
+	}
+
+	switch {
+	case v.Held():
+		if v.Content.Type == models.RevisionComment {
+			return e.store.SetCommentVisibility(id, models.VisibilityPending)
+		}
+		return e.store.SetPostVisibility(id, models.VisibilityPending)
+	case action == ActionReport:
+		if v.Content.Type == models.RevisionComment {
+			return e.store.CreateReport(reporter.CreatedBy, nil, &id, "Automod: "+v.RuleNames())
+		}
+		return e.store.CreateReport(reporter.CreatedBy, &id, nil, "Automod: "+v.RuleNames())
+	}
+	return nil
+}
+
+// wordList splits a words rule pattern into lower-case entries.
+func wordList(pattern string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(pattern, func(r rune) bool { return r == '\n' || r == ',' }) {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// matchWords returns the first entry found in text. Single words must match
+// a whole word; entries with spaces match anywhere.
+func matchWords(words []string, text string) string {
+	lower := strings.ToLower(text)
+	tokens := map[string]bool{}
+	for _, t := range strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		tokens[t] = true
+	}
+	for _, w := range words {
+		if strings.ContainsRune(w, ' ') {
+			if strings.Contains(lower, w) {
+				return w
+			}
+		} else if tokens[w] {
+			return w
+		}
+	}
+	return ""
+}
+
+// matchRepetition returns the first run of more than limit equal
+// characters or words in text.
+func matchRepetition(text string, limit int) string {
+	var prev rune
+	run := 0
+	for _, r := range text {
+		if r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		if run > limit {
+			return strings.Repeat(string(r), run)
+		}
+	}
+	words := strings.Fields(strings.ToLower(text))
+	run = 0
+	for i, w := range words {
+		if i > 0 && w == words[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		if run > limit {
+			return strings.Join(words[i-run+1:i+1], " ")
+		}
+	}
+	return ""
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
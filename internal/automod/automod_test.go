@@ -0,0 +1,267 @@
+package automod
+
+import (
+	"errors"
+	"forum/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store that remembers what Apply did.
+type memStore struct {
+	rules      []*models.AutomodRule
+	users      map[int]*models.User
+	duplicates int
+	approved   int
+	matches    []*models.AutomodMatch
+	reports    []string
+	pending    []int
+	// rulesErr and duplicatesErr make the lookups fail
+	rulesErr      error
+	duplicatesErr error
+}
+
+func (s *memStore) GetAutomodRules() ([]*models.AutomodRule, error) { return s.rules, s.rulesErr }
+
+func (s *memStore) GetUserByID(id int) (*models.User, error) { return s.users[id], nil }
+
+func (s *memStore) CountApprovedContent(userID int) (int, error) { return s.approved, nil }
+
+func (s *memStore) CountDuplicateContent(userID int, content string, since time.Time, targetType string, targetID int) (int, error) {
+	return s.duplicates, s.duplicatesErr
+}
+
+func (s *memStore) RecordAutomodMatch(m *models.AutomodMatch) error {
+	s.matches = append(s.matches, m)
+	return nil
+}
+
+func (s *memStore) CreateReport(reporterID int, postID, commentID *int, reason string) error {
+	s.reports = append(s.reports, reason)
+	return nil
+}
+
+func (s *memStore) SetPostVisibility(postID int, visibility string) error {
+	s.pending = append(s.pending, postID)
+	return nil
+}
+
+func (s *memStore) SetCommentVisibility(commentID int, visibility string) error {
+	s.pending = append(s.pending, commentID)
+	return nil
+}
+
+func newStore(rules ...*models.AutomodRule) *memStore {
+	now := time.Now()
+	for i, r := range rules {
+		r.ID, r.Enabled, r.CreatedBy = i+1, true, 100
+	}
+	return &memStore{rules: rules, users: map[int]*models.User{
+		1: {ID: 1, Username: "newbie", CreatedAt: now.Add(-time.Hour)},
+		2: {ID: 2, Username: "veteran", CreatedAt: now.AddDate(-1, 0, 0)},
+	}}
+}
+
+func TestRuleKinds(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  *models.AutomodRule
+		text  string
+		match bool
+	}{
+		{"слово", &models.AutomodRule{Kind: KindWords, Pattern: "casino, viagra"}, "Best Casino in town", true},
+		{"часть слова", &models.AutomodRule{Kind: KindWords, Pattern: "casino"}, "casinos are fun", false},
+		{"кириллица", &models.AutomodRule{Kind: KindWords, Pattern: "казино\nставки"}, "Лучшее КАЗИНО!", true},
+		{"фраза", &models.AutomodRule{Kind: KindWords, Pattern: "buy now"}, "please buy now!!!", true},
+		{"regex", &models.AutomodRule{Kind: KindRegex, Pattern: `(?i)t\.me/\w+`}, "join T.me/spamchannel", true},
+		{"ссылки в пределах", &models.AutomodRule{Kind: KindLinks, Threshold: 2}, "https://a.example and www.b.example", false},
+		{"много ссылок", &models.AutomodRule{Kind: KindLinks, Threshold: 1}, "https://a.example and www.b.example", true},
+		{"капс", &models.AutomodRule{Kind: KindCaps, Threshold: 70}, "THIS IS THE BEST FORUM EVER SERIOUSLY", true},
+		{"короткий капс", &models.AutomodRule{Kind: KindCaps, Threshold: 70}, "OK LOL", false},
+		{"обычный текст", &models.AutomodRule{Kind: KindCaps, Threshold: 70}, "This is an ordinary sentence with Names", false},
+		{"повтор символа", &models.AutomodRule{Kind: KindRepetition, Threshold: 5}, "nooooooo way", true},
+		{"повтор слова", &models.AutomodRule{Kind: KindRepetition, Threshold: 3}, "spam spam Spam spam", true},
+		{"пробелы не повтор", &models.AutomodRule{Kind: KindRepetition, Threshold: 3}, "a      b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Action = tt.name, ActionReport
+			if err := Validate(tt.rule); err != nil {
+				t.Fatalf("Правило не прошло проверку: %v", err)
+			}
+			v, err := New(newStore(tt.rule), Premoderation{}).Check(Content{Type: models.RevisionComment, AuthorID: 1, Text: tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(v.Matches) == 1; got != tt.match {
+				t.Errorf("Совпадение %v, ожидалось %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestStrictestActionWinsAndApply(t *testing.T) {
+	store := newStore(
+		&models.AutomodRule{Name: "Ссылки", Kind: KindLinks, Threshold: 0, Action: ActionReport},
+		&models.AutomodRule{Name: "Спам", Kind: KindWords, Pattern: "casino", Action: ActionHold},
+		&models.AutomodRule{Name: "Новички", Kind: KindLinks, Threshold: 0, Action: ActionReject, MaxAccountAgeDays: 7},
+	)
+	engine := New(store, Premoderation{})
+
+	// Старому аккаунту правило для новичков не применяется
+	v, err := engine.Check(Content{Type: models.RevisionPost, AuthorID: 2, Title: "Hello", Text: "casino at https://x.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) != 2 || !v.Held() || v.Visibility() != models.VisibilityPending {
+		t.Fatalf("Ожидалось удержание по 2 правилам, получено %s (%d)", v.Action(), len(v.Matches))
+	}
+	if err := engine.Apply(v, 42); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.matches) != 2 || *store.matches[0].TargetID != 42 || store.matches[0].Action != ActionHold {
+		t.Errorf("Неверный журнал совпадений: %+v", store.matches)
+	}
+	if len(store.pending) != 1 || len(store.reports) != 0 {
+		t.Errorf("Ожидалась отправка в очередь без жалобы: %v, %v", store.pending, store.reports)
+	}
+
+	v, _ = engine.Check(Content{Type: models.RevisionPost, AuthorID: 1, Title: "Hello", Text: "see https://x.example"})
+	if !v.Rejected() {
+		t.Fatalf("Ожидался отказ, получено %q", v.Action())
+	}
+	store.matches, store.reports = nil, nil
+	if err := engine.Apply(v, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.matches) != 2 || store.matches[0].TargetID != nil || len(store.reports) != 0 {
+		t.Errorf("Отказ должен только попасть в журнал: %+v, %v", store.matches, store.reports)
+	}
+
+	// Отключённые правила не применяются
+	for _, r := range store.rules {
+		r.Enabled = false
+	}
+	if v, _ := engine.Check(Content{Type: models.RevisionPost, AuthorID: 1, Text: "casino https://x.example"}); len(v.Matches) != 0 || v.Action() != "" {
+		t.Errorf("Сработало отключённое правило: %s", v.Action())
+	}
+}
+
+func TestDuplicateRule(t *testing.T) {
+	store := newStore(&models.AutomodRule{Name: "Дубли", Kind: KindDuplicate, Threshold: 24, Action: ActionReject})
+	engine := New(store, Premoderation{})
+	if v, _ := engine.Check(Content{Type: models.RevisionComment, AuthorID: 1, Text: "first!"}); len(v.Matches) != 0 {
+		t.Errorf("Первое сообщение не дубль")
+	}
+	store.duplicates = 2
+	if v, _ := engine.Check(Content{Type: models.RevisionComment, AuthorID: 1, Text: "first!"}); !v.Rejected() {
+		t.Errorf("Дубль не отклонён")
+	}
+}
+
+func TestReportRule(t *testing.T) {
+	store := newStore(&models.AutomodRule{Name: "Ссылки", Kind: KindLinks, Threshold: 0, Action: ActionReport})
+	engine := New(store, Premoderation{})
+	v, _ := engine.Check(Content{Type: models.RevisionComment, AuthorID: 2, Text: "https://x.example"})
+	if v.Queued() || v.Visibility() != models.VisibilityVisible {
+		t.Fatalf("Жалоба не должна задерживать комментарий")
+	}
+	if err := engine.Apply(v, 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.reports) != 1 || !strings.Contains(store.reports[0], `"Ссылки"`) || len(store.pending) != 0 {
+		t.Errorf("Ожидалась жалоба: %v, %v", store.reports, store.pending)
+	}
+}
+
+func TestPremoderation(t *testing.T) {
+	store := newStore()
+	engine := New(store, Premoderation{AccountAge: 24 * time.Hour, MinApproved: 3})
+
+	v, err := engine.Check(Content{Type: models.RevisionPost, AuthorID: 1, Title: "Hi", Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Premoderated || !v.Queued() || v.Visibility() != models.VisibilityPending {
+		t.Errorf("Новый аккаунт должен проходить премодерацию")
+	}
+	if v, _ := engine.Check(Content{Type: models.RevisionPost, AuthorID: 2, Text: "hello"}); !v.Premoderated {
+		t.Errorf("Мало одобренных сообщений, ожидалась премодерация")
+	}
+	store.approved = 3
+	if v, _ := engine.Check(Content{Type: models.RevisionPost, AuthorID: 2, Text: "hello"}); v.Premoderated {
+		t.Errorf("Опытный пользователь не должен проходить премодерацию")
+	}
+	// Правки уже опубликованного не премодерируются
+	if v, _ := engine.Check(Content{Type: models.RevisionPost, ID: 5, AuthorID: 1, Text: "edited"}); v.Premoderated {
+		t.Errorf("Правка не должна проходить премодерацию")
+	}
+	if v, _ := New(store, Premoderation{}).Check(Content{Type: models.RevisionPost, AuthorID: 1, Text: "hello"}); v.Premoderated {
+		t.Errorf("Премодерация выключена")
+	}
+}
+
+// Content that cannot be checked is held for a moderator, new or edited,
+// and premoderation is still decided when only the rules fail to load.
+func TestCheckFailsClosed(t *testing.T) {
+	errStore := errors.New("database is locked")
+
+	store := newStore()
+	store.rulesErr = errStore
+	engine := New(store, Premoderation{AccountAge: 24 * time.Hour})
+	v, err := engine.Check(Content{Type: models.RevisionPost, AuthorID: 1, Text: "hello"})
+	if !errors.Is(err, errStore) {
+		t.Fatalf("Ожидалась ошибка хранилища, получено %v", err)
+	}
+	if !v.Unchecked || !v.Premoderated || !v.Held() || v.Visibility() != models.VisibilityPending {
+		t.Errorf("Непроверенный пост нового аккаунта должен ждать модератора: %+v", v)
+	}
+	v, _ = New(store, Premoderation{}).Check(Content{Type: models.RevisionComment, AuthorID: 2, Text: "hello"})
+	if !v.Unchecked || v.Visibility() != models.VisibilityPending {
+		t.Errorf("Непроверенный комментарий опубликован: %+v", v)
+	}
+
+	// A rule that fails during an edit sends the edited post back to the queue
+	store = newStore(&models.AutomodRule{Kind: KindDuplicate, Threshold: 24, Action: ActionReport})
+	store.duplicatesErr = errStore
+	engine = New(store, Premoderation{})
+	v, err = engine.Check(Content{Type: models.RevisionPost, ID: 7, AuthorID: 2, Text: "edited"})
+	if err == nil || !v.Held() {
+		t.Fatalf("Правка с ошибкой правила должна быть задержана: %+v, %v", v, err)
+	}
+	if err := engine.Apply(v, 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.pending) != 1 || store.pending[0] != 7 || len(store.reports) != 0 {
+		t.Errorf("Правка не отправлена в очередь: %v, жалобы %v", store.pending, store.reports)
+	}
+
+	// A reject rule that matched before the failure still rejects
+	store = newStore(
+		&models.AutomodRule{Kind: KindWords, Pattern: "casino", Action: ActionReject},
+		&models.AutomodRule{Kind: KindDuplicate, Threshold: 24, Action: ActionHold},
+	)
+	store.duplicatesErr = errStore
+	v, _ = New(store, Premoderation{}).Check(Content{Type: models.RevisionPost, AuthorID: 2, Text: "casino"})
+	if !v.Rejected() || v.Held() {
+		t.Errorf("Отклонённое содержимое должно остаться отклонённым: %+v", v)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []*models.AutomodRule{
+		{Name: "", Kind: KindWords, Pattern: "x", Action: ActionReject},
+		{Name: "a", Kind: "magic", Action: ActionReject},
+		{Name: "a", Kind: KindWords, Pattern: " , ", Action: ActionReject},
+		{Name: "a", Kind: KindRegex, Pattern: "(unclosed", Action: ActionReject},
+		{Name: "a", Kind: KindCaps, Threshold: 100, Action: ActionReject},
+		{Name: "a", Kind: KindDuplicate, Threshold: 0, Action: ActionReject},
+		{Name: "a", Kind: KindLinks, Threshold: 1, Action: "ban"},
+	}
+	for _, r := range bad {
+		if Validate(r) == nil {
+			t.Errorf("Правило %+v должно быть отклонено", r)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config хранит конфигурацию приложения.
@@ -10,6 +13,69 @@ type Config struct {
 	Port        string
 	DBPath      string
 	ProjectRoot string
+	// CommentMaxDepth ограничивает вложенность ответов при отображении поста.
+	CommentMaxDepth int
+	// BaseURL — внешний адрес форума, используется в ссылках в письмах.
+	BaseURL string
+	// Mail — настройки исходящей почты.
+	Mail MailConfig
+	// SessionTTL — срок жизни обычной сессии, RememberMeTTL — с флажком «Запомнить меня».
+	SessionTTL    time.Duration
+	RememberMeTTL time.Duration
+	// TwoFactorRoles — роли, которым для своих прав нужна двухфакторная аутентификация.
+	TwoFactorRoles []string
+	// Security — заголовки безопасности и атрибуты cookie.
+	Security SecurityConfig
+	// Premoderation — чьи посты и комментарии ждут одобрения модератора.
+	Premoderation PremoderationConfig
+	// TrashRetention — сколько удалённые посты и комментарии хранятся в
+	// корзине, прежде чем будут удалены окончательно.
+	TrashRetention time.Duration
+}
+
+// PremoderationConfig задаёт очередь модерации для новых пользователей.
+// Пользователь проходит премодерацию, пока его аккаунт моложе AccountAge или
+// у него меньше MinApproved одобренных постов и комментариев. Нулевое
+// значение отключает условие.
+type PremoderationConfig struct {
+	AccountAge  time.Duration
+	MinApproved int
+}
+
+// SecurityConfig хранит заголовки безопасности. Пустая строка отключает
+// соответствующий заголовок.
+type SecurityConfig struct {
+	// ContentSecurityPolicy — политика CSP; {nonce} заменяется одноразовым
+	// значением запроса для встроенных скриптов.
+	ContentSecurityPolicy string
+	// FrameAncestors — значение директивы frame-ancestors, например 'none' или 'self'.
+	FrameAncestors string
+	// HSTS — значение Strict-Transport-Security.
+	HSTS           string
+	ReferrerPolicy string
+	// NoSniff включает X-Content-Type-Options: nosniff.
+	NoSniff bool
+	// SecureCookies ставит cookie флаг Secure; включите, если сайт работает по HTTPS.
+	SecureCookies bool
+}
+
+// DefaultContentSecurityPolicy разрешает скрипты только с сайта, из
+// jsDelivr и с одноразовым nonce. Стили допускают inline, так как в шаблонах
+// есть атрибуты style.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}' https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com https://fonts.googleapis.com; " +
+	"font-src 'self' https://cdn.jsdelivr.net https://fonts.gstatic.com; " +
+	"img-src 'self' data:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// MailConfig хранит настройки SMTP. Если SMTPHost пуст, письма не
+// отправляются, а пишутся в лог.
+type MailConfig struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	From         string
 }
 
 // Load загружает конфигурацию из переменных окружения или использует значения по умолчанию.
@@ -36,11 +102,38 @@ func Load() *Config {
 	}
 
 	absDBPath := filepath.Join(projectRoot, "forum.db")
+	baseURL := strings.TrimSuffix(getEnv("BASE_URL", "http://localhost:8080"), "/")
 
 	return &Config{
 		Port:        getEnv("PORT", "8080"),
 		DBPath:      getEnv("DB_PATH", absDBPath),
 		ProjectRoot: projectRoot,
+
+		CommentMaxDepth: getEnvInt("COMMENT_MAX_DEPTH", 5),
+		BaseURL:         baseURL,
+		Mail: MailConfig{
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("MAIL_FROM", "Klondike Developers <noreply@localhost>"),
+		},
+		SessionTTL:     getEnvDuration("SESSION_TTL", 24*time.Hour),
+		RememberMeTTL:  getEnvDuration("REMEMBER_ME_TTL", 30*24*time.Hour),
+		TwoFactorRoles: getEnvList("REQUIRE_2FA_ROLES"),
+		Security: SecurityConfig{
+			ContentSecurityPolicy: getEnv("CONTENT_SECURITY_POLICY", DefaultContentSecurityPolicy),
+			FrameAncestors:        getEnv("FRAME_ANCESTORS", "'none'"),
+			HSTS:                  getEnv("HSTS", "max-age=31536000; includeSubDomains"),
+			ReferrerPolicy:        getEnv("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+			NoSniff:               getEnvBool("NOSNIFF", true),
+			SecureCookies:         getEnvBool("COOKIE_SECURE", strings.HasPrefix(baseURL, "https://")),
+		},
+		Premoderation: PremoderationConfig{
+			AccountAge:  getEnvDuration("PREMOD_ACCOUNT_AGE", 0),
+			MinApproved: getEnvInt("PREMOD_MIN_APPROVED", 0),
+		},
+		TrashRetention: getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
 	}
 }
 
@@ -51,3 +144,44 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt получает целое значение переменной окружения или возвращает значение по умолчанию.
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBool получает логическое значение ("true", "false", "1", "0") или возвращает значение по умолчанию.
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration получает длительность (например, "12h") или возвращает значение по умолчанию.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList получает список значений через запятую; пустые элементы пропускаются.
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
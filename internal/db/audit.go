@@ -0,0 +1,69 @@
+package db
+
+import (
+	"forum/internal/models"
+	"time"
+)
+
+// RecordAudit appends an entry to the audit log.
+func (r *Repository) RecordAudit(e *models.AuditEntry) error {
+	now := time.Now().UTC()
+	res, err := r.db.Exec(`INSERT INTO audit_log (actor_id, action, target_type, target_id, before, after, ip, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ActorID, e.Action, e.TargetType, e.TargetID, e.Before, e.After, e.IP, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	e.ID = int(id)
+	e.CreatedAt = now
+	return nil
+}
+
+// ListAuditLog returns the audit log entries matching q, newest first, and
+// the total number of matches.
+func (r *Repository) ListAuditLog(q models.AuditQuery) ([]*models.AuditEntry, int, error) {
+	where := ` WHERE 1 = 1`
+	var args []interface{}
+	if q.Actor != "" {
+		where += ` AND u.username = ?`
+		args = append(args, q.Actor)
+	}
+	if q.Action != "" {
+		where += ` AND a.action = ?`
+		args = append(args, q.Action)
+	}
+	if q.From != nil {
+		where += ` AND a.created_at >= ?`
+		args = append(args, *q.From)
+	}
+	if q.To != nil {
+		where += ` AND a.created_at < ?`
+		args = append(args, *q.To)
+	}
+	from := ` FROM audit_log a LEFT JOIN users u ON u.id = a.actor_id`
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*)`+from+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(`SELECT a.id, a.actor_id, COALESCE(u.username, ''), a.action, a.target_type, a.target_id,
+                             a.before, a.after, a.ip, a.created_at`+from+where+` ORDER BY a.id DESC LIMIT ? OFFSET ?`,
+		append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		e := &models.AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID,
+			&e.Before, &e.After, &e.IP, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+const automodRuleColumns = `id, name, kind, pattern, threshold, max_account_age_days, action, enabled, created_by, created_at, updated_at`
+
+// CreateAutomodRule saves a new rule and sets its ID.
+func (r *Repository) CreateAutomodRule(rule *models.AutomodRule) error {
+	now := time.Now()
+	res, err := r.db.Exec(`INSERT INTO automod_rules (name, kind, pattern, threshold, max_account_age_days, action, enabled, created_by, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Kind, rule.Pattern, rule.Threshold, rule.MaxAccountAgeDays, rule.Action, rule.Enabled, rule.CreatedBy, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	rule.ID = int(id)
+	rule.CreatedAt = now
+	return nil
+}
+
+// UpdateAutomodRule saves changes to a rule. The author is kept.
+func (r *Repository) UpdateAutomodRule(rule *models.AutomodRule) error {
+	_, err := r.db.Exec(`UPDATE automod_rules SET name = ?, kind = ?, pattern = ?, threshold = ?, max_account_age_days = ?,
+                         action = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		rule.Name, rule.Kind, rule.Pattern, rule.Threshold, rule.MaxAccountAgeDays, rule.Action, rule.Enabled, time.Now(), rule.ID)
+	return err
+}
+
+// DeleteAutomodRule removes a rule. Its matches stay in the log under the
+// rule's name.
+func (r *Repository) DeleteAutomodRule(ruleID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE automod_matches SET rule_id = NULL WHERE rule_id = ?", ruleID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM automod_rules WHERE id = ?", ruleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAutomodRuleByID returns a rule by ID.
+func (r *Repository) GetAutomodRuleByID(ruleID int) (*models.AutomodRule, error) {
+	return scanAutomodRule(r.db.QueryRow(`SELECT `+automodRuleColumns+` FROM automod_rules WHERE id = ?`, ruleID))
+}
+
+// GetAutomodRules returns all rules, enabled or not, in the order they were
+// created.
+func (r *Repository) GetAutomodRules() ([]*models.AutomodRule, error) {
+	rows, err := r.db.Query(`SELECT ` + automodRuleColumns + ` FROM automod_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*models.AutomodRule
+	for rows.Next() {
+		rule, err := scanAutomodRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func scanAutomodRule(row interface{ Scan(...interface{}) error }) (*models.AutomodRule, error) {
+	rule := &models.AutomodRule{}
+	var updatedAt sql.NullTime
+	if err := row.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Pattern, &rule.Threshold, &rule.MaxAccountAgeDays,
+		&rule.Action, &rule.Enabled, &rule.CreatedBy, &rule.CreatedAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if updatedAt.Valid {
+		rule.UpdatedAt = &updatedAt.Time
+	}
+	return rule, nil
+}
+
+// RecordAutomodMatch adds a match to the log.
+func (r *Repository) RecordAutomodMatch(m *models.AutomodMatch) error {
+	now := time.Now()
+	res, err := r.db.Exec(`INSERT INTO automod_matches (rule_id, rule_name, user_id, target_type, target_id, action, excerpt, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.RuleID, m.RuleName, m.UserID, m.TargetType, m.TargetID, m.Action, m.Excerpt, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	m.ID = int(id)
+	m.CreatedAt = now
+	return nil
+}
+
+// GetAutomodMatches returns a page of the match log, newest first.
+func (r *Repository) GetAutomodMatches(limit, offset int) ([]*models.AutomodMatch, error) {
+	rows, err := r.db.Query(`SELECT m.id, m.rule_id, m.rule_name, m.user_id, COALESCE(u.username, ''), m.target_type, m.target_id,
+                             m.action, m.excerpt, m.created_at
+                             FROM automod_matches m LEFT JOIN users u ON u.id = m.user_id
+                             ORDER BY m.id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []*models.AutomodMatch
+	for rows.Next() {
+		m := &models.AutomodMatch{}
+		var ruleID, targetID sql.NullInt64
+		if err := rows.Scan(&m.ID, &ruleID, &m.RuleName, &m.UserID, &m.Username, &m.TargetType, &targetID,
+			&m.Action, &m.Excerpt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if ruleID.Valid {
+			id := int(ruleID.Int64)
+			m.RuleID = &id
+		}
+		if targetID.Valid {
+			id := int(targetID.Int64)
+			m.TargetID = &id
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// CountDuplicateContent counts the user's posts and comments created since
+// the given time whose text equals content, ignoring case and surrounding
+// space. The post or comment being edited, if any, is skipped.
+func (r *Repository) CountDuplicateContent(userID int, content string, since time.Time, targetType string, targetID int) (int, error) {
+	content = strings.ToLower(strings.TrimSpace(content))
+	excludePost, excludeComment := 0, 0
+	if targetType == models.RevisionPost {
+		excludePost = targetID
+	} else {
+		excludeComment = targetID
+	}
+	var n int
+	err := r.db.QueryRow(`SELECT
+                              (SELECT COUNT(*) FROM posts WHERE user_id = ? AND created_at > ? AND id != ? AND LOWER(TRIM(content)) = ?) +
+                              (SELECT COUNT(*) FROM comments WHERE user_id = ? AND created_at > ? AND id != ? AND LOWER(TRIM(content)) = ?)`,
+		userID, since, excludePost, content, userID, since, excludeComment, content).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"time"
+)
+
+const banColumns = `b.id, b.user_id, COALESCE(u.username, ''), b.reason, b.issued_by, COALESCE(i.username, ''),
+                    b.created_at, b.expires_at, b.lifted_at, b.lifted_by
+                    FROM bans b LEFT JOIN users u ON u.id = b.user_id LEFT JOIN users i ON i.id = b.issued_by`
+
+// CreateBan bans a user. Any ban already in force is ended first, so a user
+// has at most one active ban.
+func (r *Repository) CreateBan(ban *models.Ban) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE bans SET lifted_at = ?, lifted_by = ?
+                          WHERE user_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+		now, ban.IssuedBy, ban.UserID, now); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT INTO bans (user_id, reason, issued_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		ban.UserID, ban.Reason, ban.IssuedBy, now, ban.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	ban.ID = int(id)
+	ban.CreatedAt = now
+	return tx.Commit()
+}
+
+// GetActiveBan returns the ban in force for the user, or nil if there is
+// none.
+func (r *Repository) GetActiveBan(userID int) (*models.Ban, error) {
+	now := time.Now()
+	ban, err := scanBan(r.db.QueryRow(`SELECT `+banColumns+`
+                                       WHERE b.user_id = ? AND b.lifted_at IS NULL AND (b.expires_at IS NULL OR b.expires_at > ?)
+                                       ORDER BY b.id DESC LIMIT 1`, userID, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return ban, err
+}
+
+// GetBanByID returns a ban by ID.
+func (r *Repository) GetBanByID(banID int) (*models.Ban, error) {
+	return scanBan(r.db.QueryRow(`SELECT `+banColumns+` WHERE b.id = ?`, banID))
+}
+
+// ListActiveBans returns the bans in force, newest first.
+func (r *Repository) ListActiveBans() ([]*models.Ban, error) {
+	return r.queryBans(`SELECT `+banColumns+`
+                        WHERE b.lifted_at IS NULL AND (b.expires_at IS NULL OR b.expires_at > ?)
+                        ORDER BY b.id DESC`, time.Now())
+}
+
+// GetBansByUser returns every ban a user has received, newest first.
+func (r *Repository) GetBansByUser(userID int) ([]*models.Ban, error) {
+	return r.queryBans(`SELECT `+banColumns+` WHERE b.user_id = ? ORDER BY b.id DESC`, userID)
+}
+
+// LiftBan ends a ban before it expires.
+func (r *Repository) LiftBan(banID, liftedBy int) error {
+	_, err := r.db.Exec("UPDATE bans SET lifted_at = ?, lifted_by = ? WHERE id = ? AND lifted_at IS NULL", time.Now(), liftedBy, banID)
+	return err
+}
+
+func (r *Repository) queryBans(query string, args ...interface{}) ([]*models.Ban, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*models.Ban
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func scanBan(row interface{ Scan(...interface{}) error }) (*models.Ban, error) {
+	b := &models.Ban{}
+	err := row.Scan(&b.ID, &b.UserID, &b.Username, &b.Reason, &b.IssuedBy, &b.IssuedByName,
+		&b.CreatedAt, &b.ExpiresAt, &b.LiftedAt, &b.LiftedBy)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
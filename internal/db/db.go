@@ -4,26 +4,46 @@ import (
 	"database/sql"
 	"forum/internal/config"
 	"forum/internal/models"
+	"forum/internal/pubsub"
 	"log"
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// driverName is go-sqlite3 with the SQL functions the repository's queries
+// need registered on every connection.
+const driverName = "sqlite3_forum"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("fts4_rank", fts4Rank, true)
+		},
+	})
+}
+
 // Repository provides methods for working with the database.
 type Repository struct {
-	db *sql.DB
+	db  *sql.DB
+	hub *pubsub.Hub
 }
 
 // NewRepository creates a new repository.
 func NewRepository(cfg *config.Config) (*Repository, error) {
-	db, err := sql.Open("sqlite3", cfg.DBPath)
+	db, err := sql.Open(driverName, cfg.DBPath)
 	if err != nil {
 		return nil, err
 	}
-	return &Repository{db: db}, nil
+	return &Repository{db: db, hub: pubsub.NewHub()}, nil
+}
+
+// NotificationHub returns the hub that new notifications and unread counts
+// are published to.
+func (r *Repository) NotificationHub() *pubsub.Hub {
+	return r.hub
 }
 
 // Close closes the database connection.
@@ -42,9 +62,14 @@ func (r *Repository) CreateUser(user *models.User, plainPassword string) error {
 	}
 	user.PasswordHash = string(hash)
 	log.Println("Was")
-	_, err = r.db.Exec("INSERT INTO users (email, username, password_hash) VALUES (?, ?, ?)",
+	res, err := r.db.Exec("INSERT INTO users (email, username, password_hash) VALUES (?, ?, ?)",
 		user.Email, user.Username, user.PasswordHash)
-	return err
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	user.ID = int(id)
+	return nil
 }
 
 // IsEmailOrUsernameTaken checks if an email or username is already taken.
@@ -63,8 +88,8 @@ func (r *Repository) IsEmailOrUsernameTaken(email, username string) (bool, error
 func (r *Repository) GetUserByEmail(email string) (*models.User, error) {
 	email = strings.ToLower(email)
 	user := &models.User{}
-	err := r.db.QueryRow("SELECT id, email, username, password_hash, role, created_at FROM users WHERE email = ?", email).
-		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	err := r.db.QueryRow("SELECT id, email, username, password_hash, role, created_at, email_verified_at, COALESCE(totp_secret, ''), totp_enabled_at FROM users WHERE email = ?", email).
+		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.EmailVerifiedAt, &user.TOTPSecret, &user.TOTPEnabledAt)
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +99,8 @@ func (r *Repository) GetUserByEmail(email string) (*models.User, error) {
 // GetUserByID retrieves a user by ID.
 func (r *Repository) GetUserByID(userID int) (*models.User, error) {
 	user := &models.User{}
-	err := r.db.QueryRow("SELECT id, email, username, password_hash, role, created_at FROM users WHERE id = ?", userID).
-		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	err := r.db.QueryRow("SELECT id, email, username, password_hash, role, created_at, email_verified_at, COALESCE(totp_secret, ''), totp_enabled_at FROM users WHERE id = ?", userID).
+		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.EmailVerifiedAt, &user.TOTPSecret, &user.TOTPEnabledAt)
 	if err != nil {
 		return nil, err
 	}
@@ -86,8 +111,8 @@ func (r *Repository) GetUserByID(userID int) (*models.User, error) {
 func (r *Repository) GetUserByUsername(username string) (*models.User, error) {
 	username = strings.ToLower(username)
 	user := &models.User{}
-	err := r.db.QueryRow("SELECT id, email, username, password_hash FROM users WHERE username = ?", username).
-		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash)
+	err := r.db.QueryRow("SELECT id, email, username, password_hash, role, created_at, email_verified_at, COALESCE(totp_secret, ''), totp_enabled_at FROM users WHERE username = ?", username).
+		Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.EmailVerifiedAt, &user.TOTPSecret, &user.TOTPEnabledAt)
 	if err != nil {
 		return nil, err
 	}
@@ -96,48 +121,111 @@ func (r *Repository) GetUserByUsername(username string) (*models.User, error) {
 
 // CreateSession creates a new session.
 func (r *Repository) CreateSession(session *models.Session) error {
-	_, err := r.db.Exec("INSERT INTO sessions (session_id, user_id, expires) VALUES (?, ?, ?)",
-		session.SessionID, session.UserID, session.Expires)
-	return err
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	res, err := r.db.Exec("INSERT INTO sessions (session_id, user_id, expires, user_agent, ip, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.SessionID, session.UserID, session.Expires, session.UserAgent, session.IP, now, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	session.ID = int(id)
+	return nil
 }
 
 // GetSession retrieves a session by ID.
 func (r *Repository) GetSession(sessionID string) (*models.Session, error) {
 	session := &models.Session{}
-	err := r.db.QueryRow("SELECT session_id, user_id, expires FROM sessions WHERE session_id = ? AND expires > ?",
-		sessionID, time.Now()).Scan(&session.SessionID, &session.UserID, &session.Expires)
+	err := r.db.QueryRow("SELECT id, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at FROM sessions WHERE session_id = ? AND expires > ?",
+		sessionID, time.Now()).Scan(&session.ID, &session.SessionID, &session.UserID, &session.Expires,
+		&session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
 	if err != nil {
 		return nil, err
 	}
 	return session, nil
 }
 
+// GetUserSessions lists the user's active sessions, most recently used first.
+func (r *Repository) GetUserSessions(userID int) ([]*models.Session, error) {
+	rows, err := r.db.Query(`SELECT id, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at
+                             FROM sessions WHERE user_id = ? AND expires > ? ORDER BY last_seen_at DESC`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		s := &models.Session{}
+		if err := rows.Scan(&s.ID, &s.SessionID, &s.UserID, &s.Expires, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that the session was just used.
+func (r *Repository) TouchSession(sessionID string) error {
+	_, err := r.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE session_id = ?", time.Now(), sessionID)
+	return err
+}
+
 // DeleteSession deletes a session.
 func (r *Repository) DeleteSession(sessionID string) error {
 	_, err := r.db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
 	return err
 }
 
+// DeleteUserSessionByID revokes one of the user's sessions by its public ID.
+func (r *Repository) DeleteUserSessionByID(userID, id int) error {
+	_, err := r.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
 // DeleteUserSessions deletes all user sessions except the current one
 func (r *Repository) DeleteUserSessions(userID int, exceptSessionID string) error {
 	_, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND session_id != ?", userID, exceptSessionID)
 	return err
 }
 
+// DeleteAllUserSessions deletes every session of the user, signing them out
+// everywhere.
+func (r *Repository) DeleteAllUserSessions(userID int) error {
+	_, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
 // CleanExpiredSessions deletes all expired sessions
 func (r *Repository) CleanExpiredSessions() error {
 	_, err := r.db.Exec("DELETE FROM sessions WHERE expires < ?", time.Now())
 	return err
 }
 
-// CreatePost creates a new post.
+// CreatePost creates a new post and records its first revision.
 func (r *Repository) CreatePost(post *models.Post) (int64, error) {
-	result, err := r.db.Exec("INSERT INTO posts (user_id, title, content, created_at) VALUES (?, ?, ?, ?)",
-		post.UserID, post.Title, post.Content, time.Now())
+	html := r.renderContent(post.Content)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("INSERT INTO posts (user_id, title, content, created_at, visibility) VALUES (?, ?, ?, ?, ?)",
+		post.UserID, post.Title, post.Content, time.Now(), visibilityOrDefault(post.Visibility))
 	if err != nil {
+		tx.Rollback()
 		return 0, err
 	}
-	return result.LastInsertId()
+	postID, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := addRevision(tx, models.RevisionPost, int(postID), &post.Title, post.Content, html, post.UserID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return postID, tx.Commit()
 }
 
 // AddPostCategory links a post to a category.
@@ -148,22 +236,33 @@ func (r *Repository) AddPostCategory(postID, categoryID int) error {
 
 // GetPosts returns a list of posts with filtering.
 func (r *Repository) GetPosts(categoryID, sortBy string) ([]*models.Post, error) {
-	query := `SELECT p.id, p.user_id, p.title, p.content, p.created_at FROM posts p`
+	query := `SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.visibility,
+              p.locked, p.pinned_at, p.pinned_category_id, p.announced_at FROM posts p`
+	where := ` WHERE p.visibility = 'visible' AND p.deleted_at IS NULL`
 	var args []interface{}
 
+	// Pinned posts come first, most recently pinned on top: those pinned
+	// everywhere, and on a category page those pinned in that category.
+	pinned := `CASE WHEN p.pinned_at IS NOT NULL AND p.pinned_category_id IS NULL THEN p.pinned_at END DESC`
+	var pinArgs []interface{}
 	if categoryID != "" {
-		query += ` JOIN post_categories pc ON p.id = pc.post_id WHERE pc.category_id = ?`
+		query += ` JOIN post_categories pc ON p.id = pc.post_id`
+		where += ` AND pc.category_id = ?`
 		args = append(args, categoryID)
+		pinned = `CASE WHEN p.pinned_at IS NOT NULL AND (p.pinned_category_id IS NULL OR p.pinned_category_id = ?) THEN p.pinned_at END DESC`
+		pinArgs = append(pinArgs, categoryID)
 	}
 
 	if sortBy == "date" || sortBy == "" {
-		query += ` ORDER BY p.created_at DESC`
+		query += where + ` ORDER BY ` + pinned + `, p.created_at DESC`
 	} else if sortBy == "likes" {
-		query += ` LEFT JOIN likes l ON p.id = l.post_id
-                   GROUP BY p.id ORDER BY COUNT(CASE WHEN l.is_like = 1 THEN 1 END) DESC`
+		query += ` LEFT JOIN likes l ON p.id = l.post_id` + where + `
+                   GROUP BY p.id ORDER BY ` + pinned + `, COUNT(CASE WHEN l.is_like = 1 THEN 1 END) DESC`
+	} else {
+		query += where + ` ORDER BY ` + pinned
 	}
 
-	rows, err := r.db.Query(query, args...)
+	rows, err := r.db.Query(query, append(args, pinArgs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -172,7 +271,8 @@ func (r *Repository) GetPosts(categoryID, sortBy string) ([]*models.Post, error)
 	var posts []*models.Post
 	for rows.Next() {
 		post := &models.Post{}
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt, &post.Visibility,
+			&post.Locked, &post.PinnedAt, &post.PinnedCategoryID, &post.AnnouncedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -184,9 +284,11 @@ func (r *Repository) GetPosts(categoryID, sortBy string) ([]*models.Post, error)
 // GetPostByID retrieves a post by ID.
 func (r *Repository) GetPostByID(postID int) (*models.Post, error) {
 	post := &models.Post{}
-	err := r.db.QueryRow(`SELECT p.id, p.user_id, p.title, p.content, p.created_at
-                          FROM posts p WHERE p.id = ?`, postID).
-		Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
+	err := r.db.QueryRow(`SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.visibility,
+                          p.locked, p.pinned_at, p.pinned_category_id, p.announced_at
+                          FROM posts p WHERE p.id = ? AND p.deleted_at IS NULL`, postID).
+		Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt, &post.Visibility,
+			&post.Locked, &post.PinnedAt, &post.PinnedCategoryID, &post.AnnouncedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -241,16 +343,41 @@ func (r *Repository) GetCommentLikesDislikes(commentID int) (likes, dislikes int
 	return
 }
 
-// CreateComment creates a new comment.
+// CreateComment creates a new comment (or a reply when ParentID is set), records
+// its first revision and stores its ID in comment.ID.
 func (r *Repository) CreateComment(comment *models.Comment) error {
-	_, err := r.db.Exec("INSERT INTO comments (post_id, user_id, content, created_at) VALUES (?, ?, ?, ?)",
-		comment.PostID, comment.UserID, comment.Content, time.Now())
-	return err
+	html := r.renderContent(comment.Content)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO comments (post_id, user_id, parent_id, content, created_at, visibility) VALUES (?, ?, ?, ?, ?, ?)",
+		comment.PostID, comment.UserID, comment.ParentID, comment.Content, time.Now(), visibilityOrDefault(comment.Visibility))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addRevision(tx, models.RevisionComment, int(id), nil, comment.Content, html, comment.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	comment.ID = int(id)
+	return nil
 }
 
-// GetCommentsByPostID returns comments for a post.
+// GetCommentsByPostID returns comments for a post, including deleted ones so
+// the thread can show where they were.
 func (r *Repository) GetCommentsByPostID(postID int) ([]*models.Comment, error) {
-	rows, err := r.db.Query(`SELECT c.id, c.post_id, c.user_id, c.content, c.created_at
+	rows, err := r.db.Query(`SELECT c.id, c.post_id, c.user_id, c.parent_id, c.content, c.created_at, c.updated_at, c.visibility,
+                             c.deleted_at, c.deleted_by
                              FROM comments c
                              WHERE c.post_id = ? ORDER BY c.created_at DESC`, postID)
 	if err != nil {
@@ -261,7 +388,8 @@ func (r *Repository) GetCommentsByPostID(postID int) ([]*models.Comment, error)
 	var comments []*models.Comment
 	for rows.Next() {
 		comment := &models.Comment{}
-		err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt)
+		err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.ParentID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt, &comment.Visibility,
+			&comment.DeletedAt, &comment.DeletedBy)
 		if err != nil {
 			return nil, err
 		}
@@ -270,23 +398,37 @@ func (r *Repository) GetCommentsByPostID(postID int) ([]*models.Comment, error)
 	return comments, nil
 }
 
-// DeleteComment deletes a comment by ID
-func (r *Repository) DeleteComment(commentID int) error {
-	_, err := r.db.Exec("DELETE FROM comments WHERE id = ?", commentID)
+// DeleteComment moves a comment to the trash. Its replies stay in the
+// thread.
+func (r *Repository) DeleteComment(commentID, deletedBy int) error {
+	_, err := r.db.Exec("UPDATE comments SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL", time.Now(), deletedBy, commentID)
 	return err
 }
 
-// UpdateComment updates the comment text and updated_at
-func (r *Repository) UpdateComment(commentID int, newContent string) error {
-	_, err := r.db.Exec("UPDATE comments SET content = ?, created_at = CURRENT_TIMESTAMP WHERE id = ?", newContent, commentID)
-	return err
+// UpdateComment updates the comment text and updated_at and records a revision by editorID
+func (r *Repository) UpdateComment(commentID, editorID int, newContent string) error {
+	html := r.renderContent(newContent)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE comments SET content = ?, updated_at = ? WHERE id = ?", newContent, time.Now(), commentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addRevision(tx, models.RevisionComment, commentID, nil, newContent, html, editorID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetCommentByID returns a comment by ID
 func (r *Repository) GetCommentByID(commentID int) (*models.Comment, error) {
-	row := r.db.QueryRow("SELECT id, post_id, user_id, content FROM comments WHERE id = ?", commentID)
+	row := r.db.QueryRow(`SELECT id, post_id, user_id, parent_id, content, created_at, updated_at, visibility FROM comments
+                          WHERE id = ? AND deleted_at IS NULL AND post_id IN (SELECT id FROM posts WHERE deleted_at IS NULL)`, commentID)
 	c := &models.Comment{}
-	if err := row.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content); err != nil {
+	if err := row.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentID, &c.Content, &c.CreatedAt, &c.UpdatedAt, &c.Visibility); err != nil {
 		return nil, err
 	}
 	return c, nil
@@ -312,9 +454,13 @@ func (r *Repository) GetAllCategories() ([]*models.Category, error) {
 }
 
 // CreateCategory creates a new category.
-func (r *Repository) CreateCategory(name string) error {
-	_, err := r.db.Exec("INSERT INTO categories (name) VALUES (?)", name)
-	return err
+func (r *Repository) CreateCategory(name string) (int, error) {
+	res, err := r.db.Exec("INSERT INTO categories (name) VALUES (?)", name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
 }
 
 // CategoryExists checks if a category with the specified ID exists.
@@ -328,66 +474,31 @@ func (r *Repository) CategoryExists(id int) (bool, error) {
 	return exists, nil
 }
 
-// PostExists checks if a post with the specified ID exists.
-func (r *Repository) PostExists(id int) (bool, error) {
-	var exists bool
-	query := "SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)"
-	err := r.db.QueryRow(query, id).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		return false, err
-	}
-	return exists, nil
-}
-
-// UpdatePost updates the title, content and updated_at of a post
-func (r *Repository) UpdatePost(postID int, title, content string) error {
-	_, err := r.db.Exec("UPDATE posts SET title = ?, content = ? WHERE id = ?", title, content, postID)
-	return err
-}
-
-// DeletePost deletes a post by ID
-func (r *Repository) DeletePost(postID int) error {
+// UpdatePost updates the title, content and updated_at of a post and records a revision by editorID
+func (r *Repository) UpdatePost(postID, editorID int, title, content string) error {
+	html := r.renderContent(content)
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-
-	// Delete related data
-	// Order is important due to foreign keys
-	if _, err := tx.Exec("DELETE FROM comments WHERE post_id = ?", postID); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if _, err := tx.Exec("DELETE FROM likes WHERE post_id = ?", postID); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if _, err := tx.Exec("DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if _, err := tx.Exec("DELETE FROM images WHERE post_id = ?", postID); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if _, err := tx.Exec("DELETE FROM notifications WHERE post_id = ?", postID); err != nil {
+	if _, err := tx.Exec("UPDATE posts SET title = ?, content = ?, updated_at = ? WHERE id = ?", title, content, time.Now(), postID); err != nil {
 		tx.Rollback()
 		return err
 	}
-	if _, err := tx.Exec("DELETE FROM reports WHERE post_id = ?", postID); err != nil {
+	if err := addRevision(tx, models.RevisionPost, postID, &title, content, html, editorID); err != nil {
 		tx.Rollback()
 		return err
 	}
-
-	// Delete the post itself
-	if _, err := tx.Exec("DELETE FROM posts WHERE id = ?", postID); err != nil {
-		tx.Rollback()
-		return err
-	}
-
 	return tx.Commit()
 }
 
+// DeletePost moves a post to the trash together with everything attached
+// to it; PurgeDeleted removes it for good later.
+func (r *Repository) DeletePost(postID, deletedBy int) error {
+	_, err := r.db.Exec("UPDATE posts SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL", time.Now(), deletedBy, postID)
+	return err
+}
+
 // AddImage adds an image to a post
 func (r *Repository) AddImage(postID int, filePath string) error {
 	_, err := r.db.Exec("INSERT INTO images (post_id, file_path, uploaded_at) VALUES (?, ?, CURRENT_TIMESTAMP)", postID, filePath)
@@ -404,11 +515,46 @@ func (r *Repository) GetImagePathByPostID(postID int) (string, error) {
 	return path, nil
 }
 
-// CreateNotification creates a new notification
+// Notification events published to NotificationHub
+const (
+	EventNotification = "notification"
+	EventUnreadCount  = "unread"
+)
+
+// NotificationEvent is the payload of an EventNotification event
+type NotificationEvent struct {
+	Notification *models.Notification `json:"notification"`
+	UnreadCount  int                  `json:"unread_count"`
+}
+
+// CreateNotification creates a new notification and publishes it to the user's subscribers
 func (r *Repository) CreateNotification(userID int, notifType string, fromUserID *int, postID *int, commentID *int) error {
-	_, err := r.db.Exec(`INSERT INTO notifications (user_id, type, from_user_id, post_id, comment_id, created_at, is_read) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, 0)`,
+	res, err := r.db.Exec(`INSERT INTO notifications (user_id, type, from_user_id, post_id, comment_id, created_at, is_read) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, 0)`,
 		userID, notifType, fromUserID, postID, commentID)
-	return err
+	if err != nil {
+		return err
+	}
+	if r.hub.Subscribers(userID) == 0 {
+		return nil
+	}
+	id, _ := res.LastInsertId()
+	unread, err := r.CountUnreadNotifications(userID)
+	if err != nil {
+		return err
+	}
+	r.hub.Publish(userID, pubsub.Event{Name: EventNotification, Data: NotificationEvent{
+		Notification: &models.Notification{
+			ID:         int(id),
+			UserID:     userID,
+			Type:       notifType,
+			FromUserID: fromUserID,
+			PostID:     postID,
+			CommentID:  commentID,
+			CreatedAt:  time.Now().UTC(),
+		},
+		UnreadCount: unread,
+	}})
+	return nil
 }
 
 // GetNotificationsByUser retrieves notifications for a user
@@ -430,10 +576,44 @@ func (r *Repository) GetNotificationsByUser(userID int) ([]*models.Notification,
 	return notifs, nil
 }
 
-// MarkNotificationRead marks a notification as read
-func (r *Repository) MarkNotificationRead(notificationID int) error {
-	_, err := r.db.Exec(`UPDATE notifications SET is_read = 1 WHERE id = ?`, notificationID)
-	return err
+// CountUnreadNotifications returns the number of unread notifications of a user
+func (r *Repository) CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND is_read = 0`, userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks one of the user's notifications as read.
+// It returns sql.ErrNoRows if the notification does not belong to the user.
+func (r *Repository) MarkNotificationRead(userID, notificationID int) error {
+	res, err := r.db.Exec(`UPDATE notifications SET is_read = 1 WHERE id = ? AND user_id = ?`, notificationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	r.publishUnreadCount(userID)
+	return nil
+}
+
+// MarkAllNotificationsRead marks all of the user's notifications as read
+func (r *Repository) MarkAllNotificationsRead(userID int) error {
+	if _, err := r.db.Exec(`UPDATE notifications SET is_read = 1 WHERE user_id = ? AND is_read = 0`, userID); err != nil {
+		return err
+	}
+	r.publishUnreadCount(userID)
+	return nil
+}
+
+// publishUnreadCount tells the user's other open pages about a changed unread count
+func (r *Repository) publishUnreadCount(userID int) {
+	if r.hub.Subscribers(userID) == 0 {
+		return
+	}
+	if unread, err := r.CountUnreadNotifications(userID); err == nil {
+		r.hub.Publish(userID, pubsub.Event{Name: EventUnreadCount, Data: unread})
+	}
 }
 
 // CreateReport creates a report on a post or comment
@@ -443,34 +623,56 @@ func (r *Repository) CreateReport(reporterID int, postID *int, commentID *int, r
 	return err
 }
 
-// GetAllReports returns all reports
-func (r *Repository) GetAllReports() ([]*models.Report, error) {
-	rows, err := r.db.Query(`SELECT id, reporter_id, post_id, comment_id, reason, created_at, status FROM reports ORDER BY created_at DESC`)
+// reportColumns lists the columns scanned by scanReport.
+const reportColumns = `id, reporter_id, post_id, comment_id, reason, created_at, status, action, resolution_note, resolved_by, resolved_at`
+
+func scanReport(row interface{ Scan(...interface{}) error }) (*models.Report, error) {
+	rep := &models.Report{}
+	err := row.Scan(&rep.ID, &rep.ReporterID, &rep.PostID, &rep.CommentID, &rep.Reason, &rep.CreatedAt, &rep.Status,
+		&rep.Action, &rep.ResolutionNote, &rep.ResolvedBy, &rep.ResolvedAt)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var reports []*models.Report
-	for rows.Next() {
-		r := &models.Report{}
-		err := rows.Scan(&r.ID, &r.ReporterID, &r.PostID, &r.CommentID, &r.Reason, &r.CreatedAt, &r.Status)
-		if err != nil {
-			return nil, err
-		}
-		reports = append(reports, r)
+	return rep, nil
+}
+
+// GetAllReports returns all reports, unresolved ones first
+func (r *Repository) GetAllReports() ([]*models.Report, error) {
+	return r.queryReports(`SELECT ` + reportColumns + ` FROM reports
+                           ORDER BY status IN ('actioned', 'rejected'), created_at DESC`)
+}
+
+// UpdateReportStatus moves a report to status and records the action taken,
+// the moderator's note and who made the change.
+func (r *Repository) UpdateReportStatus(reportID int, status, action, note string, moderatorID int) error {
+	_, err := r.db.Exec(`UPDATE reports SET status = ?, action = ?, resolution_note = ?, resolved_by = ?, resolved_at = ? WHERE id = ?`,
+		status, action, note, moderatorID, time.Now(), reportID)
+	return err
+}
+
+// visibilityOrDefault lets new posts and comments leave Visibility empty.
+func visibilityOrDefault(visibility string) string {
+	if visibility == "" {
+		return models.VisibilityVisible
 	}
-	return reports, nil
+	return visibility
+}
+
+// SetPostVisibility hides or shows a post.
+func (r *Repository) SetPostVisibility(postID int, visibility string) error {
+	_, err := r.db.Exec("UPDATE posts SET visibility = ? WHERE id = ?", visibility, postID)
+	return err
 }
 
-// CloseReport closes a report
-func (r *Repository) CloseReport(reportID int) error {
-	_, err := r.db.Exec(`UPDATE reports SET status = 'closed' WHERE id = ?`, reportID)
+// SetCommentVisibility hides or shows a comment.
+func (r *Repository) SetCommentVisibility(commentID int, visibility string) error {
+	_, err := r.db.Exec("UPDATE comments SET visibility = ? WHERE id = ?", visibility, commentID)
 	return err
 }
 
 // GetPostsByUser returns posts by a user
 func (r *Repository) GetPostsByUser(userID int) ([]*models.Post, error) {
-	rows, err := r.db.Query("SELECT id, user_id, title, content, created_at FROM posts WHERE user_id = ? ORDER BY created_at DESC", userID)
+	rows, err := r.db.Query("SELECT id, user_id, title, content, created_at, visibility FROM posts WHERE user_id = ? AND deleted_at IS NULL ORDER BY created_at DESC", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -478,7 +680,7 @@ func (r *Repository) GetPostsByUser(userID int) ([]*models.Post, error) {
 	var posts []*models.Post
 	for rows.Next() {
 		p := &models.Post{}
-		err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CreatedAt)
+		err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CreatedAt, &p.Visibility)
 		if err != nil {
 			return nil, err
 		}
@@ -489,7 +691,9 @@ func (r *Repository) GetPostsByUser(userID int) ([]*models.Post, error) {
 
 // GetCommentsByUser returns comments by a user
 func (r *Repository) GetCommentsByUser(userID int) ([]*models.Comment, error) {
-	rows, err := r.db.Query("SELECT id, post_id, user_id, content, created_at FROM comments WHERE user_id = ? ORDER BY created_at DESC", userID)
+	rows, err := r.db.Query(`SELECT id, post_id, user_id, content, created_at, visibility FROM comments
+                             WHERE user_id = ? AND deleted_at IS NULL AND post_id IN (SELECT id FROM posts WHERE deleted_at IS NULL)
+                             ORDER BY created_at DESC`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -497,7 +701,7 @@ func (r *Repository) GetCommentsByUser(userID int) ([]*models.Comment, error) {
 	var comments []*models.Comment
 	for rows.Next() {
 		c := &models.Comment{}
-		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt)
+		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.Visibility)
 		if err != nil {
 			return nil, err
 		}
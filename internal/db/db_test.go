@@ -1,9 +1,16 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"forum/internal/config"
 	"forum/internal/models"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func setupTestRepo(t *testing.T) *Repository {
@@ -88,3 +95,1308 @@ func TestCreateReport(t *testing.T) {
 		t.Errorf("Ошибка создания жалобы: %v", err)
 	}
 }
+
+func TestRunMigrationsIdempotent(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatalf("Повторный запуск миграций: %v", err)
+	}
+	version, err := repo.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Ошибка чтения версии схемы: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("Версия схемы %d, ожидалось %d", version, LatestSchemaVersion())
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	if err := repo.MigrateDown(0); err != nil {
+		t.Fatalf("Ошибка отката миграций: %v", err)
+	}
+	version, _ := repo.SchemaVersion()
+	if version != 0 {
+		t.Errorf("Версия схемы после отката %d, ожидалось 0", version)
+	}
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatalf("Ошибка повторного применения миграций: %v", err)
+	}
+}
+
+// Content visibility has its own migration: rolling it back keeps the
+// report resolution columns, and a database that got visibility from
+// version 16 before the split still migrates.
+func TestMigrateVisibility(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	hasColumn := func(table, column string) bool {
+		var exists bool
+		repo.db.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+		return exists
+	}
+
+	if err := repo.MigrateDown(24); err != nil {
+		t.Fatalf("Ошибка отката миграции: %v", err)
+	}
+	if hasColumn("posts", "visibility") || hasColumn("comments", "visibility") {
+		t.Errorf("Видимость не удалена при откате")
+	}
+	if !hasColumn("reports", "action") {
+		t.Errorf("Откат видимости удалил поля разбора жалоб")
+	}
+
+	repo.db.Exec("ALTER TABLE posts ADD COLUMN visibility TEXT NOT NULL DEFAULT 'visible'")
+	repo.db.Exec("ALTER TABLE comments ADD COLUMN visibility TEXT NOT NULL DEFAULT 'visible'")
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatalf("Ошибка миграции базы с видимостью из версии 16: %v", err)
+	}
+	if !hasColumn("posts", "visibility") || !hasColumn("comments", "visibility") {
+		t.Errorf("Видимость пропала после миграции")
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	if _, err := repo.db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'future')", LatestSchemaVersion()+1); err != nil {
+		t.Fatalf("Ошибка вставки версии: %v", err)
+	}
+	if err := repo.RunMigrations(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Ожидалась ErrSchemaTooNew, получено %v", err)
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "e@b.c", Username: "e"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("e@b.c")
+	pid, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Goroutines explained", Content: "Channels and select"})
+	repo.CreateComment(&models.Comment{PostID: int(pid), UserID: u.ID, Content: "Great goroutine tutorial"})
+
+	results, err := repo.SearchPosts(models.SearchQuery{Text: "goroutine"})
+	if err != nil {
+		t.Fatalf("Ошибка поиска: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Найдено %d результатов, ожидалось 2", len(results))
+	}
+
+	repo.UpdatePost(int(pid), u.ID, "Mutexes explained", "Locks")
+	results, _ = repo.SearchPosts(models.SearchQuery{Text: "channels"})
+	if len(results) != 0 {
+		t.Errorf("Индекс не обновлён после редактирования поста")
+	}
+
+	results, _ = repo.SearchPosts(models.SearchQuery{Text: "goroutine", Author: "nobody"})
+	if len(results) != 0 {
+		t.Errorf("Фильтр по автору не применён")
+	}
+
+	repo.DeletePost(int(pid), u.ID)
+	results, _ = repo.SearchPosts(models.SearchQuery{Text: "mutexes goroutine"})
+	if len(results) != 0 {
+		t.Errorf("Индекс не очищен после удаления поста")
+	}
+
+	// Маркеры совпадений из текста поста не попадают в сниппет, в том числе
+	// у постов, проиндексированных до миграции
+	if err := repo.MigrateDown(23); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)",
+		u.ID, "Markers", "\x02spoofed\x03 highlight and channels"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = repo.SearchPosts(models.SearchQuery{Text: "channels"})
+	if len(results) != 1 {
+		t.Fatalf("Найдено %d результатов, ожидался 1", len(results))
+	}
+	if want := "spoofed highlight and " + models.SnippetMatchStart + "channels" + models.SnippetMatchEnd; results[0].Snippet != want {
+		t.Errorf("Сниппет %q, ожидался %q", results[0].Snippet, want)
+	}
+}
+
+// Results are ranked by relevance with either full-text module: run the
+// tests with and without -tags sqlite_fts5 to cover both.
+func TestSearchRanking(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "rank@b.c", Username: "rank"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("rank@b.c")
+	for _, title := range []string{"Mutexes", "Channels", "Select", "Contexts", "Generics"} {
+		repo.CreatePost(&models.Post{UserID: u.ID, Title: title, Content: "Nothing to see here"})
+	}
+	passing, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Weekly notes", Content: "We talked about testing, tooling, releases, the build, reviews, a goroutine, and the roadmap for next quarter"})
+	focused, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Leaks", Content: "goroutine leaks: every goroutine needs an exit"})
+	titled, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Goroutine basics", Content: "How the runtime schedules work"})
+
+	results, err := repo.SearchPosts(models.SearchQuery{Text: "goroutine"})
+	if err != nil {
+		t.Fatalf("Ошибка поиска (%s): %v", repo.searchModule(), err)
+	}
+	var got []int
+	for i, res := range results {
+		got = append(got, res.PostID)
+		if res.Rank >= 0 {
+			t.Errorf("Ранг %v у поста %d, ожидался отрицательный", res.Rank, res.PostID)
+		}
+		if i > 0 && res.Rank < results[i-1].Rank {
+			t.Errorf("Результаты не упорядочены по рангу: %v после %v", res.Rank, results[i-1].Rank)
+		}
+	}
+	want := []int{int(titled), int(focused), int(passing)}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Порядок результатов (%s) %v, ожидался %v", repo.searchModule(), got, want)
+	}
+}
+
+func TestCreateReply(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "f@b.c", Username: "f"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("f@b.c")
+	pid, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Test Post", Content: "Hello"})
+	parent := &models.Comment{PostID: int(pid), UserID: u.ID, Content: "Parent"}
+	if err := repo.CreateComment(parent); err != nil {
+		t.Fatalf("Ошибка создания комментария: %v", err)
+	}
+	reply := &models.Comment{PostID: int(pid), UserID: u.ID, ParentID: &parent.ID, Content: "Reply"}
+	if err := repo.CreateComment(reply); err != nil {
+		t.Fatalf("Ошибка создания ответа: %v", err)
+	}
+	got, err := repo.GetCommentByID(reply.ID)
+	if err != nil {
+		t.Fatalf("Ошибка получения ответа: %v", err)
+	}
+	if got.ParentID == nil || *got.ParentID != parent.ID {
+		t.Errorf("Неверный parent_id у ответа: %v", got.ParentID)
+	}
+}
+
+func TestRevisionsAndRollback(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "g@b.c", Username: "g"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("g@b.c")
+	pid, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "First title", Content: "First content"})
+	if err := repo.UpdatePost(int(pid), u.ID, "Second title", "Second content"); err != nil {
+		t.Fatalf("Ошибка обновления поста: %v", err)
+	}
+
+	revisions, err := repo.GetRevisions(models.RevisionPost, int(pid))
+	if err != nil {
+		t.Fatalf("Ошибка получения ревизий: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Ревизий %d, ожидалось 2", len(revisions))
+	}
+
+	if _, err := repo.RollbackToRevision(revisions[0].ID, u.ID); err != nil {
+		t.Fatalf("Ошибка отката: %v", err)
+	}
+	post, _ := repo.GetPostByID(int(pid))
+	if post.Title != "First title" || post.Content != "First content" || post.UpdatedAt == nil {
+		t.Errorf("Пост не восстановлен: %+v", post)
+	}
+	revisions, _ = repo.GetRevisions(models.RevisionPost, int(pid))
+	if len(revisions) != 3 {
+		t.Errorf("Откат должен создать новую ревизию, ревизий %d", len(revisions))
+	}
+
+	// Posts and comments in the trash are not rolled back, nor are
+	// comments of a post in the trash.
+	comment := &models.Comment{PostID: int(pid), UserID: u.ID, Content: "First comment"}
+	if err := repo.CreateComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateComment(comment.ID, u.ID, "Second comment"); err != nil {
+		t.Fatal(err)
+	}
+	commentRevisions, _ := repo.GetRevisions(models.RevisionComment, comment.ID)
+	if err := repo.DeletePost(int(pid), u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RollbackToRevision(revisions[1].ID, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Откат удалённого поста: %v, ожидалось sql.ErrNoRows", err)
+	}
+	if _, err := repo.RollbackToRevision(commentRevisions[0].ID, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Откат комментария удалённого поста: %v, ожидалось sql.ErrNoRows", err)
+	}
+	if err := repo.RestorePost(int(pid)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteComment(comment.ID, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RollbackToRevision(commentRevisions[0].ID, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Откат удалённого комментария: %v, ожидалось sql.ErrNoRows", err)
+	}
+	if n, _ := repo.GetRevisions(models.RevisionPost, int(pid)); len(n) != 3 {
+		t.Errorf("Откат удалённого поста создал ревизию, ревизий %d", len(n))
+	}
+	if n, _ := repo.GetRevisions(models.RevisionComment, comment.ID); len(n) != 2 {
+		t.Errorf("Откат удалённого комментария создал ревизию, ревизий %d", len(n))
+	}
+}
+
+func TestGetPostsPage(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "h@b.c", Username: "h"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("h@b.c")
+	for i := 0; i < 5; i++ {
+		repo.CreatePost(&models.Post{UserID: u.ID, Title: "Post", Content: "Content"})
+	}
+
+	first, err := repo.GetPostsPage(0, 0, 3)
+	if err != nil {
+		t.Fatalf("Ошибка получения страницы: %v", err)
+	}
+	if len(first) != 3 || first[0].ID < first[2].ID {
+		t.Fatalf("Неверная первая страница: %d постов", len(first))
+	}
+	second, err := repo.GetPostsPage(0, first[2].ID, 3)
+	if err != nil {
+		t.Fatalf("Ошибка получения страницы: %v", err)
+	}
+	if len(second) != 2 || second[0].ID >= first[2].ID {
+		t.Errorf("Неверная вторая страница: %d постов", len(second))
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "i@b.c", Username: "i"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("i@b.c")
+
+	secret, _, err := repo.CreateAPIToken(u.ID, "bot", []string{models.ScopeRead})
+	if err != nil {
+		t.Fatalf("Ошибка создания токена: %v", err)
+	}
+	token, err := repo.AuthenticateAPIToken(secret)
+	if err != nil {
+		t.Fatalf("Ошибка аутентификации токеном: %v", err)
+	}
+	if token.UserID != u.ID || !token.HasScope(models.ScopeRead) || token.HasScope(models.ScopeWrite) {
+		t.Errorf("Неверный токен: %+v", token)
+	}
+	tokens, _ := repo.GetAPITokensByUser(u.ID)
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Errorf("Время последнего использования не записано")
+	}
+
+	if err := repo.DeleteAPIToken(token.ID, u.ID); err != nil {
+		t.Fatalf("Ошибка удаления токена: %v", err)
+	}
+	if _, err := repo.AuthenticateAPIToken(secret); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("Отозванный токен принят: %v", err)
+	}
+}
+
+func TestNotificationHub(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "j@b.c", Username: "j"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("j@b.c")
+
+	events, cancel := repo.NotificationHub().Subscribe(u.ID)
+	defer cancel()
+	if err := repo.CreateNotification(u.ID, "like", nil, nil, nil); err != nil {
+		t.Fatalf("Ошибка создания уведомления: %v", err)
+	}
+	ev := <-events
+	ne, ok := ev.Data.(NotificationEvent)
+	if ev.Name != EventNotification || !ok || ne.UnreadCount != 1 {
+		t.Fatalf("Неверное событие: %+v", ev)
+	}
+
+	if err := repo.MarkNotificationRead(u.ID+1, ne.Notification.ID); err == nil {
+		t.Errorf("Чужое уведомление отмечено прочитанным")
+	}
+	if err := repo.MarkNotificationRead(u.ID, ne.Notification.ID); err != nil {
+		t.Fatalf("Ошибка отметки уведомления: %v", err)
+	}
+	if ev := <-events; ev.Name != EventUnreadCount || ev.Data != 0 {
+		t.Errorf("Неверное число непрочитанных: %+v", ev)
+	}
+}
+
+func TestGetRenderedContent(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "k@b.c", Username: "k"}
+	repo.CreateUser(user, "pass")
+	u, _ := repo.GetUserByEmail("k@b.c")
+	pid, _ := repo.CreatePost(&models.Post{UserID: u.ID, Title: "Markdown", Content: "**bold** <script>x</script>"})
+
+	rendered, err := repo.GetRenderedContent(models.RevisionPost, int(pid))
+	if err != nil {
+		t.Fatalf("Ошибка получения HTML: %v", err)
+	}
+	html := rendered[int(pid)]
+	if !strings.Contains(html, "<strong>bold</strong>") || strings.Contains(html, "<script") {
+		t.Errorf("Неверный HTML: %q", html)
+	}
+
+	// Ревизии без кэша (созданные до миграции) рендерятся при первом запросе
+	repo.UpdatePost(int(pid), u.ID, "Markdown", "_new_")
+	repo.db.Exec("UPDATE revisions SET rendered_html = NULL")
+	rendered, _ = repo.GetRenderedContent(models.RevisionPost, int(pid))
+	if !strings.Contains(rendered[int(pid)], "<em>new</em>") {
+		t.Errorf("HTML не обновлён после редактирования: %q", rendered[int(pid)])
+	}
+	var cached int
+	repo.db.QueryRow("SELECT COUNT(*) FROM revisions WHERE rendered_html IS NOT NULL").Scan(&cached)
+	if cached != 1 {
+		t.Errorf("Кэш не сохранён, закэшировано ревизий: %d", cached)
+	}
+
+	// Кэш с заголовками без префикса user-content- сбрасывается миграцией
+	repo.UpdatePost(int(pid), u.ID, "Markdown", "# Intro")
+	repo.MigrateDown(22)
+	repo.db.Exec(`UPDATE revisions SET rendered_html = '<h1 id="intro">Intro</h1>' WHERE rendered_html LIKE '%<h1%'`)
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	rendered, _ = repo.GetRenderedContent(models.RevisionPost, int(pid))
+	if !strings.Contains(rendered[int(pid)], `<h1 id="user-content-intro">`) {
+		t.Errorf("Кэш со старыми id заголовков не обновлён: %q", rendered[int(pid)])
+	}
+}
+
+func TestSearchUsernamesAndMentions(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	for _, name := range []string{"alice", "alicia", "al_x", "bob"} {
+		repo.CreateUser(&models.User{Email: name + "@b.c", Username: name}, "pass")
+	}
+
+	users, err := repo.SearchUsernames("ali", 10)
+	if err != nil {
+		t.Fatalf("Ошибка поиска пользователей: %v", err)
+	}
+	if len(users) != 2 || users[0].Username != "alice" {
+		t.Errorf("Неверный результат автодополнения: %d", len(users))
+	}
+	// "_" в префиксе — обычный символ, а не шаблон LIKE
+	if users, _ := repo.SearchUsernames("al_", 10); len(users) != 1 {
+		t.Errorf("Символ _ обработан как шаблон: %d", len(users))
+	}
+
+	bob, _ := repo.GetUserByUsername("bob")
+	pid, _ := repo.CreatePost(&models.Post{UserID: bob.ID, Title: "Mentions", Content: "hi @alice and @nobody"})
+	rendered, _ := repo.GetRenderedContent(models.RevisionPost, int(pid))
+	if !strings.Contains(rendered[int(pid)], `href="/user?username=alice"`) || strings.Contains(rendered[int(pid)], "username=nobody") {
+		t.Errorf("Упоминания разрешены неверно: %q", rendered[int(pid)])
+	}
+}
+
+func TestMailOutbox(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	m := &models.OutboxMail{To: "a@b.c", Subject: "Hi", TextBody: "Hello"}
+	if err := repo.EnqueueMail(m); err != nil {
+		t.Fatalf("Ошибка постановки письма в очередь: %v", err)
+	}
+
+	due, err := repo.GetDueMail(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Письмо не найдено в очереди: %v", err)
+	}
+	next := time.Now().Add(time.Hour)
+	if err := repo.MarkMailAttemptFailed(m.ID, "timeout", &next); err != nil {
+		t.Fatalf("Ошибка записи неудачной попытки: %v", err)
+	}
+	if due, _ := repo.GetDueMail(time.Now().Add(time.Second), 10); len(due) != 0 {
+		t.Errorf("Письмо отправляется раньше времени повтора")
+	}
+	due, _ = repo.GetDueMail(next.Add(time.Second), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != "timeout" {
+		t.Fatalf("Неверное состояние письма после ошибки")
+	}
+	if err := repo.MarkMailSent(m.ID); err != nil {
+		t.Fatalf("Ошибка отметки отправки: %v", err)
+	}
+	if due, _ := repo.GetDueMail(next.Add(time.Second), 10); len(due) != 0 {
+		t.Errorf("Отправленное письмо осталось в очереди")
+	}
+
+	// Тело отправленного или брошенного письма стирается
+	failed := &models.OutboxMail{To: "a@b.c", Subject: "Reset", TextBody: "token", HTMLBody: "<b>token</b>"}
+	pending := &models.OutboxMail{To: "a@b.c", Subject: "Later", TextBody: "token"}
+	repo.EnqueueMail(failed)
+	repo.EnqueueMail(pending)
+	if err := repo.MarkMailAttemptFailed(failed.ID, "rejected", nil); err != nil {
+		t.Fatalf("Ошибка записи неудачной попытки: %v", err)
+	}
+	var bodies int
+	repo.db.QueryRow("SELECT COUNT(*) FROM mail_outbox WHERE text_body != '' OR html_body != ''").Scan(&bodies)
+	if bodies != 1 {
+		t.Errorf("Ожидалось одно письмо с телом (в очереди), получено %d", bodies)
+	}
+
+	if n, err := repo.DeleteOldMail(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Удалены свежие письма: %d, %v", n, err)
+	}
+	if n, err := repo.DeleteOldMail(time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("Ожидалось удаление двух писем, удалено %d: %v", n, err)
+	}
+	if due, _ := repo.GetDueMail(time.Now().Add(time.Second), 10); len(due) != 1 || due[0].ID != pending.ID {
+		t.Errorf("Письмо в очереди удалено")
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "new@example.com", Username: "newbie"}
+	if err := repo.CreateUser(user, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	if u, _ := repo.GetUserByID(user.ID); u == nil || u.EmailVerified() {
+		t.Fatalf("Новый пользователь не должен быть подтверждён")
+	}
+
+	expired, err := repo.CreateEmailVerification(user.ID, -time.Minute)
+	if err != nil {
+		t.Fatalf("Ошибка создания токена: %v", err)
+	}
+	if _, err := repo.VerifyEmail(expired); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Просроченный токен принят: %v", err)
+	}
+
+	first, _ := repo.CreateEmailVerification(user.ID, time.Hour)
+	second, _ := repo.CreateEmailVerification(user.ID, time.Hour)
+	if n, _ := repo.CountEmailVerificationsSince(user.ID, time.Now().Add(-time.Hour)); n != 3 {
+		t.Errorf("Ожидалось 3 токена, получено %d", n)
+	}
+	userID, err := repo.VerifyEmail(second)
+	if err != nil || userID != user.ID {
+		t.Fatalf("Ошибка подтверждения email: %v", err)
+	}
+	if u, _ := repo.GetUserByID(user.ID); !u.EmailVerified() {
+		t.Errorf("Email не отмечен как подтверждённый")
+	}
+	if _, err := repo.VerifyEmail(second); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Токен использован повторно")
+	}
+	if _, err := repo.VerifyEmail(first); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Старый токен остался действительным после подтверждения")
+	}
+
+	pending, _ := repo.CreateEmailVerification(user.ID, time.Hour)
+	if err := repo.DeleteExpiredEmailVerifications(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Ошибка очистки: %v", err)
+	}
+	if n, _ := repo.CountEmailVerificationsSince(user.ID, time.Now().Add(-time.Hour)); n != 4 {
+		t.Errorf("Очистка удалила свежие токены: осталось %d", n)
+	}
+	if err := repo.DeleteExpiredEmailVerifications(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Ошибка очистки: %v", err)
+	}
+	if n, _ := repo.CountEmailVerificationsSince(user.ID, time.Now().Add(-time.Hour)); n != 1 {
+		t.Errorf("Ожидался один неиспользованный токен, осталось %d", n)
+	}
+	if _, err := repo.VerifyEmail(pending); err != nil {
+		t.Errorf("Действующий токен удалён: %v", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "reset@example.com", Username: "forgetful"}
+	if err := repo.CreateUser(user, "oldpassword"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	for _, id := range []string{"s1", "s2"} {
+		repo.CreateSession(&models.Session{SessionID: id, UserID: user.ID, Expires: time.Now().Add(time.Hour)})
+	}
+
+	token, err := repo.CreatePasswordReset(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Ошибка создания токена: %v", err)
+	}
+	if err := repo.CheckPasswordReset(token); err != nil {
+		t.Errorf("Действительный токен отклонён: %v", err)
+	}
+	if _, err := repo.ResetPassword("wrong", "newpassword"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Неверный токен принят: %v", err)
+	}
+	challenge, _ := repo.CreateLoginChallenge(user.ID, false, time.Minute)
+	userID, err := repo.ResetPassword(token, "newpassword")
+	if err != nil || userID != user.ID {
+		t.Fatalf("Ошибка сброса пароля: %v", err)
+	}
+	if _, _, err := repo.GetLoginChallenge(challenge); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("Запрос входа со старым паролем действителен после сброса")
+	}
+	if _, err := repo.ResetPassword(token, "another"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Токен использован повторно")
+	}
+	if err := repo.DeleteAllUserSessions(user.ID); err != nil {
+		t.Fatalf("Ошибка удаления сессий: %v", err)
+	}
+	if _, err := repo.GetSession("s1"); err == nil {
+		t.Errorf("Сессия осталась после сброса пароля")
+	}
+
+	u, _ := repo.GetUserByID(user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("newpassword")) != nil {
+		t.Errorf("Пароль не изменён")
+	}
+	if !u.EmailVerified() {
+		t.Errorf("Сброс пароля по ссылке должен подтверждать email")
+	}
+
+	repo.RecordPasswordResetRequest("10.0.0.1")
+	repo.RecordPasswordResetRequest("10.0.0.1")
+	if n, _ := repo.CountPasswordResetRequestsByIP("10.0.0.1", time.Now().Add(-time.Hour)); n != 2 {
+		t.Errorf("Ожидалось 2 запроса с IP, получено %d", n)
+	}
+
+	pending, _ := repo.CreatePasswordReset(user.ID, time.Hour)
+	if err := repo.DeleteExpiredPasswordResets(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Ошибка очистки: %v", err)
+	}
+	if n, _ := repo.CountPasswordResetsSince(user.ID, time.Now().Add(-time.Hour)); n != 2 {
+		t.Errorf("Очистка удалила свежие токены: осталось %d", n)
+	}
+	if err := repo.DeleteExpiredPasswordResets(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Ошибка очистки: %v", err)
+	}
+	if n, _ := repo.CountPasswordResetRequestsByIP("10.0.0.1", time.Now().Add(-time.Hour)); n != 0 {
+		t.Errorf("Старые запросы не удалены: осталось %d", n)
+	}
+	if n, _ := repo.CountPasswordResetsSince(user.ID, time.Now().Add(-time.Hour)); n != 1 {
+		t.Errorf("Ожидался один неиспользованный токен, осталось %d", n)
+	}
+	if err := repo.CheckPasswordReset(pending); err != nil {
+		t.Errorf("Действующий токен удалён: %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "mod@example.com", Username: "moder"}
+	if err := repo.CreateUser(user, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	if err := repo.SetPendingTOTPSecret(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatalf("Ошибка сохранения секрета: %v", err)
+	}
+	if u, _ := repo.GetUserByID(user.ID); u.TOTPSecret != "JBSWY3DPEHPK3PXP" || u.TwoFactorEnabled() {
+		t.Fatalf("2FA не должна включаться до подтверждения кодом")
+	}
+
+	codes, err := repo.EnableTOTP(user.ID, 100)
+	if err != nil || len(codes) != RecoveryCodeCount {
+		t.Fatalf("Ошибка включения 2FA: %v", err)
+	}
+	if u, _ := repo.GetUserByID(user.ID); !u.TwoFactorEnabled() {
+		t.Errorf("2FA не включена")
+	}
+	if ok, _ := repo.UseTOTPStep(user.ID, 100); ok {
+		t.Errorf("Код принят повторно")
+	}
+	if ok, _ := repo.UseTOTPStep(user.ID, 101); !ok {
+		t.Errorf("Код следующего шага отклонён")
+	}
+
+	if ok, _ := repo.UseRecoveryCode(user.ID, strings.ToUpper(codes[0])); !ok {
+		t.Errorf("Код восстановления отклонён")
+	}
+	if ok, _ := repo.UseRecoveryCode(user.ID, codes[0]); ok {
+		t.Errorf("Код восстановления использован повторно")
+	}
+	if n, _ := repo.CountRecoveryCodes(user.ID); n != RecoveryCodeCount-1 {
+		t.Errorf("Ожидалось %d кодов, получено %d", RecoveryCodeCount-1, n)
+	}
+
+	challenge, err := repo.CreateLoginChallenge(user.ID, true, time.Minute)
+	if err != nil {
+		t.Fatalf("Ошибка создания запроса входа: %v", err)
+	}
+	for i := 0; i < maxChallengeAttempts; i++ {
+		if id, remember, err := repo.GetLoginChallenge(challenge); err != nil || id != user.ID || !remember {
+			t.Fatalf("Запрос входа недействителен после %d попыток: %v", i, err)
+		}
+		repo.FailLoginChallenge(challenge)
+	}
+	if _, _, err := repo.GetLoginChallenge(challenge); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("Запрос входа действителен после исчерпания попыток")
+	}
+	live, _ := repo.CreateLoginChallenge(user.ID, false, time.Minute)
+	repo.CreateLoginChallenge(user.ID, false, -time.Minute)
+	if err := repo.DeleteExpiredLoginChallenges(); err != nil {
+		t.Fatalf("Ошибка очистки запросов входа: %v", err)
+	}
+	var left int
+	repo.db.QueryRow("SELECT COUNT(*) FROM login_challenges").Scan(&left)
+	if left != 1 {
+		t.Errorf("После очистки осталось %d запросов входа, ожидался один", left)
+	}
+	if _, _, err := repo.GetLoginChallenge(live); err != nil {
+		t.Errorf("Очистка удалила действующий запрос входа: %v", err)
+	}
+
+	if err := repo.DisableTOTP(user.ID); err != nil {
+		t.Fatalf("Ошибка отключения 2FA: %v", err)
+	}
+	if u, _ := repo.GetUserByID(user.ID); u.TwoFactorEnabled() || u.TOTPSecret != "" {
+		t.Errorf("2FA не отключена")
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	user := &models.User{Email: "multi@example.com", Username: "multi"}
+	if err := repo.CreateUser(user, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	phone := &models.Session{SessionID: "phone", UserID: user.ID, Expires: time.Now().Add(time.Hour), UserAgent: "Phone", IP: "10.0.0.1"}
+	laptop := &models.Session{SessionID: "laptop", UserID: user.ID, Expires: time.Now().Add(time.Hour), UserAgent: "Laptop", IP: "10.0.0.2"}
+	for _, s := range []*models.Session{phone, laptop} {
+		if err := repo.CreateSession(s); err != nil {
+			t.Fatalf("Ошибка создания сессии: %v", err)
+		}
+	}
+
+	sessions, err := repo.GetUserSessions(user.ID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Ожидалось 2 сессии, получено %d: %v", len(sessions), err)
+	}
+
+	// Public IDs survive the session ids migration in both directions and
+	// VACUUM
+	if err := repo.MigrateDown(21); err != nil {
+		t.Fatalf("Ошибка отката миграций: %v", err)
+	}
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatalf("Ошибка миграций: %v", err)
+	}
+	if _, err := repo.db.Exec("VACUUM"); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{}
+	sessions, _ = repo.GetUserSessions(user.ID)
+	for _, s := range sessions {
+		ids[s.SessionID] = s.ID
+	}
+	if ids["phone"] != phone.ID || ids["laptop"] != laptop.ID {
+		t.Errorf("ID сессий изменились: %v, ожидалось phone=%d laptop=%d", ids, phone.ID, laptop.ID)
+	}
+
+	if err := repo.TouchSession("phone"); err != nil {
+		t.Fatalf("Ошибка обновления сессии: %v", err)
+	}
+	s, err := repo.GetSession("phone")
+	if err != nil || s.UserAgent != "Phone" || s.IP != "10.0.0.1" || s.LastSeenAt.Before(s.CreatedAt) {
+		t.Errorf("Неверные данные сессии: %+v, %v", s, err)
+	}
+
+	// Чужой пользователь не может завершить сессию
+	if err := repo.DeleteUserSessionByID(user.ID+1, phone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetSession("phone"); err != nil {
+		t.Errorf("Сессия завершена чужим пользователем")
+	}
+	if err := repo.DeleteUserSessionByID(user.ID, phone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetSession("phone"); err == nil {
+		t.Errorf("Сессия не завершена")
+	}
+	if _, err := repo.GetSession("laptop"); err != nil {
+		t.Errorf("Завершена не та сессия")
+	}
+
+	expired := &models.Session{SessionID: "expired", UserID: user.ID, Expires: time.Now().Add(-time.Minute)}
+	if err := repo.CreateSession(expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CleanExpiredSessions(); err != nil {
+		t.Fatalf("Ошибка очистки сессий: %v", err)
+	}
+	var left int
+	repo.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", user.ID).Scan(&left)
+	if left != 1 {
+		t.Errorf("После очистки осталось %d сессий, ожидалась 1", left)
+	}
+}
+
+func TestLoginThrottles(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	now := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		n, err := repo.RecordLoginFailure("user:alice", now, time.Hour)
+		if err != nil || n != i {
+			t.Fatalf("Ожидалось %d неудачных попыток, получено %d: %v", i, n, err)
+		}
+	}
+	if until, err := repo.GetLoginLockout("user:alice"); err != nil || until != nil {
+		t.Errorf("Блокировка без вызова SetLoginLockout: %v, %v", until, err)
+	}
+	if err := repo.SetLoginLockout("user:alice", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if until, err := repo.GetLoginLockout("user:alice"); err != nil || until == nil || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("Блокировка не сохранена: %v, %v", until, err)
+	}
+	if err := repo.RefundLoginFailure("user:alice"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := repo.RecordLoginFailure("user:alice", now, time.Hour); n != 3 {
+		t.Errorf("Попытка не возвращена: %d", n)
+	}
+
+	// Старые попытки не учитываются
+	n, err := repo.RecordLoginFailure("user:alice", now.Add(2*time.Hour), time.Hour)
+	if err != nil || n != 1 {
+		t.Errorf("Счётчик не сброшен по истечении окна: %d, %v", n, err)
+	}
+	if until, _ := repo.GetLoginLockout("user:alice"); until != nil {
+		t.Errorf("Старая блокировка не сброшена: %v", until)
+	}
+
+	if _, err := repo.RecordLoginFailure("ip:10.0.0.1", now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.ListLoginThrottles(now.Add(-time.Hour))
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Ожидалось 2 записи, получено %d: %v", len(list), err)
+	}
+
+	if err := repo.ClearLoginFailures("user:alice"); err != nil {
+		t.Fatal(err)
+	}
+	if until, err := repo.GetLoginLockout("user:alice"); err != nil || until != nil {
+		t.Errorf("Блокировка не снята: %v, %v", until, err)
+	}
+	if err := repo.DeleteStaleLoginThrottles(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := repo.ListLoginThrottles(time.Time{}); len(list) != 0 {
+		t.Errorf("Устаревшие записи не удалены: %d", len(list))
+	}
+}
+
+func TestUserAdministration(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	admin := &models.User{Email: "admin@example.com", Username: "boss"}
+	alice := &models.User{Email: "alice@example.com", Username: "alice"}
+	bob := &models.User{Email: "bob@example.org", Username: "bob_2"}
+	for _, u := range []*models.User{admin, alice, bob} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+	pid, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Пост", Content: "Текст"})
+	postID := int(pid)
+	if err := repo.CreateReport(bob.ID, &postID, nil, "Спам"); err != nil {
+		t.Fatal(err)
+	}
+
+	users, total, err := repo.ListUsers(models.UserQuery{Text: "example.com", Limit: 10})
+	if err != nil || total != 2 || len(users) != 2 {
+		t.Fatalf("Ожидалось 2 пользователя, получено %d из %d: %v", len(users), total, err)
+	}
+	// "_" в запросе не должен работать как шаблон
+	if users, _, _ := repo.ListUsers(models.UserQuery{Text: "b_", Limit: 10}); len(users) != 1 || users[0].ID != bob.ID {
+		t.Errorf("Неверный поиск с подчёркиванием: %d", len(users))
+	}
+	users, total, _ = repo.ListUsers(models.UserQuery{Limit: 1, Offset: 2})
+	if total != 3 || len(users) != 1 || users[0].ID != admin.ID || users[0].PostCount != 0 {
+		t.Errorf("Неверная страница списка: %d, %d", total, len(users))
+	}
+
+	if err := repo.ChangeUserRole(alice.ID, "superuser", admin.ID); err != ErrUnknownRole {
+		t.Errorf("Ожидалась ошибка неизвестной роли, получено %v", err)
+	}
+	if err := repo.ChangeUserRole(alice.ID, "moderator", admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ChangeUserRole(alice.ID, "moderator", admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if users, _, _ := repo.ListUsers(models.UserQuery{Role: "moderator", Limit: 10}); len(users) != 1 || users[0].PostCount != 1 {
+		t.Errorf("Неверный фильтр по роли: %d", len(users))
+	}
+	changes, err := repo.GetRoleChanges(alice.ID)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("Ожидалась 1 запись о смене роли, получено %d: %v", len(changes), err)
+	}
+	if c := changes[0]; c.OldRole != "user" || c.NewRole != "moderator" || c.ChangedByName != "boss" {
+		t.Errorf("Неверная запись о смене роли: %+v", c)
+	}
+
+	if reports, err := repo.GetReportsAgainstUser(alice.ID); err != nil || len(reports) != 1 {
+		t.Errorf("Ожидалась 1 жалоба на пользователя, получено %d: %v", len(reports), err)
+	}
+	if reports, err := repo.GetReportsByReporter(bob.ID); err != nil || len(reports) != 1 {
+		t.Errorf("Ожидалась 1 жалоба от пользователя, получено %d: %v", len(reports), err)
+	}
+
+	if err := repo.InvalidatePassword(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := repo.GetUserByID(alice.ID); u.PasswordHash != "" {
+		t.Errorf("Пароль не сброшен")
+	}
+}
+
+func TestBans(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	mod := &models.User{Email: "mod@example.com", Username: "mod"}
+	troll := &models.User{Email: "troll@example.com", Username: "troll"}
+	for _, u := range []*models.User{mod, troll} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+
+	if ban, err := repo.GetActiveBan(troll.ID); err != nil || ban != nil {
+		t.Fatalf("Бан без вызова CreateBan: %v, %v", ban, err)
+	}
+
+	// Истёкший бан не действует
+	past := time.Now().Add(-time.Minute)
+	if err := repo.CreateBan(&models.Ban{UserID: troll.ID, Reason: "Спам", IssuedBy: mod.ID, ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if ban, _ := repo.GetActiveBan(troll.ID); ban != nil {
+		t.Errorf("Истёкший бан действует: %+v", ban)
+	}
+
+	future := time.Now().Add(time.Hour)
+	temp := &models.Ban{UserID: troll.ID, Reason: "Оскорбления", IssuedBy: mod.ID, ExpiresAt: &future}
+	if err := repo.CreateBan(temp); err != nil {
+		t.Fatal(err)
+	}
+	ban, err := repo.GetActiveBan(troll.ID)
+	if err != nil || ban == nil || ban.ID != temp.ID || ban.IssuedByName != "mod" || ban.Username != "troll" || ban.Permanent() {
+		t.Fatalf("Неверный активный бан: %+v, %v", ban, err)
+	}
+
+	// Новый бан заменяет действующий
+	permanent := &models.Ban{UserID: troll.ID, Reason: "Рецидив", IssuedBy: mod.ID}
+	if err := repo.CreateBan(permanent); err != nil {
+		t.Fatal(err)
+	}
+	if old, _ := repo.GetBanByID(temp.ID); old.Active(time.Now()) || old.LiftedBy == nil || *old.LiftedBy != mod.ID {
+		t.Errorf("Предыдущий бан не снят: %+v", old)
+	}
+	if active, _ := repo.ListActiveBans(); len(active) != 1 || !active[0].Permanent() {
+		t.Errorf("Ожидался 1 бессрочный бан, получено %d", len(active))
+	}
+
+	if err := repo.LiftBan(permanent.ID, mod.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ban, _ := repo.GetActiveBan(troll.ID); ban != nil {
+		t.Errorf("Бан не снят: %+v", ban)
+	}
+	if history, err := repo.GetBansByUser(troll.ID); err != nil || len(history) != 3 {
+		t.Errorf("Ожидалось 3 бана в истории, получено %d: %v", len(history), err)
+	}
+}
+
+func TestReportResolutionAndVisibility(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	mod := &models.User{Email: "mod@example.com", Username: "mod"}
+	alice := &models.User{Email: "alice@example.com", Username: "alice"}
+	for _, u := range []*models.User{mod, alice} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+	pid, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Спам", Content: "Купите слона"})
+	postID := int(pid)
+	comment := &models.Comment{PostID: postID, UserID: alice.ID, Content: "Тоже спам"}
+	if err := repo.CreateComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateReport(mod.ID, &postID, nil, "Спам"); err != nil {
+		t.Fatal(err)
+	}
+	reports, err := repo.GetAllReports()
+	if err != nil || len(reports) != 1 || reports[0].Status != models.ReportOpen || reports[0].Resolved() {
+		t.Fatalf("Ожидалась 1 открытая жалоба: %v", err)
+	}
+	reportID := reports[0].ID
+
+	if err := repo.UpdateReportStatus(reportID, models.ReportActioned, models.ReportActionHide, "Реклама", mod.ID); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := repo.GetReportByID(reportID)
+	if err != nil || !rep.Resolved() || rep.Action != models.ReportActionHide || rep.ResolutionNote != "Реклама" ||
+		rep.ResolvedBy == nil || *rep.ResolvedBy != mod.ID || rep.ResolvedAt == nil {
+		t.Fatalf("Неверное решение по жалобе: %+v, %v", rep, err)
+	}
+
+	if err := repo.SetPostVisibility(postID, models.VisibilityHidden); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetCommentVisibility(comment.ID, models.VisibilityHidden); err != nil {
+		t.Fatal(err)
+	}
+	if posts, _ := repo.GetPosts("", ""); len(posts) != 0 {
+		t.Errorf("Скрытый пост в ленте: %d", len(posts))
+	}
+	if posts, _ := repo.GetPostsPage(0, 0, 10); len(posts) != 0 {
+		t.Errorf("Скрытый пост в API: %d", len(posts))
+	}
+	if comments, _ := repo.GetCommentsPage(postID, 0, 10); len(comments) != 0 {
+		t.Errorf("Скрытый комментарий в API: %d", len(comments))
+	}
+	// Автор и модераторы по-прежнему видят скрытый пост по ID
+	if post, err := repo.GetPostByID(postID); err != nil || post.Visibility != models.VisibilityHidden {
+		t.Errorf("Неверная видимость поста: %v", err)
+	}
+	if comments, _ := repo.GetCommentsByPostID(postID); len(comments) != 1 || comments[0].Visibility != models.VisibilityHidden {
+		t.Errorf("Скрытый комментарий должен оставаться в ветке")
+	}
+}
+
+func TestAutomodRules(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	admin := &models.User{Email: "admin@example.com", Username: "boss"}
+	if err := repo.CreateUser(admin, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+
+	rule := &models.AutomodRule{Name: "Спам", Kind: "words", Pattern: "casino", Action: "hold", Enabled: true, CreatedBy: admin.ID}
+	if err := repo.CreateAutomodRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	rule.Enabled = false
+	rule.Pattern = "casino\nviagra"
+	if err := repo.UpdateAutomodRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetAutomodRuleByID(rule.ID)
+	if err != nil || got.Enabled || got.Pattern != "casino\nviagra" || got.UpdatedAt == nil || got.CreatedBy != admin.ID {
+		t.Fatalf("Неверное правило после обновления: %+v, %v", got, err)
+	}
+
+	pid, _ := repo.CreatePost(&models.Post{UserID: admin.ID, Title: "Казино", Content: "Casino bonus", Visibility: models.VisibilityHidden})
+	if post, _ := repo.GetPostByID(int(pid)); post.Visibility != models.VisibilityHidden {
+		t.Errorf("Пост создан с видимостью %q", post.Visibility)
+	}
+	ruleID, postID := rule.ID, int(pid)
+	if err := repo.RecordAutomodMatch(&models.AutomodMatch{RuleID: &ruleID, RuleName: rule.Name, UserID: admin.ID,
+		TargetType: models.RevisionPost, TargetID: &postID, Action: "hold", Excerpt: "casino"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteAutomodRule(rule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ := repo.GetAutomodRules(); len(rules) != 0 {
+		t.Errorf("Правило не удалено")
+	}
+	// Совпадение остаётся в журнале без ссылки на правило
+	matches, err := repo.GetAutomodMatches(10, 0)
+	if err != nil || len(matches) != 1 || matches[0].RuleID != nil || matches[0].RuleName != "Спам" || matches[0].Username != "boss" {
+		t.Fatalf("Неверный журнал: %+v, %v", matches, err)
+	}
+
+	since := time.Now().Add(-time.Hour)
+	if n, err := repo.CountDuplicateContent(admin.ID, "  CASINO bonus ", since, models.RevisionComment, 0); err != nil || n != 1 {
+		t.Errorf("Ожидался 1 дубль, получено %d: %v", n, err)
+	}
+	// Редактируемый пост не считается дублем самого себя
+	if n, _ := repo.CountDuplicateContent(admin.ID, "Casino bonus", since, models.RevisionPost, postID); n != 0 {
+		t.Errorf("Пост посчитан дублем самого себя")
+	}
+}
+
+func TestModerationQueue(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	alice := &models.User{Email: "alice@example.com", Username: "alice"}
+	if err := repo.CreateUser(alice, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	pid, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Первый", Content: "Привет"})
+	postID := int(pid)
+	pending, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Второй", Content: "Ждёт", Visibility: models.VisibilityPending})
+	comment := &models.Comment{PostID: postID, UserID: alice.ID, Content: "Тоже ждёт", Visibility: models.VisibilityPending}
+	if err := repo.CreateComment(comment); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := repo.CountApprovedContent(alice.ID); err != nil || n != 1 {
+		t.Errorf("Ожидалось 1 опубликованное сообщение, получено %d: %v", n, err)
+	}
+	if posts, _ := repo.GetPosts("", ""); len(posts) != 1 || posts[0].ID != postID {
+		t.Errorf("Пост на модерации попал в ленту")
+	}
+	if comments, _ := repo.GetCommentsPage(postID, 0, 10); len(comments) != 0 {
+		t.Errorf("Комментарий на модерации попал в API: %d", len(comments))
+	}
+
+	items, err := repo.GetModerationQueue()
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Ожидалось 2 элемента в очереди: %v", err)
+	}
+	if items[0].Type != models.RevisionPost || items[0].ID != int(pending) || items[0].Username != "alice" ||
+		items[1].Type != models.RevisionComment || items[1].PostTitle != "Первый" {
+		t.Errorf("Неверная очередь: %+v, %+v", items[0], items[1])
+	}
+
+	if err := repo.SetCommentVisibility(comment.ID, models.VisibilityVisible); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := repo.GetModerationQueue(); len(items) != 1 {
+		t.Errorf("Одобренный комментарий остался в очереди")
+	}
+	if n, _ := repo.CountApprovedContent(alice.ID); n != 2 {
+		t.Errorf("Ожидалось 2 опубликованных сообщения, получено %d", n)
+	}
+}
+
+func TestSoftDeleteAndPurge(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	mod := &models.User{Email: "mod@example.com", Username: "mod"}
+	alice := &models.User{Email: "alice@example.com", Username: "alice"}
+	for _, u := range []*models.User{mod, alice} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+	pid, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Пост", Content: "Текст"})
+	postID := int(pid)
+	repo.AddImage(postID, "/static/uploads/a.png")
+	parent := &models.Comment{PostID: postID, UserID: alice.ID, Content: "Родитель"}
+	repo.CreateComment(parent)
+	reply := &models.Comment{PostID: postID, UserID: mod.ID, ParentID: &parent.ID, Content: "Ответ"}
+	repo.CreateComment(reply)
+	repo.CreateReport(mod.ID, &postID, nil, "спам")
+	repo.CreateReport(alice.ID, nil, &reply.ID, "грубость")
+	repo.CreateNotification(alice.ID, "comment", &mod.ID, nil, &reply.ID)
+
+	if err := repo.DeleteComment(parent.ID, mod.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetCommentByID(parent.ID); err == nil {
+		t.Errorf("Удалённый комментарий доступен по ID")
+	}
+	comments, _ := repo.GetCommentsByPostID(postID)
+	if len(comments) != 2 {
+		t.Fatalf("Удалённый комментарий должен остаться в ветке")
+	}
+	for _, c := range comments {
+		if (c.ID == parent.ID) != (c.DeletedAt != nil) {
+			t.Errorf("Неверная отметка удаления у комментария %d", c.ID)
+		}
+	}
+	// Комментарий с ответами не удаляется окончательно
+	if _, n, _, err := repo.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("Комментарий с ответами удалён: %d, %v", n, err)
+	}
+	if err := repo.RestoreComment(parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RestoreComment(parent.ID); err != sql.ErrNoRows {
+		t.Errorf("Повторное восстановление: %v", err)
+	}
+
+	// Комментарий в корзине уходит вместе с постом и не считается отдельно
+	if err := repo.DeleteComment(reply.ID, mod.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeletePost(postID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetPostByID(postID); err == nil {
+		t.Errorf("Удалённый пост доступен по ID")
+	}
+	if _, err := repo.GetCommentByID(reply.ID); err == nil {
+		t.Errorf("Комментарий удалённого поста доступен по ID")
+	}
+	if posts, _ := repo.GetPosts("", ""); len(posts) != 0 {
+		t.Errorf("Удалённый пост в ленте")
+	}
+	trash, err := repo.GetTrash()
+	if err != nil || len(trash) != 1 || trash[0].Type != models.RevisionPost || trash[0].DeletedByName != "alice" {
+		t.Fatalf("Неверное содержимое корзины: %v", err)
+	}
+
+	// Срок хранения ещё не истёк
+	if p, _, _, _ := repo.PurgeDeleted(time.Now().Add(-time.Hour)); p != 0 {
+		t.Errorf("Пост удалён до истечения срока")
+	}
+	p, c, images, err := repo.PurgeDeleted(time.Now().Add(time.Hour))
+	if err != nil || p != 1 || c != 0 {
+		t.Fatalf("Ожидалось окончательное удаление одного поста: %d постов, %d комментариев, %v", p, c, err)
+	}
+	if len(images) != 1 || images[0] != "/static/uploads/a.png" {
+		t.Errorf("Неверные изображения удалённого поста: %v", images)
+	}
+	if err := repo.RestorePost(postID); err != sql.ErrNoRows {
+		t.Errorf("Окончательно удалённый пост восстановлен: %v", err)
+	}
+	var left, imagesLeft int
+	repo.db.QueryRow("SELECT COUNT(*) FROM images").Scan(&imagesLeft)
+	if imagesLeft != 0 {
+		t.Errorf("Изображение удалённого поста осталось: %d", imagesLeft)
+	}
+	repo.db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ?", postID).Scan(&left)
+	if left != 0 {
+		t.Errorf("Комментарии удалённого поста остались: %d", left)
+	}
+	var reports, notifications int
+	repo.db.QueryRow("SELECT COUNT(*) FROM reports").Scan(&reports)
+	repo.db.QueryRow("SELECT COUNT(*) FROM notifications").Scan(&notifications)
+	if reports != 0 || notifications != 0 {
+		t.Errorf("Жалобы (%d) или уведомления (%d) удалённого поста остались", reports, notifications)
+	}
+
+	// Изображение, на которое ссылается другой пост, не удаляется
+	if used, err := repo.ImageInUse("/static/uploads/a.png"); err != nil || used {
+		t.Errorf("Изображение удалённого поста считается используемым: %v", err)
+	}
+	repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Ссылка", Content: "![](/static/uploads/a.png)"})
+	if used, _ := repo.ImageInUse("/static/uploads/a.png"); !used {
+		t.Errorf("Изображение из текста поста не считается используемым")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	mod := &models.User{Email: "mod@example.com", Username: "mod"}
+	admin := &models.User{Email: "admin@example.com", Username: "admin"}
+	for _, u := range []*models.User{mod, admin} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+	entries := []*models.AuditEntry{
+		{ActorID: mod.ID, Action: models.AuditPostEdit, TargetType: "post", TargetID: 1, Before: `{"title":"a"}`, After: `{"title":"b"}`, IP: "10.0.0.1"},
+		{ActorID: mod.ID, Action: models.AuditCommentDelete, TargetType: "comment", TargetID: 2, Before: `{"content":"c"}`, IP: "10.0.0.1"},
+		{ActorID: admin.ID, Action: models.AuditCategoryCreate, TargetType: "category", TargetID: 3, After: `{"name":"Go"}`, IP: "10.0.0.2"},
+	}
+	for _, e := range entries {
+		if err := repo.RecordAudit(e); err != nil {
+			t.Fatalf("Ошибка записи в журнал аудита: %v", err)
+		}
+	}
+
+	all, total, err := repo.ListAuditLog(models.AuditQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(all) != 3 || all[0].ID != entries[2].ID || all[0].ActorName != "admin" {
+		t.Errorf("Ожидались 3 записи, новые первыми, получено %d", total)
+	}
+	byMod, total, _ := repo.ListAuditLog(models.AuditQuery{Actor: "mod", Limit: 1})
+	if total != 2 || len(byMod) != 1 {
+		t.Errorf("Фильтр по автору: ожидалось 2 записи и страница из 1, получено %d и %d", total, len(byMod))
+	}
+	_, total, _ = repo.ListAuditLog(models.AuditQuery{Action: models.AuditPostEdit, Limit: -1})
+	if total != 1 {
+		t.Errorf("Фильтр по действию: ожидалась 1 запись, получено %d", total)
+	}
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	if list, _, _ := repo.ListAuditLog(models.AuditQuery{From: &tomorrow, Limit: -1}); len(list) != 0 {
+		t.Errorf("Фильтр по дате: записей из будущего быть не должно")
+	}
+
+	if _, err := repo.db.Exec("UPDATE audit_log SET action = 'x' WHERE id = ?", entries[0].ID); err == nil {
+		t.Errorf("Запись журнала аудита удалось изменить")
+	}
+	if _, err := repo.db.Exec("DELETE FROM audit_log"); err == nil {
+		t.Errorf("Записи журнала аудита удалось удалить")
+	}
+}
+
+func TestPostFlags(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	alice := &models.User{Email: "alice@example.com", Username: "alice"}
+	bob := &models.User{Email: "bob@example.com", Username: "bob"}
+	for _, u := range []*models.User{alice, bob} {
+		if err := repo.CreateUser(u, "password123"); err != nil {
+			t.Fatalf("Ошибка создания пользователя: %v", err)
+		}
+	}
+	var ids []int
+	for _, title := range []string{"Первый", "Второй", "Третий"} {
+		pid, _ := repo.CreatePost(&models.Post{UserID: alice.ID, Title: title, Content: "Текст"})
+		repo.AddPostCategory(int(pid), 1)
+		ids = append(ids, int(pid))
+	}
+
+	if err := repo.SetPostLocked(ids[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if post, _ := repo.GetPostByID(ids[0]); !post.Locked {
+		t.Errorf("Пост должен быть закрыт")
+	}
+	if post, _ := repo.GetPostByID(ids[1]); post.Locked {
+		t.Errorf("Пост не должен быть закрыт")
+	}
+
+	// Закреплённый везде пост идёт первым и в общем списке, и в категории;
+	// закреплённый в категории — только в ней
+	category := 1
+	repo.PinPost(ids[0], nil)
+	repo.PinPost(ids[1], &category)
+	if posts, _ := repo.GetPosts("", ""); len(posts) != 3 || posts[0].ID != ids[0] || posts[1].ID != ids[2] {
+		t.Errorf("В общем списке первым должен идти пост, закреплённый везде")
+	}
+	if posts, _ := repo.GetPosts("1", ""); len(posts) != 3 || posts[0].ID != ids[1] || posts[1].ID != ids[0] {
+		t.Errorf("В категории первым должен идти пост, закреплённый последним")
+	}
+	if posts, _ := repo.GetPosts("1", "likes"); len(posts) != 3 || posts[0].ID != ids[1] {
+		t.Errorf("Закреплённые посты должны идти первыми при любой сортировке")
+	}
+	repo.UnpinPost(ids[1])
+	if post, _ := repo.GetPostByID(ids[1]); post.PinnedAt != nil || post.PinnedCategoryID != nil {
+		t.Errorf("Пост должен быть откреплён")
+	}
+
+	repo.SetPostAnnouncement(ids[2], true)
+	if list, _ := repo.GetAnnouncements(bob.ID); len(list) != 1 || list[0].ID != ids[2] {
+		t.Fatalf("Ожидалось одно объявление")
+	}
+	repo.DismissAnnouncement(bob.ID, ids[2])
+	if list, _ := repo.GetAnnouncements(bob.ID); len(list) != 0 {
+		t.Errorf("Скрытое объявление не должно показываться")
+	}
+	if list, _ := repo.GetAnnouncements(alice.ID); len(list) != 1 {
+		t.Errorf("Объявление скрыто только для одного пользователя")
+	}
+	// Повторное объявление снова показывается всем
+	repo.SetPostAnnouncement(ids[2], false)
+	repo.SetPostAnnouncement(ids[2], true)
+	if list, _ := repo.GetAnnouncements(bob.ID); len(list) != 1 {
+		t.Errorf("Повторное объявление должно снова показываться")
+	}
+}
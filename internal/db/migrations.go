@@ -1,9 +1,54 @@
 package db
 
-// RunMigrations runs database migrations.
-func (r *Repository) RunMigrations() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// migration is a single numbered schema change. Up and Down run inside a
+// transaction together with the bookkeeping in schema_migrations.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus describes a known migration and whether it has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// execAll returns a migration step that executes the given statements in order.
+func execAll(queries ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrations is the ordered list of schema changes. Versions must be
+// sequential; never edit a migration that has already been released, add a
+// new one instead.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS users (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             email TEXT UNIQUE NOT NULL COLLATE NOCASE,
             username TEXT UNIQUE NOT NULL COLLATE NOCASE,
@@ -11,7 +56,7 @@ func (r *Repository) RunMigrations() error {
             role TEXT NOT NULL DEFAULT 'user',
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP
         )`,
-		`CREATE TABLE IF NOT EXISTS posts (
+			`CREATE TABLE IF NOT EXISTS posts (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             user_id INTEGER,
             title TEXT NOT NULL,
@@ -19,7 +64,7 @@ func (r *Repository) RunMigrations() error {
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
             FOREIGN KEY (user_id) REFERENCES users(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS comments (
+			`CREATE TABLE IF NOT EXISTS comments (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             post_id INTEGER,
             user_id INTEGER,
@@ -28,17 +73,17 @@ func (r *Repository) RunMigrations() error {
             FOREIGN KEY (post_id) REFERENCES posts(id),
             FOREIGN KEY (user_id) REFERENCES users(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS categories (
+			`CREATE TABLE IF NOT EXISTS categories (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             name TEXT UNIQUE NOT NULL
         )`,
-		`CREATE TABLE IF NOT EXISTS post_categories (
+			`CREATE TABLE IF NOT EXISTS post_categories (
             post_id INTEGER,
             category_id INTEGER,
             FOREIGN KEY (post_id) REFERENCES posts(id),
             FOREIGN KEY (category_id) REFERENCES categories(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS likes (
+			`CREATE TABLE IF NOT EXISTS likes (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             user_id INTEGER,
             post_id INTEGER,
@@ -49,21 +94,20 @@ func (r *Repository) RunMigrations() error {
             FOREIGN KEY (post_id) REFERENCES posts(id),
             FOREIGN KEY (comment_id) REFERENCES comments(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS sessions (
+			`CREATE TABLE IF NOT EXISTS sessions (
             session_id TEXT PRIMARY KEY,
             user_id INTEGER,
             expires DATETIME,
             FOREIGN KEY (user_id) REFERENCES users(id)
         )`,
-		// New tables:
-		`CREATE TABLE IF NOT EXISTS images (
+			`CREATE TABLE IF NOT EXISTS images (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             post_id INTEGER,
             file_path TEXT NOT NULL,
             uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
             FOREIGN KEY (post_id) REFERENCES posts(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS notifications (
+			`CREATE TABLE IF NOT EXISTS notifications (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             user_id INTEGER NOT NULL,
             type TEXT NOT NULL,
@@ -77,7 +121,7 @@ func (r *Repository) RunMigrations() error {
             FOREIGN KEY (post_id) REFERENCES posts(id),
             FOREIGN KEY (comment_id) REFERENCES comments(id)
         )`,
-		`CREATE TABLE IF NOT EXISTS reports (
+			`CREATE TABLE IF NOT EXISTS reports (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             reporter_id INTEGER NOT NULL,
             post_id INTEGER,
@@ -89,21 +133,706 @@ func (r *Repository) RunMigrations() error {
             FOREIGN KEY (post_id) REFERENCES posts(id),
             FOREIGN KEY (comment_id) REFERENCES comments(id)
         )`,
+			// Initial categories
+			`INSERT OR IGNORE INTO categories (name) VALUES ('Programming'), ('Games'), ('General')`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS reports`,
+			`DROP TABLE IF EXISTS notifications`,
+			`DROP TABLE IF EXISTS images`,
+			`DROP TABLE IF EXISTS sessions`,
+			`DROP TABLE IF EXISTS likes`,
+			`DROP TABLE IF EXISTS post_categories`,
+			`DROP TABLE IF EXISTS categories`,
+			`DROP TABLE IF EXISTS comments`,
+			`DROP TABLE IF EXISTS posts`,
+			`DROP TABLE IF EXISTS users`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "indexes on foreign keys",
+		Up: execAll(
+			`CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts(user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments(user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_post_categories_post_id ON post_categories(post_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories(category_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_likes_post_id ON likes(post_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_likes_comment_id ON likes(comment_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_notifications_user_id`,
+			`DROP INDEX IF EXISTS idx_sessions_user_id`,
+			`DROP INDEX IF EXISTS idx_likes_comment_id`,
+			`DROP INDEX IF EXISTS idx_likes_post_id`,
+			`DROP INDEX IF EXISTS idx_post_categories_category_id`,
+			`DROP INDEX IF EXISTS idx_post_categories_post_id`,
+			`DROP INDEX IF EXISTS idx_comments_user_id`,
+			`DROP INDEX IF EXISTS idx_comments_post_id`,
+			`DROP INDEX IF EXISTS idx_posts_user_id`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "full-text search index",
+		Up:      createSearchIndex,
+		Down: execAll(
+			`DROP TRIGGER IF EXISTS comments_fts_au`,
+			`DROP TRIGGER IF EXISTS comments_fts_ad`,
+			`DROP TRIGGER IF EXISTS comments_fts_ai`,
+			`DROP TRIGGER IF EXISTS posts_fts_au`,
+			`DROP TRIGGER IF EXISTS posts_fts_ad`,
+			`DROP TRIGGER IF EXISTS posts_fts_ai`,
+			`DROP TABLE IF EXISTS comments_fts`,
+			`DROP TABLE IF EXISTS posts_fts`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "threaded comment replies",
+		Up: execAll(
+			`ALTER TABLE comments ADD COLUMN parent_id INTEGER REFERENCES comments(id)`,
+			`CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_comments_parent_id`,
+			`ALTER TABLE comments DROP COLUMN parent_id`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "edit history",
+		Up: execAll(
+			`ALTER TABLE posts ADD COLUMN updated_at DATETIME`,
+			`ALTER TABLE comments ADD COLUMN updated_at DATETIME`,
+			`CREATE TABLE IF NOT EXISTS revisions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            target_type TEXT NOT NULL,
+            target_id INTEGER NOT NULL,
+            title TEXT,
+            content TEXT NOT NULL,
+            editor_id INTEGER,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (editor_id) REFERENCES users(id)
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_revisions_target ON revisions(target_type, target_id)`,
+			// Existing content becomes the first revision
+			`INSERT INTO revisions (target_type, target_id, title, content, editor_id, created_at)
+             SELECT 'post', id, title, content, user_id, created_at FROM posts`,
+			`INSERT INTO revisions (target_type, target_id, title, content, editor_id, created_at)
+             SELECT 'comment', id, NULL, content, user_id, created_at FROM comments`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_revisions_target`,
+			`DROP TABLE IF EXISTS revisions`,
+			`ALTER TABLE comments DROP COLUMN updated_at`,
+			`ALTER TABLE posts DROP COLUMN updated_at`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "api tokens",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            token_hash TEXT NOT NULL UNIQUE,
+            prefix TEXT NOT NULL,
+            scopes TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            last_used_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_api_tokens_user_id`,
+			`DROP TABLE IF EXISTS api_tokens`,
+		),
+	},
+	{
+		Version: 7,
+		Name:    "rendered content cache",
+		// Filled lazily by GetRenderedContent for existing revisions
+		Up:   execAll(`ALTER TABLE revisions ADD COLUMN rendered_html TEXT`),
+		Down: execAll(`ALTER TABLE revisions DROP COLUMN rendered_html`),
+	},
+	{
+		Version: 8,
+		Name:    "mail outbox",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS mail_outbox (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            recipient TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            text_body TEXT NOT NULL,
+            html_body TEXT NOT NULL DEFAULT '',
+            status TEXT NOT NULL DEFAULT 'pending',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT NOT NULL DEFAULT '',
+            next_attempt_at DATETIME NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            sent_at DATETIME
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_mail_outbox_due ON mail_outbox(status, next_attempt_at)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_mail_outbox_due`,
+			`DROP TABLE IF EXISTS mail_outbox`,
+		),
+	},
+	{
+		Version: 9,
+		Name:    "email verification",
+		// Accounts created before verification existed count as verified
+		Up: execAll(
+			`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`,
+			`UPDATE users SET email_verified_at = CURRENT_TIMESTAMP`,
+			`CREATE TABLE IF NOT EXISTS email_verifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            token_hash TEXT NOT NULL UNIQUE,
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id ON email_verifications(user_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_email_verifications_user_id`,
+			`DROP TABLE IF EXISTS email_verifications`,
+			`ALTER TABLE users DROP COLUMN email_verified_at`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "password resets",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS password_resets (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            token_hash TEXT NOT NULL UNIQUE,
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id)`,
+			// Every request is recorded, including ones for unknown emails, so
+			// that per-IP limits do not depend on whether the account exists
+			`CREATE TABLE IF NOT EXISTS password_reset_requests (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            ip TEXT NOT NULL,
+            created_at DATETIME NOT NULL
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_password_reset_requests_ip ON password_reset_requests(ip, created_at)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_password_reset_requests_ip`,
+			`DROP TABLE IF EXISTS password_reset_requests`,
+			`DROP INDEX IF EXISTS idx_password_resets_user_id`,
+			`DROP TABLE IF EXISTS password_resets`,
+		),
+	},
+	{
+		Version: 11,
+		Name:    "two-factor authentication",
+		// totp_secret is set at enrollment and only used once totp_enabled_at
+		// is set; totp_last_step stops a code from being used twice
+		Up: execAll(
+			`ALTER TABLE users ADD COLUMN totp_secret TEXT`,
+			`ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME`,
+			`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS recovery_codes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            code_hash TEXT NOT NULL,
+            used_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)`,
+			`CREATE TABLE IF NOT EXISTS login_challenges (
+            token_hash TEXT PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            expires_at DATETIME NOT NULL,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS login_challenges`,
+			`DROP INDEX IF EXISTS idx_recovery_codes_user_id`,
+			`DROP TABLE IF EXISTS recovery_codes`,
+			`ALTER TABLE users DROP COLUMN totp_last_step`,
+			`ALTER TABLE users DROP COLUMN totp_enabled_at`,
+			`ALTER TABLE users DROP COLUMN totp_secret`,
+		),
+	},
+	{
+		Version: 12,
+		Name:    "session metadata",
+		Up: execAll(
+			`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN created_at DATETIME`,
+			`ALTER TABLE sessions ADD COLUMN last_seen_at DATETIME`,
+			`UPDATE sessions SET created_at = CURRENT_TIMESTAMP, last_seen_at = CURRENT_TIMESTAMP`,
+			// Remember-me has to survive the two-factor step
+			`ALTER TABLE login_challenges ADD COLUMN remember INTEGER NOT NULL DEFAULT 0`,
+		),
+		Down: execAll(
+			`ALTER TABLE login_challenges DROP COLUMN remember`,
+			`ALTER TABLE sessions DROP COLUMN last_seen_at`,
+			`ALTER TABLE sessions DROP COLUMN created_at`,
+			`ALTER TABLE sessions DROP COLUMN ip`,
+			`ALTER TABLE sessions DROP COLUMN user_agent`,
+		),
+	},
+	{
+		Version: 13,
+		Name:    "login throttling",
+		Up: execAll(
+			// key is "user:<name>" or "ip:<address>"
+			`CREATE TABLE IF NOT EXISTS login_throttles (
+            key TEXT PRIMARY KEY,
+            failures INTEGER NOT NULL DEFAULT 0,
+            last_failure_at DATETIME NOT NULL,
+            locked_until DATETIME
+        )`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS login_throttles`,
+		),
+	},
+	{
+		Version: 14,
+		Name:    "role changes",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS role_changes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            old_role TEXT NOT NULL,
+            new_role TEXT NOT NULL,
+            changed_by INTEGER NOT NULL,
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            FOREIGN KEY (changed_by) REFERENCES users(id)
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_role_changes_user_id ON role_changes(user_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS role_changes`,
+		),
+	},
+	{
+		Version: 15,
+		Name:    "bans",
+		Up: execAll(
+			// expires_at is NULL for permanent bans
+			`CREATE TABLE IF NOT EXISTS bans (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            reason TEXT NOT NULL,
+            issued_by INTEGER NOT NULL,
+            created_at DATETIME NOT NULL,
+            expires_at DATETIME,
+            lifted_at DATETIME,
+            lifted_by INTEGER,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            FOREIGN KEY (issued_by) REFERENCES users(id),
+            FOREIGN KEY (lifted_by) REFERENCES users(id)
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_bans_user_id ON bans(user_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS bans`,
+		),
+	},
+	{
+		Version: 16,
+		Name:    "report resolution",
+		Up: execAll(
+			`ALTER TABLE reports ADD COLUMN action TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE reports ADD COLUMN resolution_note TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE reports ADD COLUMN resolved_by INTEGER`,
+			`ALTER TABLE reports ADD COLUMN resolved_at DATETIME`,
+			// Closing a report used to mean it had been dealt with
+			`UPDATE reports SET status = 'actioned' WHERE status = 'closed'`,
+			`CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status)`,
+			// posts.visibility and comments.visibility were first added here;
+			// they moved to version 25 so that they are rolled back on their own
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_reports_status`,
+			`UPDATE reports SET status = 'closed' WHERE status IN ('actioned', 'rejected')`,
+			`UPDATE reports SET status = 'open' WHERE status = 'in_review'`,
+			`ALTER TABLE reports DROP COLUMN resolved_at`,
+			`ALTER TABLE reports DROP COLUMN resolved_by`,
+			`ALTER TABLE reports DROP COLUMN resolution_note`,
+			`ALTER TABLE reports DROP COLUMN action`,
+		),
+	},
+	{
+		Version: 17,
+		Name:    "automod",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS automod_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            kind TEXT NOT NULL,
+            pattern TEXT NOT NULL DEFAULT '',
+            threshold INTEGER NOT NULL DEFAULT 0,
+            max_account_age_days INTEGER NOT NULL DEFAULT 0,
+            action TEXT NOT NULL,
+            enabled INTEGER NOT NULL DEFAULT 1,
+            created_by INTEGER NOT NULL,
+            created_at DATETIME NOT NULL,
+            updated_at DATETIME,
+            FOREIGN KEY (created_by) REFERENCES users(id)
+        )`,
+			// rule_name is copied so the log stays readable after a rule is
+			// deleted; target_id is NULL for rejected content
+			`CREATE TABLE IF NOT EXISTS automod_matches (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            rule_id INTEGER,
+            rule_name TEXT NOT NULL,
+            user_id INTEGER NOT NULL,
+            target_type TEXT NOT NULL,
+            target_id INTEGER,
+            action TEXT NOT NULL,
+            excerpt TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY (rule_id) REFERENCES automod_rules(id) ON DELETE SET NULL,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_automod_matches_created_at ON automod_matches(created_at)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS automod_matches`,
+			`DROP TABLE IF EXISTS automod_rules`,
+		),
+	},
+	{
+		Version: 18,
+		Name:    "soft delete",
+		Up: execAll(
+			`ALTER TABLE posts ADD COLUMN deleted_at DATETIME`,
+			`ALTER TABLE posts ADD COLUMN deleted_by INTEGER`,
+			`ALTER TABLE comments ADD COLUMN deleted_at DATETIME`,
+			`ALTER TABLE comments ADD COLUMN deleted_by INTEGER`,
+			`CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts(deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments(deleted_at)`,
+		),
+		Down: execAll(
+			// Deleted content was gone for good before this migration
+			`DELETE FROM comments WHERE deleted_at IS NOT NULL OR post_id IN (SELECT id FROM posts WHERE deleted_at IS NOT NULL)`,
+			`DELETE FROM posts WHERE deleted_at IS NOT NULL`,
+			`DROP INDEX IF EXISTS idx_comments_deleted_at`,
+			`DROP INDEX IF EXISTS idx_posts_deleted_at`,
+			`ALTER TABLE comments DROP COLUMN deleted_by`,
+			`ALTER TABLE comments DROP COLUMN deleted_at`,
+			`ALTER TABLE posts DROP COLUMN deleted_by`,
+			`ALTER TABLE posts DROP COLUMN deleted_at`,
+		),
+	},
+	{
+		Version: 19,
+		Name:    "audit log",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS audit_log (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            actor_id INTEGER NOT NULL,
+            action TEXT NOT NULL,
+            target_type TEXT NOT NULL,
+            target_id INTEGER NOT NULL,
+            before TEXT NOT NULL DEFAULT '',
+            after TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY (actor_id) REFERENCES users(id)
+        )`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at)`,
+			// The log is append-only: entries can be neither changed nor removed
+			`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+             BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+			`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+             BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+		),
+		Down: execAll(
+			`DROP TRIGGER IF EXISTS audit_log_no_delete`,
+			`DROP TRIGGER IF EXISTS audit_log_no_update`,
+			`DROP TABLE IF EXISTS audit_log`,
+		),
+	},
+	{
+		Version: 20,
+		Name:    "post flags",
+		Up: execAll(
+			`ALTER TABLE posts ADD COLUMN locked INTEGER NOT NULL DEFAULT 0`,
+			// pinned_category_id is NULL for a post pinned on every listing
+			`ALTER TABLE posts ADD COLUMN pinned_at DATETIME`,
+			`ALTER TABLE posts ADD COLUMN pinned_category_id INTEGER`,
+			`ALTER TABLE posts ADD COLUMN announced_at DATETIME`,
+			`CREATE TABLE IF NOT EXISTS announcement_dismissals (
+            user_id INTEGER NOT NULL,
+            post_id INTEGER NOT NULL,
+            dismissed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (user_id, post_id),
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            FOREIGN KEY (post_id) REFERENCES posts(id)
+        )`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS announcement_dismissals`,
+			`ALTER TABLE posts DROP COLUMN announced_at`,
+			`ALTER TABLE posts DROP COLUMN pinned_category_id`,
+			`ALTER TABLE posts DROP COLUMN pinned_at`,
+			`ALTER TABLE posts DROP COLUMN locked`,
+		),
+	},
+	{
+		Version: 21,
+		Name:    "clear sent mail bodies",
+		// Bodies of delivered mail may hold reset and verification tokens
+		Up: execAll(
+			`UPDATE mail_outbox SET text_body = '', html_body = '' WHERE status != 'pending'`,
+		),
+		Down: execAll(),
+	},
+	{
+		Version: 22,
+		Name:    "session ids",
+		// The public session ID was the implicit rowid, which VACUUM may
+		// renumber; rebuild the table with a real key, keeping current IDs
+		Up: execAll(
+			`CREATE TABLE sessions_new (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            session_id TEXT NOT NULL UNIQUE,
+            user_id INTEGER,
+            expires DATETIME,
+            user_agent TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            created_at DATETIME,
+            last_seen_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        )`,
+			`INSERT INTO sessions_new (id, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at)
+             SELECT rowid, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at FROM sessions`,
+			`DROP TABLE sessions`,
+			`ALTER TABLE sessions_new RENAME TO sessions`,
+			`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		),
+		Down: execAll(
+			`CREATE TABLE sessions_old (
+            session_id TEXT PRIMARY KEY,
+            user_id INTEGER,
+            expires DATETIME,
+            user_agent TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            created_at DATETIME,
+            last_seen_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        )`,
+			`INSERT INTO sessions_old (rowid, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at)
+             SELECT id, session_id, user_id, expires, user_agent, ip, created_at, last_seen_at FROM sessions`,
+			`DROP TABLE sessions`,
+			`ALTER TABLE sessions_old RENAME TO sessions`,
+			`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		),
+	},
+	{
+		Version: 23,
+		Name:    "prefix heading ids",
+		// Cached renderings with heading IDs from before they were prefixed
+		// are dropped and rendered again on the next view
+		Up: execAll(
+			`UPDATE revisions SET rendered_html = NULL
+             WHERE rendered_html LIKE '%<h_ id="%' AND rendered_html NOT LIKE '%<h_ id="user-content-%'`,
+		),
+		Down: execAll(),
+	},
+	{
+		Version: 24,
+		Name:    "strip snippet markers",
+		// Snippets mark matches with \x02 and \x03, so the index must not
+		// contain them
+		Up:   reindexSearch(strippedText),
+		Down: reindexSearch(rawText),
+	},
+	{
+		Version: 25,
+		Name:    "content visibility",
+		// Databases that ran version 16 before the split already have the
+		// columns
+		Up: func(tx *sql.Tx) error {
+			for _, table := range []string{"posts", "comments"} {
+				if err := addColumnIfMissing(tx, table, "visibility", "TEXT NOT NULL DEFAULT 'visible'"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: execAll(
+			`ALTER TABLE comments DROP COLUMN visibility`,
+			`ALTER TABLE posts DROP COLUMN visibility`,
+		),
+	},
+}
+
+// addColumnIfMissing adds a column unless the table already has it.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// LatestSchemaVersion returns the highest migration version known to this binary.
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable creates the schema_migrations bookkeeping table.
+func (r *Repository) ensureMigrationsTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration version (0 for an empty database).
+func (r *Repository) SchemaVersion() (int, error) {
+	if err := r.ensureMigrationsTable(); err != nil {
+		return 0, err
 	}
+	var version int
+	err := r.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
 
-	for _, query := range queries {
-		if _, err := r.db.Exec(query); err != nil {
+// RunMigrations applies all pending migrations, each in its own transaction.
+// It refuses to run against a schema newer than the binary.
+func (r *Repository) RunMigrations() error {
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); current > latest {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := r.applyMigration(m, true); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// MigrateDown rolls back applied migrations, newest first, until the schema
+// is at targetVersion.
+func (r *Repository) MigrateDown(targetVersion int) error {
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); current > latest {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, current, latest)
+	}
 
-	// Add initial categories
-	categories := []string{"Programming", "Games", "General"}
-	for _, cat := range categories {
-		if _, err := r.db.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", cat); err != nil {
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= targetVersion {
+			continue
+		}
+		if err := r.applyMigration(m, false); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
+
+// applyMigration runs one migration step and records it in schema_migrations.
+func (r *Repository) applyMigration(m migration, up bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	step, direction := m.Up, "up"
+	if !up {
+		step, direction = m.Down, "down"
+	}
+	if step == nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d (%s) has no %s step", m.Version, m.Name, direction)
+	}
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d (%s) %s: %w", m.Version, m.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrationStatuses lists every known migration with its applied state.
+func (r *Repository) MigrationStatuses() ([]*MigrationStatus, error) {
+	if err := r.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, m := range migrations {
+		s := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
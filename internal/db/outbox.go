@@ -0,0 +1,78 @@
+package db
+
+import (
+	"forum/internal/models"
+	"time"
+)
+
+// EnqueueMail stores an email in the outbox for the mail worker.
+func (r *Repository) EnqueueMail(m *models.OutboxMail) error {
+	now := time.Now()
+	res, err := r.db.Exec(`INSERT INTO mail_outbox (recipient, subject, text_body, html_body, status, next_attempt_at, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?)`, m.To, m.Subject, m.TextBody, m.HTMLBody, models.MailPending, now, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	m.ID = int(id)
+	m.Status = models.MailPending
+	m.NextAttemptAt = now
+	m.CreatedAt = now
+	return nil
+}
+
+// GetDueMail returns up to limit pending emails whose next attempt is due, oldest first.
+func (r *Repository) GetDueMail(now time.Time, limit int) ([]*models.OutboxMail, error) {
+	rows, err := r.db.Query(`SELECT id, recipient, subject, text_body, html_body, status, attempts, last_error, next_attempt_at, created_at, sent_at
+                             FROM mail_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		models.MailPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []*models.OutboxMail
+	for rows.Next() {
+		m := &models.OutboxMail{}
+		if err := rows.Scan(&m.ID, &m.To, &m.Subject, &m.TextBody, &m.HTMLBody, &m.Status, &m.Attempts,
+			&m.LastError, &m.NextAttemptAt, &m.CreatedAt, &m.SentAt); err != nil {
+			return nil, err
+		}
+		mails = append(mails, m)
+	}
+	return mails, rows.Err()
+}
+
+// MarkMailSent records a successful delivery. The body is cleared, since it
+// may hold a one-time token that must not outlive the mail.
+func (r *Repository) MarkMailSent(mailID int) error {
+	_, err := r.db.Exec(`UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ?,
+                         text_body = '', html_body = '' WHERE id = ?`,
+		models.MailSent, time.Now(), mailID)
+	return err
+}
+
+// MarkMailAttemptFailed records a failed delivery. The email is retried at
+// nextAttempt, or given up on (status failed) when nextAttempt is nil, which
+// clears its body like MarkMailSent.
+func (r *Repository) MarkMailAttemptFailed(mailID int, sendErr string, nextAttempt *time.Time) error {
+	if nextAttempt == nil {
+		_, err := r.db.Exec(`UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = ?,
+                             text_body = '', html_body = '' WHERE id = ?`,
+			models.MailFailed, sendErr, mailID)
+		return err
+	}
+	_, err := r.db.Exec(`UPDATE mail_outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+		sendErr, *nextAttempt, mailID)
+	return err
+}
+
+// DeleteOldMail removes sent and failed emails queued before the given time
+// and returns how many were removed. Pending emails are kept.
+func (r *Repository) DeleteOldMail(before time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM mail_outbox WHERE status != ? AND created_at < ?", models.MailPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"forum/internal/models"
+	"strings"
+)
+
+// Cursor-paginated listings used by the JSON API. A cursor is the ID of the
+// last item of the previous page; 0 starts from the beginning.
+
+// GetPostsPage returns up to limit posts older than beforeID (newest first),
+// optionally restricted to a category.
+func (r *Repository) GetPostsPage(categoryID, beforeID, limit int) ([]*models.Post, error) {
+	query := `SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.visibility,
+              p.locked, p.pinned_at, p.pinned_category_id, p.announced_at FROM posts p WHERE p.visibility = 'visible' AND p.deleted_at IS NULL`
+	var args []interface{}
+	if categoryID > 0 {
+		query += ` AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.id AND pc.category_id = ?)`
+		args = append(args, categoryID)
+	}
+	if beforeID > 0 {
+		query += ` AND p.id < ?`
+		args = append(args, beforeID)
+	}
+	query += ` ORDER BY p.id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*models.Post
+	for rows.Next() {
+		post := &models.Post{}
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt, &post.Visibility,
+			&post.Locked, &post.PinnedAt, &post.PinnedCategoryID, &post.AnnouncedAt); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// GetCommentsPage returns up to limit comments of a post with IDs greater
+// than afterID (oldest first).
+func (r *Repository) GetCommentsPage(postID, afterID, limit int) ([]*models.Comment, error) {
+	rows, err := r.db.Query(`SELECT id, post_id, user_id, parent_id, content, created_at, updated_at, visibility
+                             FROM comments WHERE post_id = ? AND id > ? AND visibility = 'visible' AND deleted_at IS NULL ORDER BY id ASC LIMIT ?`, postID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		c := &models.Comment{}
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentID, &c.Content, &c.CreatedAt, &c.UpdatedAt, &c.Visibility); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// GetNotificationsPage returns up to limit notifications of a user older
+// than beforeID (newest first).
+func (r *Repository) GetNotificationsPage(userID, beforeID, limit int) ([]*models.Notification, error) {
+	query := `SELECT id, user_id, type, from_user_id, post_id, comment_id, created_at, is_read FROM notifications WHERE user_id = ?`
+	args := []interface{}{userID}
+	if beforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifs []*models.Notification
+	for rows.Next() {
+		n := &models.Notification{}
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.FromUserID, &n.PostID, &n.CommentID, &n.CreatedAt, &n.IsRead); err != nil {
+			return nil, err
+		}
+		notifs = append(notifs, n)
+	}
+	return notifs, rows.Err()
+}
+
+// GetReportsPage returns up to limit reports older than beforeID (newest
+// first), optionally filtered by status.
+func (r *Repository) GetReportsPage(status string, beforeID, limit int) ([]*models.Report, error) {
+	query := `SELECT ` + reportColumns + ` FROM reports WHERE 1 = 1`
+	var args []interface{}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if beforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	return r.queryReports(query, args...)
+}
+
+// GetReportByID returns a report by ID.
+func (r *Repository) GetReportByID(reportID int) (*models.Report, error) {
+	return scanReport(r.db.QueryRow(`SELECT `+reportColumns+` FROM reports WHERE id = ?`, reportID))
+}
+
+// GetCategoriesByPostID returns all categories of a post.
+func (r *Repository) GetCategoriesByPostID(postID int) ([]*models.Category, error) {
+	rows, err := r.db.Query(`SELECT c.id, c.name FROM categories c JOIN post_categories pc ON c.id = pc.category_id
+                             WHERE pc.post_id = ? ORDER BY c.name ASC`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*models.Category
+	for rows.Next() {
+		cat := &models.Category{}
+		if err := rows.Scan(&cat.ID, &cat.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategoryByName returns a category by its name.
+func (r *Repository) GetCategoryByName(name string) (*models.Category, error) {
+	cat := &models.Category{}
+	err := r.db.QueryRow("SELECT id, name FROM categories WHERE name = ?", name).Scan(&cat.ID, &cat.Name)
+	if err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// SearchUsernames returns up to limit users whose username starts with prefix,
+// shortest names first.
+func (r *Repository) SearchUsernames(prefix string, limit int) ([]*models.User, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(prefix))
+	rows, err := r.db.Query(`SELECT id, username FROM users WHERE username LIKE ? ESCAPE '\'
+                             ORDER BY length(username), username LIMIT ?`, escaped+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		u := &models.User{}
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidResetToken is returned for an unknown, used or expired password
+// reset token.
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// RecordPasswordResetRequest logs a reset request from ip, whether or not
+// the email belongs to an account.
+func (r *Repository) RecordPasswordResetRequest(ip string) error {
+	_, err := r.db.Exec("INSERT INTO password_reset_requests (ip, created_at) VALUES (?, ?)", ip, time.Now())
+	return err
+}
+
+// CountPasswordResetRequestsByIP counts reset requests from ip since the
+// given time.
+func (r *Repository) CountPasswordResetRequestsByIP(ip string, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM password_reset_requests WHERE ip = ? AND created_at > ?", ip, since).Scan(&count)
+	return count, err
+}
+
+// DeleteExpiredPasswordResets removes reset requests logged before the
+// given time, along with reset tokens issued before it that have been used
+// or have expired. Newer rows are kept because the rate limits count them.
+func (r *Repository) DeleteExpiredPasswordResets(before time.Time) error {
+	if _, err := r.db.Exec("DELETE FROM password_reset_requests WHERE created_at < ?", before); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("DELETE FROM password_resets WHERE created_at < ? AND (used_at IS NOT NULL OR expires_at < ?)",
+		before, time.Now())
+	return err
+}
+
+// CreatePasswordReset issues a single-use reset token for the user that
+// expires after ttl, and returns its secret. Only the hash is stored.
+func (r *Repository) CreatePasswordReset(userID int, ttl time.Duration) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = r.db.Exec(`INSERT INTO password_resets (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		userID, hashToken(secret), now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// CountPasswordResetsSince counts the reset tokens issued to the user since
+// the given time.
+func (r *Repository) CountPasswordResetsSince(userID int, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM password_resets WHERE user_id = ? AND created_at > ?", userID, since).Scan(&count)
+	return count, err
+}
+
+// CheckPasswordReset reports whether a reset token is still usable without
+// consuming it.
+func (r *Repository) CheckPasswordReset(secret string) error {
+	var id int
+	err := r.db.QueryRow(`SELECT id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`,
+		hashToken(secret), time.Now()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	return err
+}
+
+// ResetPassword consumes a reset token and sets the owner's new password.
+// All of the user's outstanding reset tokens and login challenges are
+// invalidated, and since the link proves access to the mailbox, the email
+// counts as verified. It returns the ID of the user; signing them out is
+// left to the caller.
+func (r *Repository) ResetPassword(secret, newPassword string) (int, error) {
+	// Hash before opening the transaction: bcrypt is slow on purpose
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM password_resets
+                       WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, hashToken(secret), now).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
+		return 0, err
+	}
+	// A challenge started with the old password must not finish the sign-in
+	if _, err := tx.Exec("DELETE FROM login_challenges WHERE user_id = ?", userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE users SET password_hash = ?, email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?",
+		string(hash), now, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"forum/internal/models"
+	"time"
+)
+
+// SetPostLocked locks or unlocks a post.
+func (r *Repository) SetPostLocked(postID int, locked bool) error {
+	_, err := r.db.Exec("UPDATE posts SET locked = ? WHERE id = ?", locked, postID)
+	return err
+}
+
+// PinPost pins a post to the top of the listing of the given category, or
+// of every listing if categoryID is nil.
+func (r *Repository) PinPost(postID int, categoryID *int) error {
+	_, err := r.db.Exec("UPDATE posts SET pinned_at = ?, pinned_category_id = ? WHERE id = ?", time.Now(), categoryID, postID)
+	return err
+}
+
+// UnpinPost takes a post off the top of the listings.
+func (r *Repository) UnpinPost(postID int) error {
+	_, err := r.db.Exec("UPDATE posts SET pinned_at = NULL, pinned_category_id = NULL WHERE id = ?", postID)
+	return err
+}
+
+// SetPostAnnouncement makes a post a site-wide announcement or ends it.
+// Announcing a post again shows it to everyone, including users who
+// dismissed it before.
+func (r *Repository) SetPostAnnouncement(postID int, announce bool) error {
+	if !announce {
+		_, err := r.db.Exec("UPDATE posts SET announced_at = NULL WHERE id = ?", postID)
+		return err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM announcement_dismissals WHERE post_id = ?", postID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE posts SET announced_at = ? WHERE id = ?", time.Now(), postID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAnnouncements returns the published announcements the user has not
+// dismissed, newest first. A userID of 0 (a guest) gets all of them.
+func (r *Repository) GetAnnouncements(userID int) ([]*models.Post, error) {
+	rows, err := r.db.Query(`SELECT p.id, p.user_id, p.title, p.announced_at FROM posts p
+                             WHERE p.announced_at IS NOT NULL AND p.visibility = 'visible' AND p.deleted_at IS NULL
+                             AND NOT EXISTS (SELECT 1 FROM announcement_dismissals d WHERE d.post_id = p.id AND d.user_id = ?)
+                             ORDER BY p.announced_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*models.Post
+	for rows.Next() {
+		post := &models.Post{}
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.AnnouncedAt); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// DismissAnnouncement hides an announcement from the user.
+func (r *Repository) DismissAnnouncement(userID, postID int) error {
+	_, err := r.db.Exec("INSERT OR IGNORE INTO announcement_dismissals (user_id, post_id) VALUES (?, ?)", userID, postID)
+	return err
+}
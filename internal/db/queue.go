@@ -0,0 +1,46 @@
+package db
+
+import (
+	"forum/internal/models"
+)
+
+// CountApprovedContent counts the user's published posts and comments.
+func (r *Repository) CountApprovedContent(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT
+                              (SELECT COUNT(*) FROM posts WHERE user_id = ? AND visibility = 'visible' AND deleted_at IS NULL) +
+                              (SELECT COUNT(*) FROM comments WHERE user_id = ? AND visibility = 'visible' AND deleted_at IS NULL)`,
+		userID, userID).Scan(&n)
+	return n, err
+}
+
+// GetModerationQueue returns the pending posts and comments, oldest first.
+func (r *Repository) GetModerationQueue() ([]*models.QueuedItem, error) {
+	rows, err := r.db.Query(`SELECT 'post', p.id, p.id, p.title, p.user_id, COALESCE(u.username, ''), p.content, p.created_at,
+                             COALESCE((SELECT GROUP_CONCAT(DISTINCT m.rule_name) FROM automod_matches m
+                                       WHERE m.target_type = 'post' AND m.target_id = p.id AND m.action = 'hold'), '')
+                             FROM posts p LEFT JOIN users u ON u.id = p.user_id
+                             WHERE p.visibility = 'pending' AND p.deleted_at IS NULL
+                             UNION ALL
+                             SELECT 'comment', c.id, c.post_id, p.title, c.user_id, COALESCE(u.username, ''), c.content, c.created_at,
+                             COALESCE((SELECT GROUP_CONCAT(DISTINCT m.rule_name) FROM automod_matches m
+                                       WHERE m.target_type = 'comment' AND m.target_id = c.id AND m.action = 'hold'), '')
+                             FROM comments c JOIN posts p ON p.id = c.post_id LEFT JOIN users u ON u.id = c.user_id
+                             WHERE c.visibility = 'pending' AND c.deleted_at IS NULL AND p.deleted_at IS NULL
+                             ORDER BY 8 ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*models.QueuedItem
+	for rows.Next() {
+		it := &models.QueuedItem{}
+		if err := rows.Scan(&it.Type, &it.ID, &it.PostID, &it.PostTitle, &it.UserID, &it.Username, &it.Content,
+			&it.CreatedAt, &it.Rules); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
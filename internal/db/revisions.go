@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"forum/internal/markdown"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+// addRevision stores a version of a post or comment inside tx, together with
+// its rendered HTML.
+func addRevision(tx *sql.Tx, targetType string, targetID int, title *string, content, html string, editorID int) error {
+	_, err := tx.Exec(`INSERT INTO revisions (target_type, target_id, title, content, rendered_html, editor_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		targetType, targetID, title, content, html, editorID, time.Now())
+	return err
+}
+
+// renderContent renders markdown, linking @mentions of existing users. It
+// queries the database, so it must not be called while a transaction is open
+// (an in-memory database has one connection per transaction).
+func (r *Repository) renderContent(content string) string {
+	return markdown.Render(content, func(username string) bool {
+		_, err := r.GetUserByUsername(username)
+		return err == nil
+	})
+}
+
+// GetRenderedContent returns the cached HTML of the latest revision of each
+// given post or comment, keyed by target ID. Revisions without a cached
+// rendering (e.g. from before the cache existed) are rendered and stored.
+// Targets without any revision are missing from the result.
+func (r *Repository) GetRenderedContent(targetType string, targetIDs ...int) (map[int]string, error) {
+	rendered := make(map[int]string, len(targetIDs))
+	if len(targetIDs) == 0 {
+		return rendered, nil
+	}
+	args := []interface{}{targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(targetIDs)), ",")
+	rows, err := r.db.Query(`SELECT id, target_id, content, rendered_html FROM revisions
+                             WHERE id IN (SELECT MAX(id) FROM revisions WHERE target_type = ? AND target_id IN (`+placeholders+`) GROUP BY target_id)`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	type stale struct {
+		revisionID, targetID int
+		content              string
+	}
+	var missing []stale
+	for rows.Next() {
+		var revisionID, targetID int
+		var content string
+		var html sql.NullString
+		if err := rows.Scan(&revisionID, &targetID, &content, &html); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !html.Valid {
+			missing = append(missing, stale{revisionID, targetID, content})
+			continue
+		}
+		rendered[targetID] = html.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rendering resolves mentions with further queries, so it happens after
+	// the result set is closed.
+	for _, m := range missing {
+		html := r.renderContent(m.content)
+		if _, err := r.db.Exec(`UPDATE revisions SET rendered_html = ? WHERE id = ?`, html, m.revisionID); err != nil {
+			return nil, err
+		}
+		rendered[m.targetID] = html
+	}
+	return rendered, nil
+}
+
+// GetRevisions returns all revisions of a post or comment, oldest first.
+func (r *Repository) GetRevisions(targetType string, targetID int) ([]*models.Revision, error) {
+	rows, err := r.db.Query(`SELECT id, target_type, target_id, title, content, COALESCE(editor_id, 0), created_at
+                             FROM revisions WHERE target_type = ? AND target_id = ? ORDER BY id ASC`, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*models.Revision
+	for rows.Next() {
+		rev := &models.Revision{}
+		err := rows.Scan(&rev.ID, &rev.TargetType, &rev.TargetID, &rev.Title, &rev.Content, &rev.EditorID, &rev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetRevisionByID returns a single revision.
+func (r *Repository) GetRevisionByID(revisionID int) (*models.Revision, error) {
+	rev := &models.Revision{}
+	err := r.db.QueryRow(`SELECT id, target_type, target_id, title, content, COALESCE(editor_id, 0), created_at
+                          FROM revisions WHERE id = ?`, revisionID).
+		Scan(&rev.ID, &rev.TargetType, &rev.TargetID, &rev.Title, &rev.Content, &rev.EditorID, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// RollbackToRevision restores a post or comment to the content of an earlier
+// revision. The restore itself is recorded as a new revision by editorID, so
+// history is never rewritten. A post or comment in the trash, or a comment
+// of a post in the trash, is reported as sql.ErrNoRows and left as it is.
+func (r *Repository) RollbackToRevision(revisionID, editorID int) (*models.Revision, error) {
+	rev, err := r.GetRevisionByID(revisionID)
+	if err != nil {
+		return nil, err
+	}
+	switch rev.TargetType {
+	case models.RevisionPost:
+		if _, err := r.GetPostByID(rev.TargetID); err != nil {
+			return nil, err
+		}
+		title := ""
+		if rev.Title != nil {
+			title = *rev.Title
+		}
+		err = r.UpdatePost(rev.TargetID, editorID, title, rev.Content)
+	case models.RevisionComment:
+		if _, err := r.GetCommentByID(rev.TargetID); err != nil {
+			return nil, err
+		}
+		err = r.UpdateComment(rev.TargetID, editorID, rev.Content)
+	default:
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"forum/internal/models"
+	"math"
+	"strings"
+)
+
+// ftsModule returns the best full-text module compiled into SQLite. FTS5 is
+// only available when go-sqlite3 is built with the sqlite_fts5 tag, so plain
+// builds fall back to FTS4.
+func ftsModule(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) string {
+	var enabled bool
+	if err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err == nil && enabled {
+		return "fts5"
+	}
+	return "fts4"
+}
+
+// createSearchIndex creates the posts_fts and comments_fts tables, the
+// triggers that keep them in sync with posts and comments, and indexes the
+// existing rows. Row IDs in the index match posts.id and comments.id.
+func createSearchIndex(tx *sql.Tx) error {
+	module := ftsModule(tx)
+	statements := []string{
+		fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING %s(title, content)`, module),
+		fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING %s(content)`, module),
+	}
+	statements = append(statements, searchTriggers(rawText)...)
+	statements = append(statements,
+		`INSERT INTO posts_fts (rowid, title, content) SELECT id, title, content FROM posts`,
+		`INSERT INTO comments_fts (rowid, content) SELECT id, content FROM comments`,
+	)
+	return execAll(statements...)(tx)
+}
+
+// rawText indexes a column as it is.
+func rawText(column string) string {
+	return column
+}
+
+// strippedText indexes a column without the snippet match markers, so that
+// markers typed into a post cannot pass for a match in its snippet.
+func strippedText(column string) string {
+	return fmt.Sprintf("replace(replace(%s, char(2), ''), char(3), '')", column)
+}
+
+// searchTriggers returns the statements that create the triggers keeping
+// the search index in sync, with text applied to every indexed column.
+func searchTriggers(text func(column string) string) []string {
+	return []string{
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ai AFTER INSERT ON posts BEGIN
+            INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, ` + text("new.title") + `, ` + text("new.content") + `);
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ad AFTER DELETE ON posts BEGIN
+            DELETE FROM posts_fts WHERE rowid = old.id;
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_au AFTER UPDATE OF title, content ON posts BEGIN
+            UPDATE posts_fts SET title = ` + text("new.title") + `, content = ` + text("new.content") + ` WHERE rowid = old.id;
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS comments_fts_ai AFTER INSERT ON comments BEGIN
+            INSERT INTO comments_fts (rowid, content) VALUES (new.id, ` + text("new.content") + `);
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS comments_fts_ad AFTER DELETE ON comments BEGIN
+            DELETE FROM comments_fts WHERE rowid = old.id;
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS comments_fts_au AFTER UPDATE OF content ON comments BEGIN
+            UPDATE comments_fts SET content = ` + text("new.content") + ` WHERE rowid = old.id;
+        END`,
+	}
+}
+
+// reindexSearch returns a migration step that recreates the search index
+// triggers and reindexes every post and comment, with text applied to every
+// indexed column.
+func reindexSearch(text func(column string) string) func(tx *sql.Tx) error {
+	statements := []string{
+		`DROP TRIGGER IF EXISTS comments_fts_au`,
+		`DROP TRIGGER IF EXISTS comments_fts_ad`,
+		`DROP TRIGGER IF EXISTS comments_fts_ai`,
+		`DROP TRIGGER IF EXISTS posts_fts_au`,
+		`DROP TRIGGER IF EXISTS posts_fts_ad`,
+		`DROP TRIGGER IF EXISTS posts_fts_ai`,
+	}
+	statements = append(statements, searchTriggers(text)...)
+	statements = append(statements,
+		`DELETE FROM posts_fts`,
+		`DELETE FROM comments_fts`,
+		`INSERT INTO posts_fts (rowid, title, content) SELECT id, `+text("title")+`, `+text("content")+` FROM posts`,
+		`INSERT INTO comments_fts (rowid, content) SELECT id, `+text("content")+` FROM comments`,
+	)
+	return execAll(statements...)
+}
+
+// searchModule reports which full-text module the existing index was created with.
+func (r *Repository) searchModule() string {
+	var ddl string
+	err := r.db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'posts_fts'").Scan(&ddl)
+	if err == nil && strings.Contains(strings.ToLower(ddl), "fts5") {
+		return "fts5"
+	}
+	return "fts4"
+}
+
+// buildMatchExpression turns free text into a safe MATCH expression: every
+// word is quoted so user input can never be parsed as FTS query syntax, and
+// the last word matches as a prefix. FTS4 expects the prefix star inside the
+// quotes, FTS5 after them.
+func buildMatchExpression(text, module string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		w = strings.ReplaceAll(w, `"`, `""`)
+		if i == len(words)-1 {
+			if module == "fts5" {
+				words[i] = `"` + w + `"*`
+			} else {
+				words[i] = `"` + w + `*"`
+			}
+			continue
+		}
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// BM25 parameters, the same as FTS5 uses.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// fts4Rank scores an FTS4 match with Okapi BM25 the way FTS5's bm25() does,
+// from matchinfo(table, 'pcnalx') and optional per-column weights (1.0 by
+// default). Like bm25() it returns the score negated, so better matches sort
+// first. It is registered as the SQL function fts4_rank.
+func fts4Rank(matchinfo []byte, weights ...float64) float64 {
+	info := make([]uint32, len(matchinfo)/4)
+	for i := range info {
+		info[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(info) < 3 {
+		return 0
+	}
+	phrases, columns, rows := int(info[0]), int(info[1]), float64(info[2])
+	if len(info) < 3+2*columns+3*columns*phrases {
+		return 0
+	}
+	avgLen, rowLen, hits := info[3:3+columns], info[3+columns:3+2*columns], info[3+2*columns:]
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns; c++ {
+			x := hits[3*(c+p*columns):]
+			tf, docs := float64(x[0]), float64(x[2])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log((rows - docs + 0.5) / (docs + 0.5))
+			if idf <= 0 {
+				idf = 1e-6
+			}
+			norm := 1.0
+			if avgLen[c] > 0 {
+				norm = 1 - bm25B + bm25B*float64(rowLen[c])/float64(avgLen[c])
+			}
+			weight := 1.0
+			if c < len(weights) {
+				weight = weights[c]
+			}
+			score += weight * idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return -score
+}
+
+// SearchPosts searches post titles, post content and comment content and
+// returns hits ordered by relevance, best first. FTS5 ranks with bm25() and
+// the FTS4 fallback with fts4_rank(), which computes the same formula.
+func (r *Repository) SearchPosts(q models.SearchQuery) ([]*models.SearchResult, error) {
+	module := r.searchModule()
+	match := buildMatchExpression(q.Text, module)
+	if match == "" {
+		return nil, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+
+	var postSnippet, postRank, commentSnippet, commentRank string
+	start, end := models.SnippetMatchStart, models.SnippetMatchEnd
+	if module == "fts5" {
+		postSnippet = fmt.Sprintf("snippet(posts_fts, -1, '%s', '%s', '…', 24)", start, end)
+		postRank = "bm25(posts_fts, 2.0, 1.0)"
+		commentSnippet = fmt.Sprintf("snippet(comments_fts, 0, '%s', '%s', '…', 24)", start, end)
+		commentRank = "bm25(comments_fts)"
+	} else {
+		postSnippet = fmt.Sprintf("snippet(posts_fts, '%s', '%s', '…', -1, 24)", start, end)
+		postRank = "fts4_rank(matchinfo(posts_fts, 'pcnalx'), 2.0, 1.0)"
+		commentSnippet = fmt.Sprintf("snippet(comments_fts, '%s', '%s', '…', 0, 24)", start, end)
+		commentRank = "fts4_rank(matchinfo(comments_fts, 'pcnalx'))"
+	}
+
+	var filters string
+	var filterArgs []interface{}
+	if q.Author != "" {
+		filters += " AND u.username = ?"
+		filterArgs = append(filterArgs, strings.ToLower(q.Author))
+	}
+	if q.CategoryID > 0 {
+		filters += " AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.id AND pc.category_id = ?)"
+		filterArgs = append(filterArgs, q.CategoryID)
+	}
+
+	var postDates, commentDates string
+	var dateArgs []interface{}
+	if q.From != nil {
+		postDates += " AND p.created_at >= ?"
+		commentDates += " AND c.created_at >= ?"
+		dateArgs = append(dateArgs, *q.From)
+	}
+	if q.To != nil {
+		postDates += " AND p.created_at < ?"
+		commentDates += " AND c.created_at < ?"
+		dateArgs = append(dateArgs, *q.To)
+	}
+
+	query := `SELECT 'post', p.id, NULL, p.title, ` + postSnippet + `, p.user_id, COALESCE(u.username, ''), p.created_at, ` + postRank + ` AS rank
+              FROM posts_fts
+              JOIN posts p ON p.id = posts_fts.rowid
+              LEFT JOIN users u ON u.id = p.user_id
+              WHERE posts_fts MATCH ? AND p.visibility = 'visible' AND p.deleted_at IS NULL` + filters + postDates + `
+              UNION ALL
+              SELECT 'comment', p.id, c.id, p.title, ` + commentSnippet + `, c.user_id, COALESCE(u.username, ''), c.created_at, ` + commentRank + ` AS rank
+              FROM comments_fts
+              JOIN comments c ON c.id = comments_fts.rowid
+              JOIN posts p ON p.id = c.post_id
+              LEFT JOIN users u ON u.id = c.user_id
+              WHERE comments_fts MATCH ? AND p.visibility = 'visible' AND c.visibility = 'visible'
+                    AND p.deleted_at IS NULL AND c.deleted_at IS NULL` + filters + commentDates + `
+              ORDER BY rank ASC, 8 DESC
+              LIMIT ?`
+
+	var args []interface{}
+	args = append(args, match)
+	args = append(args, filterArgs...)
+	args = append(args, dateArgs...)
+	args = append(args, match)
+	args = append(args, filterArgs...)
+	args = append(args, dateArgs...)
+	args = append(args, q.Limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.SearchResult
+	for rows.Next() {
+		res := &models.SearchResult{}
+		err := rows.Scan(&res.Kind, &res.PostID, &res.CommentID, &res.Title, &res.Snippet,
+			&res.UserID, &res.Username, &res.CreatedAt, &res.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"time"
+)
+
+// RecordLoginFailure counts a failed sign-in against key and returns the new
+// count. A count whose last failure is older than window starts over. The
+// upsert is a single statement, so concurrent failures are all counted.
+func (r *Repository) RecordLoginFailure(key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	err := r.db.QueryRow(`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 1, ?)
+                          ON CONFLICT(key) DO UPDATE SET
+                              failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+                              locked_until = CASE WHEN last_failure_at < ? THEN NULL ELSE locked_until END,
+                              last_failure_at = excluded.last_failure_at
+                          RETURNING failures`,
+		key, now, now.Add(-window), now.Add(-window)).Scan(&failures)
+	return failures, err
+}
+
+// SetLoginLockout blocks sign-in for key until the given time.
+func (r *Repository) SetLoginLockout(key string, until time.Time) error {
+	_, err := r.db.Exec("UPDATE login_throttles SET locked_until = ? WHERE key = ?", until, key)
+	return err
+}
+
+// GetLoginLockout returns the time key is locked until, or nil if it has
+// never been locked.
+func (r *Repository) GetLoginLockout(key string) (*time.Time, error) {
+	var until sql.NullTime
+	err := r.db.QueryRow("SELECT locked_until FROM login_throttles WHERE key = ?", key).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil || !until.Valid {
+		return nil, err
+	}
+	return &until.Time, nil
+}
+
+// ClearLoginFailures forgets the failures and any lockout of key.
+func (r *Repository) ClearLoginFailures(key string) error {
+	_, err := r.db.Exec("DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// RefundLoginFailure takes back one failure of key.
+func (r *Repository) RefundLoginFailure(key string) error {
+	_, err := r.db.Exec("UPDATE login_throttles SET failures = failures - 1 WHERE key = ? AND failures > 0", key)
+	return err
+}
+
+// ListLoginThrottles returns the keys with failures since the given time,
+// current lockouts first.
+func (r *Repository) ListLoginThrottles(since time.Time) ([]*models.LoginThrottle, error) {
+	rows, err := r.db.Query(`SELECT key, failures, last_failure_at, locked_until FROM login_throttles
+                             WHERE last_failure_at > ? OR locked_until > ?
+                             ORDER BY locked_until IS NULL, locked_until DESC, last_failure_at DESC`, since, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var throttles []*models.LoginThrottle
+	for rows.Next() {
+		t := &models.LoginThrottle{}
+		var until sql.NullTime
+		if err := rows.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &until); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			t.LockedUntil = &until.Time
+		}
+		throttles = append(throttles, t)
+	}
+	return throttles, rows.Err()
+}
+
+// DeleteStaleLoginThrottles removes keys whose last failure is before the
+// given time and that are not locked.
+func (r *Repository) DeleteStaleLoginThrottles(before time.Time) error {
+	_, err := r.db.Exec("DELETE FROM login_throttles WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		before, time.Now())
+	return err
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix marks forum tokens so they are easy to spot in logs and
+// secret scanners.
+const apiTokenPrefix = "fk_"
+
+// ErrInvalidAPIToken is returned when a bearer token is unknown.
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+// hashToken returns the hex SHA-256 of a secret token. Only hashes of
+// API, verification and similar tokens are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecret returns 32 random bytes, hex encoded.
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateAPIToken generates a new token for the user and returns its secret.
+// The secret is not stored and cannot be recovered later.
+func (r *Repository) CreateAPIToken(userID int, name string, scopes []string) (string, *models.APIToken, error) {
+	random, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	secret := apiTokenPrefix + random
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:len(apiTokenPrefix)+8],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	res, err := r.db.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, name, hashToken(secret), token.Prefix, strings.Join(scopes, ","), token.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	id, _ := res.LastInsertId()
+	token.ID = int(id)
+	return secret, token, nil
+}
+
+// GetAPITokensByUser lists a user's tokens, newest first.
+func (r *Repository) GetAPITokensByUser(userID int) ([]*models.APIToken, error) {
+	rows, err := r.db.Query(`SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+                             FROM api_tokens WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.APIToken
+	for rows.Next() {
+		t := &models.APIToken{}
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = splitScopes(scopes)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// AuthenticateAPIToken looks up a token by its secret and records the time
+// it was used.
+func (r *Repository) AuthenticateAPIToken(secret string) (*models.APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+	t := &models.APIToken{}
+	var scopes string
+	err := r.db.QueryRow(`SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+                          FROM api_tokens WHERE token_hash = ?`, hashToken(secret)).
+		Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.LastUsedAt)
+	if err != nil {
+		return nil, ErrInvalidAPIToken
+	}
+	t.Scopes = splitScopes(scopes)
+
+	now := time.Now()
+	if _, err := r.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, t.ID); err != nil {
+		return nil, err
+	}
+	t.LastUsedAt = &now
+	return t, nil
+}
+
+// DeleteAPIToken revokes one of the user's tokens.
+func (r *Repository) DeleteAPIToken(tokenID, userID int) error {
+	_, err := r.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	return err
+}
+
+// DeleteAllUserAPITokens revokes every token of the user.
+func (r *Repository) DeleteAllUserAPITokens(userID int) error {
+	_, err := r.db.Exec("DELETE FROM api_tokens WHERE user_id = ?", userID)
+	return err
+}
+
+func splitScopes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"database/sql"
+	"forum/internal/models"
+	"path/filepath"
+	"time"
+)
+
+// GetTrash returns the deleted posts and comments, most recently deleted
+// first. Comments of a deleted post go with it and are not listed.
+func (r *Repository) GetTrash() ([]*models.TrashedItem, error) {
+	rows, err := r.db.Query(`SELECT 'post', p.id, p.id, p.title, p.user_id, COALESCE(u.username, ''), p.content, p.created_at,
+                             p.deleted_at, COALESCE(p.deleted_by, 0), COALESCE(d.username, '')
+                             FROM posts p LEFT JOIN users u ON u.id = p.user_id LEFT JOIN users d ON d.id = p.deleted_by
+                             WHERE p.deleted_at IS NOT NULL
+                             UNION ALL
+                             SELECT 'comment', c.id, c.post_id, p.title, c.user_id, COALESCE(u.username, ''), c.content, c.created_at,
+                             c.deleted_at, COALESCE(c.deleted_by, 0), COALESCE(d.username, '')
+                             FROM comments c JOIN posts p ON p.id = c.post_id
+                             LEFT JOIN users u ON u.id = c.user_id LEFT JOIN users d ON d.id = c.deleted_by
+                             WHERE c.deleted_at IS NOT NULL AND p.deleted_at IS NULL
+                             ORDER BY 9 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*models.TrashedItem
+	for rows.Next() {
+		it := &models.TrashedItem{}
+		if err := rows.Scan(&it.Type, &it.ID, &it.PostID, &it.PostTitle, &it.UserID, &it.Username, &it.Content,
+			&it.CreatedAt, &it.DeletedAt, &it.DeletedBy, &it.DeletedByName); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// RestorePost takes a post out of the trash. It returns sql.ErrNoRows if the
+// post is not in the trash.
+func (r *Repository) RestorePost(postID int) error {
+	return restore(r.db, "UPDATE posts SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND deleted_at IS NOT NULL", postID)
+}
+
+// RestoreComment takes a comment out of the trash. It returns sql.ErrNoRows
+// if the comment is not in the trash.
+func (r *Repository) RestoreComment(commentID int) error {
+	return restore(r.db, "UPDATE comments SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND deleted_at IS NOT NULL", commentID)
+}
+
+func restore(db *sql.DB, query string, id int) error {
+	res, err := db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeDeleted removes for good the posts and comments deleted before the
+// given time, with their likes, categories, images, notifications, reports
+// and edit history. A deleted comment that still has replies keeps its place
+// in the thread until the replies are gone. It returns how many posts and
+// comments were removed and the paths of the purged posts' images; the image
+// files are left to the caller.
+func (r *Repository) PurgeDeleted(before time.Time) (posts, comments int, images []string, err error) {
+	postIDs, err := r.queryIDs("SELECT id FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < ?", before)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	// Comments of a purged post go with it and are not counted twice
+	commentIDs, err := r.queryIDs(`SELECT c.id FROM comments c WHERE c.deleted_at IS NOT NULL AND c.deleted_at < ?
+                                   AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+                                   AND c.post_id NOT IN (SELECT id FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < ?)`,
+		before, before)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for _, id := range postIDs {
+		paths, err := imagePathsTx(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, nil, err
+		}
+		images = append(images, paths...)
+		if err := execAllTx(tx, id,
+			"DELETE FROM likes WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+			"DELETE FROM notifications WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+			"DELETE FROM reports WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+			"DELETE FROM revisions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)",
+			"DELETE FROM comments WHERE post_id = ?",
+			"DELETE FROM likes WHERE post_id = ?",
+			"DELETE FROM post_categories WHERE post_id = ?",
+			"DELETE FROM images WHERE post_id = ?",
+			"DELETE FROM notifications WHERE post_id = ?",
+			"DELETE FROM reports WHERE post_id = ?",
+			"DELETE FROM announcement_dismissals WHERE post_id = ?",
+			"DELETE FROM revisions WHERE target_type = 'post' AND target_id = ?",
+			"DELETE FROM posts WHERE id = ?",
+		); err != nil {
+			tx.Rollback()
+			return 0, 0, nil, err
+		}
+	}
+	for _, id := range commentIDs {
+		if err := execAllTx(tx, id,
+			"DELETE FROM likes WHERE comment_id = ?",
+			"DELETE FROM notifications WHERE comment_id = ?",
+			"DELETE FROM reports WHERE comment_id = ?",
+			"DELETE FROM revisions WHERE target_type = 'comment' AND target_id = ?",
+			"DELETE FROM comments WHERE id = ?",
+		); err != nil {
+			tx.Rollback()
+			return 0, 0, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, nil, err
+	}
+	return len(postIDs), len(commentIDs), images, nil
+}
+
+func imagePathsTx(tx *sql.Tx, postID int) ([]string, error) {
+	rows, err := tx.Query("SELECT file_path FROM images WHERE post_id = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// execAllTx runs each statement with id as its only argument.
+func execAllTx(tx *sql.Tx, id int, statements ...string) error {
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) queryIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ImageInUse reports whether any post still shows the image, either as its
+// attachment or by linking to it from its text, or a comment links to it.
+func (r *Repository) ImageInUse(path string) (bool, error) {
+	var used bool
+	name := filepath.Base(path)
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM images WHERE file_path = ?)
+                          OR EXISTS (SELECT 1 FROM posts WHERE instr(content, ?) > 0)
+                          OR EXISTS (SELECT 1 FROM comments WHERE instr(content, ?) > 0)`, path, name, name).Scan(&used)
+	return used, err
+}
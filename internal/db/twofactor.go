@@ -0,0 +1,189 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at a time.
+const RecoveryCodeCount = 10
+
+// maxChallengeAttempts is how many wrong codes a login challenge accepts
+// before the password has to be entered again.
+const maxChallengeAttempts = 5
+
+// ErrInvalidLoginChallenge is returned for an unknown, expired or exhausted
+// login challenge.
+var ErrInvalidLoginChallenge = errors.New("invalid login challenge")
+
+// SetPendingTOTPSecret stores a new authenticator secret for a user who has
+// not finished enrolling yet.
+func (r *Repository) SetPendingTOTPSecret(userID int, secret string) error {
+	_, err := r.db.Exec("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled_at IS NULL", secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret,
+// records step as used and returns a fresh set of recovery codes.
+func (r *Repository) EnableTOTP(userID int, step int64) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET totp_enabled_at = ?, totp_last_step = ?
+                         WHERE id = ? AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`, time.Now(), step, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication and drops the secret and
+// recovery codes.
+func (r *Repository) DisableTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It returns false if
+// that step or a later one was already used, so a code cannot be replayed.
+func (r *Repository) UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := r.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes and returns the
+// new ones. Only their hashes are stored.
+func (r *Repository) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns
+// false if the code is unknown or already used.
+func (r *Repository) UseRecoveryCode(userID int, code string) (bool, error) {
+	res, err := r.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now(), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func (r *Repository) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in a typed code.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// CreateLoginChallenge starts the second sign-in step for a user who has
+// entered the correct password, and returns the challenge secret. remember
+// carries the remember-me choice over to the session.
+func (r *Repository) CreateLoginChallenge(userID int, remember bool, ttl time.Duration) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec("INSERT INTO login_challenges (token_hash, user_id, remember, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(secret), userID, remember, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetLoginChallenge returns the user of a pending login challenge and their
+// remember-me choice.
+func (r *Repository) GetLoginChallenge(secret string) (userID int, remember bool, err error) {
+	err = r.db.QueryRow("SELECT user_id, remember FROM login_challenges WHERE token_hash = ? AND expires_at > ? AND attempts < ?",
+		hashToken(secret), time.Now(), maxChallengeAttempts).Scan(&userID, &remember)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, ErrInvalidLoginChallenge
+	}
+	return userID, remember, err
+}
+
+// FailLoginChallenge counts a wrong code against the challenge.
+func (r *Repository) FailLoginChallenge(secret string) error {
+	_, err := r.db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?", hashToken(secret))
+	return err
+}
+
+// DeleteExpiredLoginChallenges removes challenges that have expired or have
+// run out of attempts.
+func (r *Repository) DeleteExpiredLoginChallenges() error {
+	_, err := r.db.Exec("DELETE FROM login_challenges WHERE expires_at < ? OR attempts >= ?", time.Now(), maxChallengeAttempts)
+	return err
+}
+
+// DeleteLoginChallenge removes a finished challenge along with any expired
+// ones.
+func (r *Repository) DeleteLoginChallenge(secret string) error {
+	_, err := r.db.Exec("DELETE FROM login_challenges WHERE token_hash = ? OR expires_at < ?", hashToken(secret), time.Now())
+	return err
+}
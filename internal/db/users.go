@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+// ErrUnknownRole is returned when a role is not one of models.Roles.
+var ErrUnknownRole = errors.New("unknown role")
+
+// ListUsers returns one page of the users matching q, newest first, and the
+// number of matches across all pages.
+func (r *Repository) ListUsers(q models.UserQuery) ([]*models.UserSummary, int, error) {
+	where := ` WHERE 1 = 1`
+	var args []interface{}
+	if text := strings.TrimSpace(q.Text); text != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(text))
+		where += ` AND (u.username LIKE ? ESCAPE '\' OR u.email LIKE ? ESCAPE '\')`
+		args = append(args, "%"+escaped+"%", "%"+escaped+"%")
+	}
+	if q.Role != "" {
+		where += ` AND u.role = ?`
+		args = append(args, q.Role)
+	}
+	switch q.Verified {
+	case "yes":
+		where += ` AND u.email_verified_at IS NOT NULL`
+	case "no":
+		where += ` AND u.email_verified_at IS NULL`
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM users u`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(`SELECT u.id, u.email, u.username, u.role, u.created_at, u.email_verified_at, u.totp_enabled_at,
+                                    (SELECT COUNT(*) FROM posts WHERE user_id = u.id),
+                                    (SELECT COUNT(*) FROM comments WHERE user_id = u.id)
+                             FROM users u`+where+` ORDER BY u.id DESC LIMIT ? OFFSET ?`,
+		append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*models.UserSummary
+	for rows.Next() {
+		u := &models.UserSummary{}
+		if err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.Role, &u.CreatedAt, &u.EmailVerifiedAt, &u.TOTPEnabledAt,
+			&u.PostCount, &u.CommentCount); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+// ChangeUserRole gives the user a new role and records the change together
+// with the admin who made it. Setting the current role again is a no-op.
+func (r *Repository) ChangeUserRole(userID int, newRole string, changedBy int) error {
+	known := false
+	for _, role := range models.Roles {
+		if role == newRole {
+			known = true
+		}
+	}
+	if !known {
+		return ErrUnknownRole
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldRole string
+	if err := tx.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&oldRole); err != nil {
+		return err
+	}
+	if oldRole == newRole {
+		return nil
+	}
+	if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", newRole, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO role_changes (user_id, old_role, new_role, changed_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, oldRole, newRole, changedBy, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRoleChanges returns the role history of a user, newest first.
+func (r *Repository) GetRoleChanges(userID int) ([]*models.RoleChange, error) {
+	rows, err := r.db.Query(`SELECT rc.id, rc.user_id, rc.old_role, rc.new_role, rc.changed_by, COALESCE(u.username, ''), rc.created_at
+                             FROM role_changes rc LEFT JOIN users u ON u.id = rc.changed_by
+                             WHERE rc.user_id = ? ORDER BY rc.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*models.RoleChange
+	for rows.Next() {
+		c := &models.RoleChange{}
+		if err := rows.Scan(&c.ID, &c.UserID, &c.OldRole, &c.NewRole, &c.ChangedBy, &c.ChangedByName, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// GetReportsByReporter returns the reports a user has filed, newest first.
+func (r *Repository) GetReportsByReporter(userID int) ([]*models.Report, error) {
+	return r.queryReports(`SELECT `+reportColumns+` FROM reports
+                           WHERE reporter_id = ? ORDER BY id DESC`, userID)
+}
+
+// GetReportsAgainstUser returns the reports on a user's posts and comments,
+// newest first.
+func (r *Repository) GetReportsAgainstUser(userID int) ([]*models.Report, error) {
+	return r.queryReports(`SELECT `+reportColumns+` FROM reports
+                           WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)
+                              OR comment_id IN (SELECT id FROM comments WHERE user_id = ?)
+                           ORDER BY id DESC`, userID, userID)
+}
+
+func (r *Repository) queryReports(query string, args ...interface{}) ([]*models.Report, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*models.Report
+	for rows.Next() {
+		rep, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
+
+// InvalidatePassword clears the user's password so that signing in is only
+// possible after a password reset.
+func (r *Repository) InvalidatePassword(userID int) error {
+	_, err := r.db.Exec("UPDATE users SET password_hash = '' WHERE id = ?", userID)
+	return err
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrInvalidVerificationToken is returned for an unknown, used or expired
+// email verification token.
+var ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+// CreateEmailVerification issues a single-use verification token for the
+// user that expires after ttl, and returns its secret. Only the hash is
+// stored.
+func (r *Repository) CreateEmailVerification(userID int, ttl time.Duration) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = r.db.Exec(`INSERT INTO email_verifications (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		userID, hashToken(secret), now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// VerifyEmail consumes a verification token, marks the owner's email as
+// verified and invalidates the user's other outstanding tokens. It returns
+// the ID of the verified user.
+func (r *Repository) VerifyEmail(secret string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM email_verifications
+                       WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, hashToken(secret), now).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE email_verifications SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL", now, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// CountEmailVerificationsSince counts the verification tokens issued to the
+// user since the given time. It is used to rate limit resending.
+func (r *Repository) CountEmailVerificationsSince(userID int, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM email_verifications WHERE user_id = ? AND created_at > ?", userID, since).Scan(&count)
+	return count, err
+}
+
+// DeleteExpiredEmailVerifications removes verification tokens issued before
+// the given time that have been used or have expired. Newer tokens are kept
+// because resending is rate limited by them.
+func (r *Repository) DeleteExpiredEmailVerifications(before time.Time) error {
+	_, err := r.db.Exec("DELETE FROM email_verifications WHERE created_at < ? AND (used_at IS NOT NULL OR expires_at < ?)",
+		before, time.Now())
+	return err
+}
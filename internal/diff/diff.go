@@ -0,0 +1,131 @@
+// Package diff computes line-based differences between two texts.
+package diff
+
+import "strings"
+
+// Op is the kind of change applied to a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is one line of a diff.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Kind returns the operation name, handy for CSS classes in templates.
+func (l Line) Kind() string {
+	switch l.Op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	}
+	return "equal"
+}
+
+// Prefix returns the unified-diff marker for the line.
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	}
+	return " "
+}
+
+// maxCells bounds the LCS table. Texts whose changed middle part is larger
+// are shown as a full replacement instead of a minimal diff.
+const maxCells = 4000000
+
+// Lines returns the line diff that turns a into b.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var out []Line
+	for _, line := range x[:prefix] {
+		out = append(out, Line{Op: Equal, Text: line})
+	}
+	out = append(out, middle(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, line := range x[len(x)-suffix:] {
+		out = append(out, Line{Op: Equal, Text: line})
+	}
+	return out
+}
+
+// middle diffs two slices with no common prefix or suffix using a longest
+// common subsequence table.
+func middle(x, y []string) []Line {
+	n, m := len(x), len(y)
+	var out []Line
+	if n*m > maxCells {
+		for _, line := range x {
+			out = append(out, Line{Op: Delete, Text: line})
+		}
+		for _, line := range y {
+			out = append(out, Line{Op: Insert, Text: line})
+		}
+		return out
+	}
+
+	// lcs[i*w+j] is the LCS length of x[i:] and y[j:]
+	w := m + 1
+	lcs := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, Line{Op: Equal, Text: x[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			out = append(out, Line{Op: Delete, Text: x[i]})
+			i++
+		default:
+			out = append(out, Line{Op: Insert, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		out = append(out, Line{Op: Delete, Text: x[i]})
+	}
+	for ; j < m; j++ {
+		out = append(out, Line{Op: Insert, Text: y[j]})
+	}
+	return out
+}
+
+// split breaks text into lines, normalizing Windows line endings.
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
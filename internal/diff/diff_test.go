@@ -0,0 +1,32 @@
+package diff
+
+import "testing"
+
+func render(lines []Line) string {
+	var out string
+	for _, l := range lines {
+		out += l.Prefix() + l.Text + "\n"
+	}
+	return out
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"identical", "a\nb", "a\nb", " a\n b\n"},
+		{"insert", "a\nc", "a\nb\nc", " a\n+b\n c\n"},
+		{"delete", "a\nb\nc", "a\nc", " a\n-b\n c\n"},
+		{"replace", "a\nb\nc", "a\nx\nc", " a\n-b\n+x\n c\n"},
+		{"from empty", "", "a", "+a\n"},
+		{"to empty", "a", "", "-a\n"},
+		{"crlf", "a\r\nb", "a\nb", " a\n b\n"},
+	}
+	for _, tt := range tests {
+		if got := render(Lines(tt.a, tt.b)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
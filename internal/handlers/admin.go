@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"forum/internal/throttle"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminUsersPerPage = 50
+	// Пользователь не запрашивал сброс, поэтому ссылка живёт дольше обычной
+	adminResetTokenTTL = 24 * time.Hour
+)
+
+// AdminHandler serves the administration pages. Every page requires the
+// admin role.
+type AdminHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+	mailer      *mail.Mailer
+}
+
+func NewAdminHandler(repo *db.Repository, log *log.Logger, projectRoot string, mailer *mail.Mailer) *AdminHandler {
+	return &AdminHandler{repo: repo, log: log, projectRoot: projectRoot, mailer: mailer}
+}
+
+// requireAdmin answers 403 unless the user is an admin.
+func (h *AdminHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Доступ запрещён", h.projectRoot)
+		return false
+	}
+	return true
+}
+
+// render executes a template from static/ with the CSRF token and CSP nonce
+// added to data.
+func (h *AdminHandler) render(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, name)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	data["CSRFToken"] = csrfToken(r)
+	data["CSPNonce"] = cspNonce(r)
+	tmpl.Execute(w, data)
+}
+
+// Lockouts lists the usernames and addresses with recent failed sign-ins.
+func (h *AdminHandler) Lockouts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	now := time.Now()
+	throttles, err := h.repo.ListLoginThrottles(now.Add(-throttle.DefaultUserPolicy.Window))
+	if err != nil {
+		h.log.Printf("Ошибка загрузки блокировок входа: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	h.render(w, r, "admin_lockouts.html", map[string]interface{}{
+		"Throttles": throttles,
+		"Now":       now,
+		"Error":     r.URL.Query().Get("error"),
+		"Success":   r.URL.Query().Get("success"),
+	})
+}
+
+// ClearLockout forgets the failed sign-ins of one username or address and
+// lifts its lockout.
+func (h *AdminHandler) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if !strings.HasPrefix(key, throttle.UserPrefix) && !strings.HasPrefix(key, throttle.IPPrefix) {
+		http.Redirect(w, r, "/admin/lockouts?error=Неверный ключ блокировки", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.ClearLoginFailures(key); err != nil {
+		h.log.Printf("Ошибка снятия блокировки входа: %v", err)
+		http.Redirect(w, r, "/admin/lockouts?error=Ошибка снятия блокировки", http.StatusSeeOther)
+		return
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	h.log.Printf("Блокировка входа %s снята администратором %d", key, userID)
+	audit(h.repo, h.log, r, models.AuditLockoutClear, "lockout", 0, map[string]string{"key": key}, nil)
+	http.Redirect(w, r, "/admin/lockouts?success=Блокировка снята", http.StatusSeeOther)
+}
+
+// Users lists the users matching the search and filters, a page at a time.
+func (h *AdminHandler) Users(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	page, _ := strconv.Atoi(params.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	query := models.UserQuery{
+		Text:     strings.TrimSpace(params.Get("q")),
+		Role:     params.Get("role"),
+		Verified: params.Get("verified"),
+		Limit:    adminUsersPerPage,
+		Offset:   (page - 1) * adminUsersPerPage,
+	}
+	users, total, err := h.repo.ListUsers(query)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки пользователей: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	pageURL := func(p int) string {
+		v := url.Values{}
+		for _, key := range []string{"q", "role", "verified"} {
+			if params.Get(key) != "" {
+				v.Set(key, params.Get(key))
+			}
+		}
+		v.Set("page", strconv.Itoa(p))
+		return "/admin/users?" + v.Encode()
+	}
+	data := map[string]interface{}{
+		"Users":   users,
+		"Total":   total,
+		"Query":   query,
+		"Roles":   models.Roles,
+		"Page":    page,
+		"Error":   params.Get("error"),
+		"Success": params.Get("success"),
+	}
+	if page > 1 {
+		data["PrevURL"] = pageURL(page - 1)
+	}
+	if page*adminUsersPerPage < total {
+		data["NextURL"] = pageURL(page + 1)
+	}
+	h.render(w, r, "admin_users.html", data)
+}
+
+// User shows one user with their content, reports, sessions, bans and role
+// history, and the forms to manage the account.
+func (h *AdminHandler) User(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	user, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	posts, err := h.repo.GetPostsByUser(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки постов пользователя %d: %v", user.ID, err)
+	}
+	comments, err := h.repo.GetCommentsByUser(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки комментариев пользователя %d: %v", user.ID, err)
+	}
+	reportsFiled, err := h.repo.GetReportsByReporter(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки жалоб пользователя %d: %v", user.ID, err)
+	}
+	reportsAgainst, err := h.repo.GetReportsAgainstUser(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки жалоб на пользователя %d: %v", user.ID, err)
+	}
+	sessions, err := h.repo.GetUserSessions(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки сессий пользователя %d: %v", user.ID, err)
+	}
+	roleChanges, err := h.repo.GetRoleChanges(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки истории ролей пользователя %d: %v", user.ID, err)
+	}
+	bans, err := h.repo.GetBansByUser(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки банов пользователя %d: %v", user.ID, err)
+	}
+
+	currentID, _ := r.Context().Value("userID").(int)
+	h.render(w, r, "admin_user.html", map[string]interface{}{
+		"User":           user,
+		"IsSelf":         user.ID == currentID,
+		"Roles":          models.Roles,
+		"Posts":          posts,
+		"Comments":       comments,
+		"ReportsFiled":   reportsFiled,
+		"ReportsAgainst": reportsAgainst,
+		"Sessions":       sessions,
+		"RoleChanges":    roleChanges,
+		"Bans":           bans,
+		"Now":            time.Now(),
+		"Error":          r.URL.Query().Get("error"),
+		"Success":        r.URL.Query().Get("success"),
+	})
+}
+
+// ChangeRole sets a user's role. Admins cannot change their own role, so the
+// last admin cannot lock everyone out of the console.
+func (h *AdminHandler) ChangeRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	user, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+	back := "/admin/user?id=" + strconv.Itoa(user.ID)
+	adminID, _ := r.Context().Value("userID").(int)
+	if user.ID == adminID {
+		http.Redirect(w, r, back+"&error=Нельзя изменить собственную роль", http.StatusSeeOther)
+		return
+	}
+
+	role := r.FormValue("role")
+	err := h.repo.ChangeUserRole(user.ID, role, adminID)
+	if errors.Is(err, db.ErrUnknownRole) {
+		http.Redirect(w, r, back+"&error=Неизвестная роль", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Ошибка смены роли пользователя %d: %v", user.ID, err)
+		http.Redirect(w, r, back+"&error=Ошибка смены роли", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("Роль пользователя %d изменена с %s на %s администратором %d", user.ID, user.Role, role, adminID)
+	audit(h.repo, h.log, r, models.AuditUserRole, "user", user.ID, map[string]string{"role": user.Role}, map[string]string{"role": role})
+	http.Redirect(w, r, back+"&success=Роль изменена", http.StatusSeeOther)
+}
+
+// ForcePasswordReset invalidates a user's password, signs them out
+// everywhere, revokes their API tokens and mails them a link to choose a new
+// one.
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	user, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+	back := "/admin/user?id=" + strconv.Itoa(user.ID)
+
+	if err := h.repo.InvalidatePassword(user.ID); err != nil {
+		h.log.Printf("Ошибка сброса пароля пользователя %d: %v", user.ID, err)
+		http.Redirect(w, r, back+"&error=Ошибка сброса пароля", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteAllUserSessions(user.ID); err != nil {
+		h.log.Printf("Ошибка завершения сессий пользователя %d: %v", user.ID, err)
+	}
+	if err := h.repo.DeleteAllUserAPITokens(user.ID); err != nil {
+		h.log.Printf("Ошибка отзыва API-токенов пользователя %d: %v", user.ID, err)
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	h.log.Printf("Пароль пользователя %d сброшен администратором %d", user.ID, adminID)
+	audit(h.repo, h.log, r, models.AuditUserPasswordReset, "user", user.ID, nil, nil)
+
+	token, err := h.repo.CreatePasswordReset(user.ID, adminResetTokenTTL)
+	if err == nil {
+		err = h.mailer.Send(user.Email, "password_reset_forced", map[string]interface{}{
+			"Username": user.Username,
+			"Token":    token,
+			"ValidFor": "24 hours",
+		})
+	}
+	if err != nil {
+		h.log.Printf("Ошибка отправки письма для сброса пароля: %v", err)
+		http.Redirect(w, r, back+"&error=Пароль сброшен, но письмо не отправлено. Пользователь может запросить ссылку сам", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, back+"&success=Пароль сброшен, пользователю отправлено письмо", http.StatusSeeOther)
+}
+
+// RevokeSessions signs a user out on all devices and revokes their API
+// tokens.
+func (h *AdminHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	user, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+	back := "/admin/user?id=" + strconv.Itoa(user.ID)
+	if err := h.repo.DeleteAllUserSessions(user.ID); err != nil {
+		h.log.Printf("Ошибка завершения сессий пользователя %d: %v", user.ID, err)
+		http.Redirect(w, r, back+"&error=Ошибка завершения сессий", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteAllUserAPITokens(user.ID); err != nil {
+		h.log.Printf("Ошибка отзыва API-токенов пользователя %d: %v", user.ID, err)
+		http.Redirect(w, r, back+"&error=Ошибка отзыва API-токенов", http.StatusSeeOther)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	h.log.Printf("Сессии пользователя %d завершены администратором %d", user.ID, adminID)
+	audit(h.repo, h.log, r, models.AuditUserSessionsRevoke, "user", user.ID, nil, nil)
+	http.Redirect(w, r, back+"&success=Все сессии пользователя завершены", http.StatusSeeOther)
+}
+
+// targetUser loads the user named by the id query parameter, answering 400
+// or 404 itself when it cannot.
+func (h *AdminHandler) targetUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "400 Bad Request", "Неверный ID пользователя", h.projectRoot)
+		return nil, false
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		renderError(w, http.StatusNotFound, "404 Not Found", "Пользователь не найден", h.projectRoot)
+		return nil, false
+	}
+	if err != nil {
+		h.log.Printf("Ошибка загрузки пользователя %d: %v", userID, err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"forum/internal/mail"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestAdminRevokesAPITokens(t *testing.T) {
+	repo := setupTestRepo(t)
+	admin, _ := createTestUser(t, repo, "admin", "admin")
+	admin.Role = "admin"
+	mailer := mail.NewMailer(repo, mail.LogTransport{Log: testLogger}, testLogger, "http://localhost")
+	h := NewAdminHandler(repo, testLogger, "", mailer)
+	api := newTestAPI(repo)
+
+	pid, _ := repo.CreatePost(&models.Post{UserID: admin.ID, Title: "Пост", Content: "Текст поста"})
+	postPath := "/api/v1/posts/" + strconv.Itoa(int(pid))
+
+	for _, tt := range []struct {
+		name     string
+		username string
+		handler  http.HandlerFunc
+	}{
+		{"force password reset", "bob", h.ForcePasswordReset},
+		{"revoke sessions", "carol", h.RevokeSessions},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			user, token := createTestUser(t, repo, tt.username, "user")
+			if code := apiCall(t, api, token, http.MethodGet, postPath, nil); code != http.StatusOK {
+				t.Fatalf("Токен не работает до отзыва: %d", code)
+			}
+			if code := asUser(tt.handler, admin, "/admin/users?id="+strconv.Itoa(user.ID), nil); code != http.StatusSeeOther {
+				t.Fatalf("Статус %d, ожидался редирект", code)
+			}
+			if code := apiCall(t, api, token, http.MethodGet, postPath, nil); code != http.StatusUnauthorized {
+				t.Errorf("Отозванный токен: статус %d, ожидался 401", code)
+			}
+			if tokens, _ := repo.GetAPITokensByUser(user.ID); len(tokens) != 0 {
+				t.Errorf("Осталось токенов: %d", len(tokens))
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The announcement banner comes from the shared partial, so it shows on
+// pages whose handlers know nothing about announcements, for guests and for
+// signed-in users alike, until the user dismisses it.
+func TestAnnouncementBannerOnEveryPage(t *testing.T) {
+	repo := setupTestRepo(t)
+	admin, _ := createTestUser(t, repo, "admin", "admin")
+	alice, _ := createTestUser(t, repo, "alice", "user")
+	pid, err := repo.CreatePost(&models.Post{UserID: admin.ID, Title: "Плановые работы", Content: "Форум будет недоступен"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetPostAnnouncement(int(pid), true); err != nil {
+		t.Fatal(err)
+	}
+	session := &models.Session{SessionID: "session-alice", UserID: alice.ID, Expires: time.Now().Add(time.Hour)}
+	if err := repo.CreateSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewAuthHandler(repo, testLogger, "../..", nil, AuthOptions{})
+	search := NewSearchHandler(repo, testLogger, "../..")
+	notifications := NewNotificationsHandler(repo, testLogger, "../..")
+
+	page := func(h http.HandlerFunc, r *http.Request) string {
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Код ответа %d, ожидался 200", rec.Code)
+		}
+		return rec.Body.String()
+	}
+	signedIn := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), "userID", alice.ID))
+	}
+	withCookie := func(r *http.Request) *http.Request {
+		r.AddCookie(&http.Cookie{Name: "session_id", Value: session.SessionID})
+		return r
+	}
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		req         *http.Request
+		dismissible bool
+	}{
+		{"гость на странице входа", auth.Login, httptest.NewRequest(http.MethodGet, "/login", nil), false},
+		{"гость в поиске", search.Search, httptest.NewRequest(http.MethodGet, "/search", nil), false},
+		{"пользователь в поиске по cookie", search.Search, withCookie(httptest.NewRequest(http.MethodGet, "/search", nil)), true},
+		{"пользователь в уведомлениях", notifications.ListNotifications, signedIn(httptest.NewRequest(http.MethodGet, "/notifications", nil)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := page(tt.handler, tt.req)
+			if !strings.Contains(body, "Плановые работы") {
+				t.Fatal("Объявление не показано")
+			}
+			if got := strings.Contains(body, "data-dismiss-announcement"); got != tt.dismissible {
+				t.Errorf("Кнопка закрытия показана: %v, ожидалось %v", got, tt.dismissible)
+			}
+		})
+	}
+
+	if err := repo.DismissAnnouncement(alice.ID, int(pid)); err != nil {
+		t.Fatal(err)
+	}
+	if body := page(notifications.ListNotifications, signedIn(httptest.NewRequest(http.MethodGet, "/notifications", nil))); strings.Contains(body, "Плановые работы") {
+		t.Error("Закрытое объявление показано снова")
+	}
+	if body := page(search.Search, httptest.NewRequest(http.MethodGet, "/search", nil)); !strings.Contains(body, "Плановые работы") {
+		t.Error("Объявление пропало у гостя")
+	}
+}
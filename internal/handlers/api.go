@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"forum/internal/automod"
+	"forum/internal/db"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIHandler serves the versioned JSON API under /api/v1. It enforces the
+// same permission rules as the HTML handlers but reports errors with HTTP
+// status codes and a JSON error envelope instead of redirects.
+type APIHandler struct {
+	repo    *db.Repository
+	log     *log.Logger
+	automod *automod.Engine
+}
+
+// NewAPIHandler creates a new APIHandler. engine screens new and edited
+// posts and comments.
+func NewAPIHandler(repo *db.Repository, log *log.Logger, engine *automod.Engine) *APIHandler {
+	return &APIHandler{repo: repo, log: log, automod: engine}
+}
+
+const (
+	apiDefaultLimit = 20
+	apiMaxLimit     = 100
+	apiMaxBodyBytes = 1 << 20
+)
+
+// apiErrorBody is the error envelope: {"error": {"code": "...", "message": "..."}}
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiPage wraps list responses: {"data": [...], "next_cursor": "..."}
+type apiPage struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// apiItem wraps single-resource responses: {"data": {...}}
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorBody{Error: apiError{Code: code, Message: message}})
+}
+
+// internalError logs err and writes a generic 500 response.
+func (h *APIHandler) internalError(w http.ResponseWriter, message string, err error) {
+	h.log.Printf("API %s: %v", message, err)
+	writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+}
+
+// notFoundOr writes 404 for sql.ErrNoRows and 500 for any other error.
+func (h *APIHandler) notFoundOr(w http.ResponseWriter, err error, what string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "not_found", what+" not found")
+		return
+	}
+	h.internalError(w, "loading "+what, err)
+}
+
+// currentUser returns the authenticated user from the request context, or
+// writes 401 and returns ok=false.
+func currentUser(w http.ResponseWriter, r *http.Request) (userID int, role string, ok bool) {
+	userID, ok = r.Context().Value("userID").(int)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return 0, "", false
+	}
+	role, _ = r.Context().Value("role").(string)
+	return userID, role, true
+}
+
+// currentActiveUser is like currentUser but also writes 403 for banned
+// users. It guards editing content and reporting.
+func currentActiveUser(w http.ResponseWriter, r *http.Request) (userID int, role string, ok bool) {
+	userID, role, ok = currentUser(w, r)
+	if ok && activeBan(r) != nil {
+		writeAPIError(w, http.StatusForbidden, "account_suspended", "Your account is suspended")
+		return 0, "", false
+	}
+	return userID, role, ok
+}
+
+// currentVerifiedUser is like currentActiveUser but also writes 403 for
+// users who have not confirmed their email address. It guards creating
+// content and voting.
+func currentVerifiedUser(w http.ResponseWriter, r *http.Request) (userID int, role string, ok bool) {
+	userID, role, ok = currentActiveUser(w, r)
+	if ok && !emailVerified(r) {
+		writeAPIError(w, http.StatusForbidden, "email_unverified", "Confirm your email address first")
+		return 0, "", false
+	}
+	return userID, role, ok
+}
+
+// visiblePost loads the post and writes 404 unless it exists and is shown
+// to the caller.
+func (h *APIHandler) visiblePost(w http.ResponseWriter, r *http.Request, postID int) (*models.Post, bool) {
+	post, err := viewablePost(h.repo, r, postID)
+	if err != nil {
+		h.notFoundOr(w, err, "Post")
+		return nil, false
+	}
+	return post, true
+}
+
+// visibleComment loads the comment and writes 404 unless both it and its
+// post are shown to the caller.
+func (h *APIHandler) visibleComment(w http.ResponseWriter, r *http.Request, commentID int) (*models.Comment, bool) {
+	comment, err := viewableComment(h.repo, r, commentID)
+	if err != nil {
+		h.notFoundOr(w, err, "Comment")
+		return nil, false
+	}
+	return comment, true
+}
+
+// screen runs the automod rules on content about to be saved and writes 422
+// automod_rejected if they reject it.
+func (h *APIHandler) screen(w http.ResponseWriter, role string, c automod.Content) (*automod.Verdict, bool) {
+	verdict := screenContent(h.automod, h.log, role, c)
+	if verdict.Rejected() {
+		applyVerdict(h.automod, h.log, verdict, 0)
+		writeAPIError(w, http.StatusUnprocessableEntity, "automod_rejected", "The content was blocked by automatic moderation")
+		return nil, false
+	}
+	return verdict, true
+}
+
+func isModerator(role string) bool {
+	return role == "admin" || role == "moderator"
+}
+
+// decodeJSON reads a JSON request body into v, rejecting unknown fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// pathID parses a positive integer path parameter.
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid "+name)
+		return 0, false
+	}
+	return id, true
+}
+
+// pageParams reads the cursor and limit query parameters.
+func pageParams(w http.ResponseWriter, r *http.Request) (cursor, limit int, ok bool) {
+	limit = apiDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+			return 0, 0, false
+		}
+		limit = min(n, apiMaxLimit)
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			cursor, err = strconv.Atoi(string(raw))
+		}
+		if err != nil || cursor <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_cursor", "Invalid cursor")
+			return 0, 0, false
+		}
+	}
+	return cursor, limit, true
+}
+
+// nextCursor returns the cursor for the page after one ending at lastID, or
+// "" when the page was not full.
+func nextCursor(count, limit, lastID int) string {
+	if count < limit {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastID)))
+}
+
+// API representations
+
+type apiCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiPost struct {
+	ID         int           `json:"id"`
+	UserID     int           `json:"user_id"`
+	Username   string        `json:"username"`
+	Title      string        `json:"title"`
+	Content    string        `json:"content"`
+	HTML       string        `json:"content_html"`
+	Categories []apiCategory `json:"categories"`
+	ImageURL   string        `json:"image_url,omitempty"`
+	Likes      int           `json:"likes"`
+	Dislikes   int           `json:"dislikes"`
+	Visibility string        `json:"visibility"`
+	Locked     bool          `json:"locked"`
+	Pinned     bool          `json:"pinned"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  *time.Time    `json:"updated_at,omitempty"`
+}
+
+type apiComment struct {
+	ID         int        `json:"id"`
+	PostID     int        `json:"post_id"`
+	ParentID   *int       `json:"parent_id,omitempty"`
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	Content    string     `json:"content"`
+	HTML       string     `json:"content_html"`
+	Likes      int        `json:"likes"`
+	Dislikes   int        `json:"dislikes"`
+	Visibility string     `json:"visibility"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+type apiVotes struct {
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+}
+
+type apiNotification struct {
+	ID         int       `json:"id"`
+	Type       string    `json:"type"`
+	FromUserID *int      `json:"from_user_id,omitempty"`
+	PostID     *int      `json:"post_id,omitempty"`
+	CommentID  *int      `json:"comment_id,omitempty"`
+	IsRead     bool      `json:"is_read"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func toAPINotification(n *models.Notification) apiNotification {
+	return apiNotification{
+		ID:         n.ID,
+		Type:       n.Type,
+		FromUserID: n.FromUserID,
+		PostID:     n.PostID,
+		CommentID:  n.CommentID,
+		IsRead:     n.IsRead,
+		CreatedAt:  n.CreatedAt,
+	}
+}
+
+type apiReport struct {
+	ID         int       `json:"id"`
+	ReporterID int       `json:"reporter_id"`
+	PostID     *int      `json:"post_id,omitempty"`
+	CommentID  *int      `json:"comment_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Resolution, set once a moderator has acted on the report
+	Action         string     `json:"action,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolvedBy     *int       `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+}
+
+func (h *APIHandler) username(userID int) string {
+	if user, err := h.repo.GetUserByID(userID); err == nil {
+		return user.Username
+	}
+	return ""
+}
+
+func (h *APIHandler) toAPIPost(post *models.Post) *apiPost {
+	likes, dislikes, _ := h.repo.GetLikesDislikes(post.ID)
+	imagePath, _ := h.repo.GetImagePathByPostID(post.ID)
+	categories := []apiCategory{}
+	if cats, err := h.repo.GetCategoriesByPostID(post.ID); err == nil {
+		for _, c := range cats {
+			categories = append(categories, apiCategory{ID: c.ID, Name: c.Name})
+		}
+	}
+	return &apiPost{
+		ID:         post.ID,
+		UserID:     post.UserID,
+		Username:   h.username(post.UserID),
+		Title:      post.Title,
+		Content:    post.Content,
+		HTML:       string(contentHTML(renderedContents(h.repo, h.log, models.RevisionPost, post.ID), post.ID, post.Content)),
+		Categories: categories,
+		ImageURL:   imagePath,
+		Likes:      likes,
+		Dislikes:   dislikes,
+		Visibility: post.Visibility,
+		Locked:     post.Locked,
+		Pinned:     post.PinnedAt != nil,
+		CreatedAt:  post.CreatedAt,
+		UpdatedAt:  post.UpdatedAt,
+	}
+}
+
+func (h *APIHandler) toAPIComment(c *models.Comment) *apiComment {
+	likes, dislikes, _ := h.repo.GetCommentLikesDislikes(c.ID)
+	return &apiComment{
+		ID:         c.ID,
+		PostID:     c.PostID,
+		ParentID:   c.ParentID,
+		UserID:     c.UserID,
+		Username:   h.username(c.UserID),
+		Content:    c.Content,
+		HTML:       string(contentHTML(renderedContents(h.repo, h.log, models.RevisionComment, c.ID), c.ID, c.Content)),
+		Likes:      likes,
+		Dislikes:   dislikes,
+		Visibility: c.Visibility,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+func toAPIReport(rep *models.Report) *apiReport {
+	return &apiReport{
+		ID:         rep.ID,
+		ReporterID: rep.ReporterID,
+		PostID:     rep.PostID,
+		CommentID:  rep.CommentID,
+		Reason:     rep.Reason,
+		Status:     rep.Status,
+		CreatedAt:  rep.CreatedAt,
+
+		Action:         rep.Action,
+		ResolutionNote: rep.ResolutionNote,
+		ResolvedBy:     rep.ResolvedBy,
+		ResolvedAt:     rep.ResolvedAt,
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"forum/internal/automod"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type apiCommentInput struct {
+	Content  *string `json:"content"`
+	ParentID *int    `json:"parent_id"`
+}
+
+// validateCommentInput applies the same limits as the HTML comment forms.
+func validateCommentInput(content string) string {
+	if contentLen := utf8.RuneCountInString(content); contentLen < 2 || contentLen > 1000 {
+		return "Comment must be 2-1000 characters"
+	}
+	return ""
+}
+
+// ListComments handles GET /api/v1/posts/{id}/comments?cursor=&limit=
+func (h *APIHandler) ListComments(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.visiblePost(w, r, postID); !ok {
+		return
+	}
+
+	comments, err := h.repo.GetCommentsPage(postID, cursor, limit)
+	if err != nil {
+		h.internalError(w, "listing comments", err)
+		return
+	}
+	data := []*apiComment{}
+	for _, c := range comments {
+		data = append(data, h.toAPIComment(c))
+	}
+	next := ""
+	if len(comments) > 0 {
+		next = nextCursor(len(comments), limit, comments[len(comments)-1].ID)
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data, NextCursor: next})
+}
+
+// CreateComment handles POST /api/v1/posts/{id}/comments
+func (h *APIHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentVerifiedUser(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var in apiCommentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Content == nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "content is required")
+		return
+	}
+	content := strings.TrimSpace(*in.Content)
+	if msg := validateCommentInput(content); msg != "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", msg)
+		return
+	}
+	post, ok := h.visiblePost(w, r, postID)
+	if !ok {
+		return
+	}
+	// Moderators can still comment on a locked post
+	if role != "admin" && role != "moderator" && !unlocked(w, post) {
+		return
+	}
+
+	comment := &models.Comment{PostID: postID, UserID: userID, Content: content}
+	var parent *models.Comment
+	if in.ParentID != nil {
+		var err error
+		parent, err = viewableComment(h.repo, r, *in.ParentID)
+		if err != nil || parent.PostID != postID {
+			writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "parent_id does not belong to this post")
+			return
+		}
+		comment.ParentID = &parent.ID
+	}
+
+	verdict, ok := h.screen(w, role, automod.Content{Type: models.RevisionComment, AuthorID: userID, Text: content})
+	if !ok {
+		return
+	}
+	comment.Visibility = verdict.Visibility()
+
+	if err := h.repo.CreateComment(comment); err != nil {
+		h.internalError(w, "creating comment", err)
+		return
+	}
+	applyVerdict(h.automod, h.log, verdict, comment.ID)
+	if !verdict.Queued() {
+		notified := notifyComment(h.repo, comment, parent)
+		notifyMentions(h.repo, userID, &comment.PostID, &comment.ID, "", comment.Content, notified...)
+	}
+
+	created, err := h.repo.GetCommentByID(comment.ID)
+	if err != nil {
+		h.internalError(w, "loading comment", err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/comments/"+strconv.Itoa(comment.ID))
+	writeJSON(w, http.StatusCreated, apiItem{Data: h.toAPIComment(created)})
+}
+
+// GetComment handles GET /api/v1/comments/{id}
+func (h *APIHandler) GetComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	comment, ok := h.visibleComment(w, r, commentID)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem{Data: h.toAPIComment(comment)})
+}
+
+// UpdateComment handles PATCH /api/v1/comments/{id} (only author, moderator, or admin)
+func (h *APIHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentActiveUser(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	comment, err := h.repo.GetCommentByID(commentID)
+	if err != nil {
+		h.notFoundOr(w, err, "Comment")
+		return
+	}
+	if comment.UserID != userID && !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "No permission to edit")
+		return
+	}
+	var in apiCommentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.ParentID != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "parent_id cannot be changed")
+		return
+	}
+	if in.Content == nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "content is required")
+		return
+	}
+	content := strings.TrimSpace(*in.Content)
+	if msg := validateCommentInput(content); msg != "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", msg)
+		return
+	}
+	verdict, ok := h.screen(w, role, automod.Content{Type: models.RevisionComment, ID: commentID, AuthorID: comment.UserID, Text: content})
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateComment(commentID, userID, content); err != nil {
+		h.internalError(w, "updating comment", err)
+		return
+	}
+	applyVerdict(h.automod, h.log, verdict, commentID)
+	if !verdict.Held() && comment.Visibility != models.VisibilityPending {
+		notifyMentions(h.repo, userID, &comment.PostID, &commentID, comment.Content, content)
+	}
+	before := commentSnapshot(comment)
+	comment, err = h.repo.GetCommentByID(commentID)
+	if err != nil {
+		h.internalError(w, "loading comment", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditCommentEdit, models.RevisionComment, commentID, before, commentSnapshot(comment))
+	writeJSON(w, http.StatusOK, apiItem{Data: h.toAPIComment(comment)})
+}
+
+// DeleteComment handles DELETE /api/v1/comments/{id} (only author, moderator, or admin)
+func (h *APIHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	comment, err := h.repo.GetCommentByID(commentID)
+	if err != nil {
+		h.notFoundOr(w, err, "Comment")
+		return
+	}
+	if comment.UserID != userID && !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "No permission to delete")
+		return
+	}
+	if err := h.repo.DeleteComment(commentID, userID); err != nil {
+		h.internalError(w, "deleting comment", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditCommentDelete, models.RevisionComment, commentID, commentSnapshot(comment), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VoteComment handles POST /api/v1/comments/{id}/votes
+func (h *APIHandler) VoteComment(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentVerifiedUser(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var in apiVoteInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.IsLike == nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "is_like is required")
+		return
+	}
+	comment, ok := h.visibleComment(w, r, commentID)
+	if !ok {
+		return
+	}
+	post, ok := h.visiblePost(w, r, comment.PostID)
+	if !ok || !unlocked(w, post) {
+		return
+	}
+
+	like := &models.Like{UserID: userID, CommentID: &commentID, IsLike: *in.IsLike}
+	if err := h.repo.CreateLike(like); err != nil {
+		h.internalError(w, "processing like", err)
+		return
+	}
+	notifyLike(h.repo, like)
+
+	likes, dislikes, _ := h.repo.GetCommentLikesDislikes(commentID)
+	writeJSON(w, http.StatusOK, apiItem{Data: apiVotes{Likes: likes, Dislikes: dislikes}})
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"forum/internal/automod"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type apiPostInput struct {
+	Title       *string `json:"title"`
+	Content     *string `json:"content"`
+	CategoryIDs []int   `json:"category_ids"`
+}
+
+// validatePostInput applies the same limits as the HTML post forms.
+func validatePostInput(title, content string) string {
+	if titleLen := utf8.RuneCountInString(title); titleLen < 5 || titleLen > 100 {
+		return "Title must be 5-100 characters"
+	}
+	if contentLen := utf8.RuneCountInString(content); contentLen < 10 || contentLen > 5000 {
+		return "Content must be 10-5000 characters"
+	}
+	return ""
+}
+
+// ListPosts handles GET /api/v1/posts?category=&cursor=&limit=
+func (h *APIHandler) ListPosts(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	categoryID := 0
+	if v := r.URL.Query().Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_category", "Invalid category")
+			return
+		}
+		categoryID = id
+	}
+
+	posts, err := h.repo.GetPostsPage(categoryID, cursor, limit)
+	if err != nil {
+		h.internalError(w, "listing posts", err)
+		return
+	}
+	data := []*apiPost{}
+	for _, p := range posts {
+		data = append(data, h.toAPIPost(p))
+	}
+	next := ""
+	if len(posts) > 0 {
+		next = nextCursor(len(posts), limit, posts[len(posts)-1].ID)
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data, NextCursor: next})
+}
+
+// GetPost handles GET /api/v1/posts/{id}
+func (h *APIHandler) GetPost(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	post, ok := h.visiblePost(w, r, postID)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem{Data: h.toAPIPost(post)})
+}
+
+// CreatePost handles POST /api/v1/posts
+func (h *APIHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentVerifiedUser(w, r)
+	if !ok {
+		return
+	}
+	var in apiPostInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Title == nil || in.Content == nil || len(in.CategoryIDs) == 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "title, content and category_ids are required")
+		return
+	}
+	title, content := strings.TrimSpace(*in.Title), strings.TrimSpace(*in.Content)
+	if msg := validatePostInput(title, content); msg != "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", msg)
+		return
+	}
+	for _, catID := range in.CategoryIDs {
+		exists, err := h.repo.CategoryExists(catID)
+		if err != nil {
+			h.internalError(w, "checking category", err)
+			return
+		}
+		if !exists {
+			writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Category "+strconv.Itoa(catID)+" does not exist")
+			return
+		}
+	}
+
+	verdict, ok := h.screen(w, role, automod.Content{Type: models.RevisionPost, AuthorID: userID, Title: title, Text: content})
+	if !ok {
+		return
+	}
+
+	postID, err := h.repo.CreatePost(&models.Post{UserID: userID, Title: title, Content: content, Visibility: verdict.Visibility()})
+	if err != nil {
+		h.internalError(w, "creating post", err)
+		return
+	}
+	for _, catID := range in.CategoryIDs {
+		if err := h.repo.AddPostCategory(int(postID), catID); err != nil {
+			h.log.Printf("API error adding category: %v", err)
+		}
+	}
+
+	id := int(postID)
+	applyVerdict(h.automod, h.log, verdict, id)
+	if !verdict.Queued() {
+		notifyMentions(h.repo, userID, &id, nil, "", content)
+	}
+
+	post, err := h.repo.GetPostByID(id)
+	if err != nil {
+		h.internalError(w, "loading post", err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/posts/"+strconv.Itoa(post.ID))
+	writeJSON(w, http.StatusCreated, apiItem{Data: h.toAPIPost(post)})
+}
+
+// UpdatePost handles PATCH /api/v1/posts/{id} (only author, moderator, or admin)
+func (h *APIHandler) UpdatePost(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentActiveUser(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	post, err := h.repo.GetPostByID(postID)
+	if err != nil {
+		h.notFoundOr(w, err, "Post")
+		return
+	}
+	if post.UserID != userID && !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "No permission to edit")
+		return
+	}
+
+	var in apiPostInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.CategoryIDs != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "category_ids cannot be changed")
+		return
+	}
+	title, content := post.Title, post.Content
+	if in.Title != nil {
+		title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		content = strings.TrimSpace(*in.Content)
+	}
+	if msg := validatePostInput(title, content); msg != "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", msg)
+		return
+	}
+	verdict, ok := h.screen(w, role, automod.Content{Type: models.RevisionPost, ID: postID, AuthorID: post.UserID, Title: title, Text: content})
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdatePost(postID, userID, title, content); err != nil {
+		h.internalError(w, "updating post", err)
+		return
+	}
+	applyVerdict(h.automod, h.log, verdict, postID)
+	if !verdict.Held() && post.Visibility != models.VisibilityPending {
+		notifyMentions(h.repo, userID, &postID, nil, post.Content, content)
+	}
+	before := postSnapshot(post)
+	post, err = h.repo.GetPostByID(postID)
+	if err != nil {
+		h.internalError(w, "loading post", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditPostEdit, models.RevisionPost, postID, before, postSnapshot(post))
+	writeJSON(w, http.StatusOK, apiItem{Data: h.toAPIPost(post)})
+}
+
+// DeletePost handles DELETE /api/v1/posts/{id} (only author, moderator, or admin)
+func (h *APIHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	post, err := h.repo.GetPostByID(postID)
+	if err != nil {
+		h.notFoundOr(w, err, "Post")
+		return
+	}
+	if post.UserID != userID && !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "No permission to delete")
+		return
+	}
+	if err := h.repo.DeletePost(postID, userID); err != nil {
+		h.internalError(w, "deleting post", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditPostDelete, models.RevisionPost, postID, postSnapshot(post), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiVoteInput struct {
+	IsLike *bool `json:"is_like"`
+}
+
+// VotePost handles POST /api/v1/posts/{id}/votes. Voting the same way twice
+// removes the vote, as on the site.
+func (h *APIHandler) VotePost(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentVerifiedUser(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var in apiVoteInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.IsLike == nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "is_like is required")
+		return
+	}
+	post, ok := h.visiblePost(w, r, postID)
+	if !ok || !unlocked(w, post) {
+		return
+	}
+
+	like := &models.Like{UserID: userID, PostID: &postID, IsLike: *in.IsLike}
+	if err := h.repo.CreateLike(like); err != nil {
+		h.internalError(w, "processing like", err)
+		return
+	}
+	notifyLike(h.repo, like)
+
+	likes, dislikes, _ := h.repo.GetLikesDislikes(postID)
+	writeJSON(w, http.StatusOK, apiItem{Data: apiVotes{Likes: likes, Dislikes: dislikes}})
+}
+
+// unlocked reports whether the post accepts new comments and votes, and
+// answers 403 post_locked if it does not.
+func unlocked(w http.ResponseWriter, post *models.Post) bool {
+	if post.Locked {
+		writeAPIError(w, http.StatusForbidden, "post_locked", "This post is locked")
+		return false
+	}
+	return true
+}
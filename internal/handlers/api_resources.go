@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ListCategories handles GET /api/v1/categories
+func (h *APIHandler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.repo.GetAllCategories()
+	if err != nil {
+		h.internalError(w, "listing categories", err)
+		return
+	}
+	data := []apiCategory{}
+	for _, c := range categories {
+		data = append(data, apiCategory{ID: c.ID, Name: c.Name})
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data})
+}
+
+// CreateCategory handles POST /api/v1/categories (only admin)
+func (h *APIHandler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	_, role, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only admins can create categories")
+		return
+	}
+	var in struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "name is required")
+		return
+	}
+	if _, err := h.repo.GetCategoryByName(name); err == nil {
+		writeAPIError(w, http.StatusConflict, "conflict", "Category already exists")
+		return
+	}
+	id, err := h.repo.CreateCategory(name)
+	if err != nil {
+		h.internalError(w, "creating category", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditCategoryCreate, "category", id, nil, map[string]string{"name": name})
+	writeJSON(w, http.StatusCreated, apiItem{Data: apiCategory{ID: id, Name: name}})
+}
+
+// ListNotifications handles GET /api/v1/notifications?cursor=&limit=
+func (h *APIHandler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	notifs, err := h.repo.GetNotificationsPage(userID, cursor, limit)
+	if err != nil {
+		h.internalError(w, "listing notifications", err)
+		return
+	}
+	data := []apiNotification{}
+	for _, n := range notifs {
+		data = append(data, toAPINotification(n))
+	}
+	next := ""
+	if len(notifs) > 0 {
+		next = nextCursor(len(notifs), limit, notifs[len(notifs)-1].ID)
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data, NextCursor: next})
+}
+
+// MarkNotificationRead handles POST /api/v1/notifications/{id}/read
+func (h *APIHandler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	notifID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.repo.MarkNotificationRead(userID, notifID); err != nil {
+		h.notFoundOr(w, err, "Notification")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkAllNotificationsRead handles POST /api/v1/notifications/read-all
+func (h *APIHandler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.MarkAllNotificationsRead(userID); err != nil {
+		h.internalError(w, "marking notifications read", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListReports handles GET /api/v1/reports?status=&cursor=&limit= (only moderator and admin)
+func (h *APIHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	_, role, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Access denied")
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	reports, err := h.repo.GetReportsPage(r.URL.Query().Get("status"), cursor, limit)
+	if err != nil {
+		h.internalError(w, "listing reports", err)
+		return
+	}
+	data := []*apiReport{}
+	for _, rep := range reports {
+		data = append(data, toAPIReport(rep))
+	}
+	next := ""
+	if len(reports) > 0 {
+		next = nextCursor(len(reports), limit, reports[len(reports)-1].ID)
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data, NextCursor: next})
+}
+
+// CreateReport handles POST /api/v1/reports
+func (h *APIHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := currentActiveUser(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		PostID    *int   `json:"post_id"`
+		CommentID *int   `json:"comment_id"`
+		Reason    string `json:"reason"`
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	reason := strings.TrimSpace(in.Reason)
+	if reason == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "reason is required")
+		return
+	}
+	if (in.PostID == nil) == (in.CommentID == nil) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Exactly one of post_id and comment_id is required")
+		return
+	}
+	if in.PostID != nil {
+		if _, ok := h.visiblePost(w, r, *in.PostID); !ok {
+			return
+		}
+	} else if _, ok := h.visibleComment(w, r, *in.CommentID); !ok {
+		return
+	}
+
+	if err := h.repo.CreateReport(userID, in.PostID, in.CommentID, reason); err != nil {
+		h.internalError(w, "creating report", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, apiItem{Data: map[string]string{"status": "open"}})
+}
+
+// CloseReport handles POST /api/v1/reports/{id}/close (only moderator and admin).
+// The optional body {"status": "actioned"|"rejected", "note": "..."} sets the
+// resolution; without it the report is marked actioned. The reporter is
+// notified.
+func (h *APIHandler) CloseReport(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !isModerator(role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Access denied")
+		return
+	}
+	reportID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	in := struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}{Status: models.ReportActioned}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Status != models.ReportActioned && in.Status != models.ReportRejected {
+		writeAPIError(w, http.StatusBadRequest, "invalid_status", "status must be \"actioned\" or \"rejected\"")
+		return
+	}
+	rep, err := h.repo.GetReportByID(reportID)
+	if err != nil {
+		h.notFoundOr(w, err, "Report")
+		return
+	}
+	if rep.Resolved() {
+		writeAPIError(w, http.StatusConflict, "already_resolved", "Report is already resolved")
+		return
+	}
+	action := ""
+	if in.Status == models.ReportRejected {
+		action = models.ReportActionDismiss
+	}
+	if err := h.repo.UpdateReportStatus(reportID, in.Status, action, strings.TrimSpace(in.Note), userID); err != nil {
+		h.internalError(w, "closing report", err)
+		return
+	}
+	before := reportSnapshot(rep)
+	rep, err = h.repo.GetReportByID(reportID)
+	if err != nil {
+		h.internalError(w, "loading report", err)
+		return
+	}
+	audit(h.repo, h.log, r, models.AuditReportResolve, "report", reportID, before, reportSnapshot(rep))
+	notifyReporter(h.repo, h.log, rep, userID)
+	writeJSON(w, http.StatusOK, apiItem{Data: toAPIReport(rep)})
+}
+
+// NotFound handles unknown /api/ paths with a JSON 404.
+func (h *APIHandler) NotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "not_found", "Unknown API endpoint "+strconv.Quote(r.URL.Path))
+}
+
+// MethodNotAllowed answers 405 for known API paths requested with a method
+// that has no handler.
+func (h *APIHandler) MethodNotAllowed(methods []string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method "+r.Method+" is not allowed")
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestAPIHiddenAndPendingPosts(t *testing.T) {
+	repo := setupTestRepo(t)
+	api := newTestAPI(repo)
+	alice, aliceToken := createTestUser(t, repo, "alice", "user")
+	bob, bobToken := createTestUser(t, repo, "bob", "user")
+	_, modToken := createTestUser(t, repo, "mod", "moderator")
+
+	for _, visibility := range []string{models.VisibilityPending, models.VisibilityHidden} {
+		t.Run(visibility, func(t *testing.T) {
+			pid, err := repo.CreatePost(&models.Post{UserID: alice.ID, Title: "Заголовок", Content: "Текст поста"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			comment := &models.Comment{PostID: int(pid), UserID: alice.ID, Content: "Видимый комментарий"}
+			if err := repo.CreateComment(comment); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetPostVisibility(int(pid), visibility); err != nil {
+				t.Fatal(err)
+			}
+			post := "/api/v1/posts/" + strconv.Itoa(int(pid))
+			cmt := "/api/v1/comments/" + strconv.Itoa(comment.ID)
+			vote := map[string]bool{"is_like": true}
+
+			// Для остальных пост и всё под ним не существует
+			for _, c := range []struct {
+				method, path string
+				body         interface{}
+			}{
+				{http.MethodGet, post, nil},
+				{http.MethodGet, post + "/comments", nil},
+				{http.MethodPost, post + "/comments", map[string]string{"content": "Новый комментарий"}},
+				{http.MethodPost, post + "/votes", vote},
+				{http.MethodGet, cmt, nil},
+				{http.MethodPost, cmt + "/votes", vote},
+				{http.MethodPost, "/api/v1/reports", map[string]interface{}{"post_id": pid, "reason": "спам"}},
+				{http.MethodPost, "/api/v1/reports", map[string]interface{}{"comment_id": comment.ID, "reason": "спам"}},
+			} {
+				if code := apiCall(t, api, bobToken, c.method, c.path, c.body); code != http.StatusNotFound {
+					t.Errorf("%s %s: ожидался 404, получен %d", c.method, c.path, code)
+				}
+			}
+
+			// Автор и модераторы его видят
+			for _, token := range []string{aliceToken, modToken} {
+				if code := apiCall(t, api, token, http.MethodGet, post, nil); code != http.StatusOK {
+					t.Errorf("GET %s: ожидался 200, получен %d", post, code)
+				}
+				if code := apiCall(t, api, token, http.MethodGet, cmt, nil); code != http.StatusOK {
+					t.Errorf("GET %s: ожидался 200, получен %d", cmt, code)
+				}
+			}
+
+			// Без голосов, комментариев, жалоб и уведомлений
+			if likes, _, _ := repo.GetLikesDislikes(int(pid)); likes != 0 {
+				t.Errorf("Голос за скрытый пост засчитан")
+			}
+			if comments, _ := repo.GetCommentsByPostID(int(pid)); len(comments) != 1 {
+				t.Errorf("Комментарий к скрытому посту добавлен")
+			}
+			if reports, _ := repo.GetReportsByReporter(bob.ID); len(reports) != 0 {
+				t.Errorf("Жалоба на скрытый пост принята")
+			}
+			if notifications, _ := repo.GetNotificationsByUser(alice.ID); len(notifications) != 0 {
+				t.Errorf("Автор получил уведомление о скрытом посте")
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"forum/internal/db"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminAuditPerPage = 50
+
+// audit records an action by a moderator or admin in the audit log; what
+// regular users do with their own content is not recorded. before and after
+// are stored as JSON and may be nil. The action has already happened, so a
+// failure to record it is only logged.
+func audit(repo *db.Repository, logger *log.Logger, r *http.Request, action, targetType string, targetID int, before, after interface{}) {
+	role, _ := r.Context().Value("role").(string)
+	if role != "moderator" && role != "admin" {
+		return
+	}
+	actorID, _ := r.Context().Value("userID").(int)
+	entry := &models.AuditEntry{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		IP:         clientIP(r),
+	}
+	if err := repo.RecordAudit(entry); err != nil {
+		logger.Printf("Ошибка записи в журнал аудита (%s %s %d): %v", action, targetType, targetID, err)
+	}
+}
+
+func auditSnapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Snapshots of audited objects, limited to what a moderator can change.
+
+func postSnapshot(p *models.Post) map[string]string {
+	return map[string]string{"title": p.Title, "content": p.Content, "visibility": p.Visibility}
+}
+
+func commentSnapshot(c *models.Comment) map[string]string {
+	return map[string]string{"content": c.Content, "visibility": c.Visibility}
+}
+
+func reportSnapshot(rep *models.Report) map[string]string {
+	return map[string]string{"status": rep.Status, "action": rep.Action, "note": rep.ResolutionNote}
+}
+
+func banSnapshot(b *models.Ban) map[string]interface{} {
+	return map[string]interface{}{"ban_id": b.ID, "reason": b.Reason, "expires_at": b.ExpiresAt, "lifted_at": b.LiftedAt}
+}
+
+func automodSnapshot(rule *models.AutomodRule) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                 rule.Name,
+		"kind":                 rule.Kind,
+		"pattern":              rule.Pattern,
+		"threshold":            rule.Threshold,
+		"max_account_age_days": rule.MaxAccountAgeDays,
+		"action":               rule.Action,
+		"enabled":              rule.Enabled,
+	}
+}
+
+// auditQuery reads the audit log filters from the query string: actor,
+// action, and the dates from and to, both inclusive.
+func auditQuery(params url.Values) (models.AuditQuery, string) {
+	q := models.AuditQuery{
+		Actor:  strings.TrimSpace(params.Get("actor")),
+		Action: params.Get("action"),
+	}
+	var formError string
+	if from := params.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			formError = "Неверная начальная дата"
+		} else {
+			q.From = &t
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			formError = "Неверная конечная дата"
+		} else {
+			t = t.AddDate(0, 0, 1)
+			q.To = &t
+		}
+	}
+	return q, formError
+}
+
+// auditFilterValues copies the audit log filters that are set.
+func auditFilterValues(params url.Values) url.Values {
+	v := url.Values{}
+	for _, key := range []string{"actor", "action", "from", "to"} {
+		if params.Get(key) != "" {
+			v.Set(key, params.Get(key))
+		}
+	}
+	return v
+}
+
+// Audit shows the audit log, newest first, filtered by actor, action and
+// date.
+func (h *AdminHandler) Audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	page, _ := strconv.Atoi(params.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	query, formError := auditQuery(params)
+	if formError == "" {
+		formError = params.Get("error")
+	}
+	query.Limit, query.Offset = adminAuditPerPage, (page-1)*adminAuditPerPage
+
+	data := map[string]interface{}{
+		"Actor":   query.Actor,
+		"Action":  query.Action,
+		"From":    params.Get("from"),
+		"To":      params.Get("to"),
+		"Actions": models.AuditActions,
+		"Page":    page,
+		"Error":   formError,
+	}
+	filters := auditFilterValues(params)
+	data["ExportQuery"] = filters.Encode()
+	if formError == "" {
+		entries, total, err := h.repo.ListAuditLog(query)
+		if err != nil {
+			h.log.Printf("Ошибка загрузки журнала аудита: %v", err)
+			renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+			return
+		}
+		data["Entries"] = entries
+		data["Total"] = total
+		pageURL := func(p int) string {
+			filters.Set("page", strconv.Itoa(p))
+			return "/admin/audit?" + filters.Encode()
+		}
+		if page*adminAuditPerPage < total {
+			data["NextURL"] = pageURL(page + 1)
+		}
+		if page > 1 {
+			data["PrevURL"] = pageURL(page - 1)
+		}
+	}
+	h.render(w, r, "admin_audit.html", data)
+}
+
+// auditRecord is an audit log entry in the JSON export.
+type auditRecord struct {
+	ID         int             `json:"id"`
+	ActorID    int             `json:"actor_id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int             `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// rawSnapshot turns a stored snapshot into JSON, null if it is empty.
+func rawSnapshot(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
+
+// ExportAudit downloads the audit log entries matching the filters of the
+// admin page as CSV or, with ?format=json, as JSON.
+func (h *AdminHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	query, formError := auditQuery(params)
+	if formError != "" {
+		http.Redirect(w, r, "/admin/audit?error="+url.QueryEscape(formError), http.StatusSeeOther)
+		return
+	}
+	query.Limit = -1 // no limit
+	entries, _, err := h.repo.ListAuditLog(query)
+	if err != nil {
+		h.log.Printf("Ошибка выгрузки журнала аудита: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	h.log.Printf("Журнал аудита выгружен администратором %d (%d записей)", adminID, len(entries))
+
+	filename := "audit-" + time.Now().UTC().Format("20060102-150405")
+	if params.Get("format") == "json" {
+		records := make([]auditRecord, 0, len(entries))
+		for _, e := range entries {
+			records = append(records, auditRecord{
+				ID: e.ID, ActorID: e.ActorID, Actor: e.ActorName, Action: e.Action,
+				TargetType: e.TargetType, TargetID: e.TargetID,
+				Before: rawSnapshot(e.Before), After: rawSnapshot(e.After),
+				IP: e.IP, CreatedAt: e.CreatedAt.UTC(),
+			})
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		writeJSON(w, http.StatusOK, records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "actor_id", "actor", "action", "target_type", "target_id", "before", "after", "ip"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(e.ID), e.CreatedAt.UTC().Format(time.RFC3339), strconv.Itoa(e.ActorID), e.ActorName,
+			e.Action, e.TargetType, strconv.Itoa(e.TargetID), e.Before, e.After, e.IP,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		h.log.Printf("Ошибка выгрузки журнала аудита: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"forum/internal/automod"
+	"forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"forum/internal/throttle"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// asUser sends a form POST to the handler as the given user and returns the
+// response status.
+func asUser(h http.HandlerFunc, user *models.User, target string, form url.Values) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := context.WithValue(req.Context(), "userID", user.ID)
+	ctx = context.WithValue(ctx, "role", user.Role)
+	rec := httptest.NewRecorder()
+	h(rec, req.WithContext(ctx))
+	return rec.Code
+}
+
+func auditEntries(t *testing.T, repo *db.Repository, action string) []*models.AuditEntry {
+	t.Helper()
+	entries, _, err := repo.ListAuditLog(models.AuditQuery{Action: action, Limit: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAuditPrivilegedActions(t *testing.T) {
+	repo := setupTestRepo(t)
+	admin, _ := createTestUser(t, repo, "admin", "admin")
+	admin.Role = "admin"
+	bob, _ := createTestUser(t, repo, "bob", "user")
+	mailer := mail.NewMailer(repo, mail.LogTransport{Log: testLogger}, testLogger, "http://localhost")
+	admins := NewAdminHandler(repo, testLogger, "", mailer)
+	bans := NewBanHandler(repo, testLogger, "", mailer)
+	reports := NewReportHandler(repo, testLogger, "", mailer)
+	revisions := NewRevisionHandler(repo, testLogger, "")
+
+	pid, _ := repo.CreatePost(&models.Post{UserID: bob.ID, Title: "Первый пост", Content: "Исходный текст"})
+	postID := int(pid)
+	repo.UpdatePost(postID, bob.ID, "Первый пост", "Изменённый текст")
+	revs, _ := repo.GetRevisions(models.RevisionPost, postID)
+	repo.CreateReport(admin.ID, &postID, nil, "спам")
+	userQuery := "?id=" + strconv.Itoa(bob.ID)
+
+	steps := []struct {
+		action   string
+		targetID int
+		send     func() int
+	}{
+		{models.AuditUserBan, bob.ID, func() int {
+			return asUser(bans.CreateBan, admin, "/bans/create", url.Values{"username": {"bob"}, "reason": {"спам"}, "duration": {"24h"}})
+		}},
+		{models.AuditUserUnban, bob.ID, func() int {
+			ban, _ := repo.GetActiveBan(bob.ID)
+			if ban == nil {
+				t.Fatal("Бан не создан")
+			}
+			return asUser(bans.LiftBan, admin, "/bans/lift?id="+strconv.Itoa(ban.ID), nil)
+		}},
+		{models.AuditUserRole, bob.ID, func() int {
+			return asUser(admins.ChangeRole, admin, "/admin/user/role"+userQuery, url.Values{"role": {"moderator"}})
+		}},
+		{models.AuditUserSessionsRevoke, bob.ID, func() int {
+			return asUser(admins.RevokeSessions, admin, "/admin/user/sessions"+userQuery, nil)
+		}},
+		{models.AuditUserPasswordReset, bob.ID, func() int {
+			return asUser(admins.ForcePasswordReset, admin, "/admin/user/reset"+userQuery, nil)
+		}},
+		{models.AuditLockoutClear, 0, func() int {
+			return asUser(admins.ClearLockout, admin, "/admin/lockouts/clear?key="+url.QueryEscape(throttle.UserPrefix+"bob"), nil)
+		}},
+		{models.AuditAutomodCreate, 1, func() int {
+			return asUser(admins.SaveAutomodRule, admin, "/admin/automod/save", url.Values{
+				"name": {"Ссылки"}, "kind": {automod.KindWords}, "pattern": {"casino"}, "action": {automod.ActionHold}, "enabled": {"on"},
+			})
+		}},
+		{models.AuditAutomodDelete, 1, func() int {
+			return asUser(admins.DeleteAutomodRule, admin, "/admin/automod/delete?id=1", nil)
+		}},
+		{models.AuditPostRollback, postID, func() int {
+			return asUser(revisions.Rollback, admin, "/history/rollback?id="+strconv.Itoa(revs[0].ID), nil)
+		}},
+		{models.AuditPostHide, postID, func() int {
+			return asUser(reports.ResolveReport, admin, "/reports/resolve?id=1", url.Values{"action": {models.ReportActionHide}})
+		}},
+	}
+	for _, step := range steps {
+		if code := step.send(); code != http.StatusSeeOther {
+			t.Errorf("%s: статус %d, ожидался редирект", step.action, code)
+		}
+		entries := auditEntries(t, repo, step.action)
+		if len(entries) != 1 {
+			t.Errorf("%s: %d записей в журнале аудита, ожидалась одна", step.action, len(entries))
+			continue
+		}
+		if e := entries[0]; e.ActorID != admin.ID || e.TargetID != step.targetID {
+			t.Errorf("%s: неверная запись %+v", step.action, e)
+		}
+	}
+
+	role := auditEntries(t, repo, models.AuditUserRole)
+	if len(role) == 1 && (role[0].Before != `{"role":"user"}` || role[0].After != `{"role":"moderator"}`) {
+		t.Errorf("Неверные снимки смены роли: %s -> %s", role[0].Before, role[0].After)
+	}
+	rollback := auditEntries(t, repo, models.AuditPostRollback)
+	if len(rollback) == 1 && (!strings.Contains(rollback[0].Before, "Изменённый текст") || !strings.Contains(rollback[0].After, "Исходный текст")) {
+		t.Errorf("Неверные снимки отката: %s -> %s", rollback[0].Before, rollback[0].After)
+	}
+
+	// A post in the trash is not rolled back and nothing is recorded.
+	repo.DeletePost(postID, admin.ID)
+	if code := asUser(revisions.Rollback, admin, "/history/rollback?id="+strconv.Itoa(revs[1].ID), nil); code != http.StatusNotFound {
+		t.Errorf("Откат удалённого поста: статус %d, ожидался 404", code)
+	}
+	if n := len(auditEntries(t, repo, models.AuditPostRollback)); n != 1 {
+		t.Errorf("Откат удалённого поста записан в журнал: %d записей", n)
+	}
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/middleware"
 	"forum/internal/models"
+	"forum/internal/throttle"
 	"log"
 	"net/http"
 	"regexp"
@@ -11,26 +16,45 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
-	"html/template"
-	"path/filepath"
-
 	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-var loginAttempts = make(map[string][]time.Time) // username -> slice of failed attempt times
-const maxLoginAttempts = 5
-const loginBlockDuration = 10 * time.Minute
+const (
+	verificationTokenTTL       = 24 * time.Hour
+	verificationResendCooldown = time.Minute
+	verificationMaxPerDay      = 5
+)
+
+// AuthOptions configures sign-in.
+type AuthOptions struct {
+	// SessionTTL is the lifetime of a session; RememberMeTTL replaces it
+	// when the user ticks "remember me"
+	SessionTTL    time.Duration
+	RememberMeTTL time.Duration
+	// TwoFactorRoles lists roles that must enable two-factor authentication
+	TwoFactorRoles []string
+	// LoginThrottle limits password and code guessing; nil uses the default
+	// policies with the repository as the store
+	LoginThrottle *throttle.Throttler
+	// Cookies sets the attributes of the session and login cookies
+	Cookies middleware.CookiePolicy
+}
 
 type AuthHandler struct {
 	repo        *db.Repository
 	log         *log.Logger
 	projectRoot string
+	mailer      *mail.Mailer
+	opts        AuthOptions
 }
 
-func NewAuthHandler(repo *db.Repository, log *log.Logger, projectRoot string) *AuthHandler {
-	return &AuthHandler{repo: repo, log: log, projectRoot: projectRoot}
+func NewAuthHandler(repo *db.Repository, log *log.Logger, projectRoot string, mailer *mail.Mailer, opts AuthOptions) *AuthHandler {
+	if opts.LoginThrottle == nil {
+		opts.LoginThrottle = throttle.New(repo, throttle.DefaultUserPolicy, throttle.DefaultIPPolicy)
+	}
+	return &AuthHandler{repo: repo, log: log, projectRoot: projectRoot, mailer: mailer, opts: opts}
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -44,15 +68,17 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "register.html"))
+		tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "register.html")
 		if err != nil {
 			h.log.Printf("Ошибка загрузки шаблона: %v", err)
 			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 			return
 		}
 		data := map[string]interface{}{
-			"Error":   r.URL.Query().Get("error"),
-			"Success": r.URL.Query().Get("success"),
+			"CSRFToken": csrfToken(r),
+			"CSPNonce":  cspNonce(r),
+			"Error":     r.URL.Query().Get("error"),
+			"Success":   r.URL.Query().Get("success"),
 		}
 		tmpl.Execute(w, data)
 		return
@@ -111,7 +137,10 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Println("created")
 		h.log.Printf("Пользователь зарегистрирован: %s", username)
-		http.Redirect(w, r, "/login?success=Регистрация успешна", http.StatusSeeOther)
+		if err := h.sendVerification(user); err != nil {
+			h.log.Printf("Ошибка отправки письма подтверждения: %v", err)
+		}
+		http.Redirect(w, r, "/login?success=Регистрация успешна. Мы отправили письмо для подтверждения email", http.StatusSeeOther)
 		return
 	}
 	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
@@ -128,15 +157,17 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "login.html"))
+		tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "login.html")
 		if err != nil {
 			h.log.Printf("Ошибка загрузки шаблона: %v", err)
 			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 			return
 		}
 		data := map[string]interface{}{
-			"Error":   r.URL.Query().Get("error"),
-			"Success": r.URL.Query().Get("success"),
+			"CSRFToken": csrfToken(r),
+			"CSPNonce":  cspNonce(r),
+			"Error":     r.URL.Query().Get("error"),
+			"Success":   r.URL.Query().Get("success"),
 		}
 		tmpl.Execute(w, data)
 		return
@@ -144,72 +175,140 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		username := strings.ReplaceAll(strings.TrimSpace(strings.ToLower(r.FormValue("username"))), " ", "")
 		password := r.FormValue("password")
+		remember := r.FormValue("remember") != ""
 
-		// Проверка блокировки
-		now := time.Now()
-		attempts := loginAttempts[username]
-		// Оставляем только попытки за последние 10 минут
-		var recent []time.Time
-		for _, t := range attempts {
-			if now.Sub(t) < loginBlockDuration {
-				recent = append(recent, t)
-			}
-		}
-		if len(recent) >= maxLoginAttempts {
-			http.Redirect(w, r, "/login?error=Слишком много неудачных попыток. Попробуйте через 10 минут.", http.StatusSeeOther)
+		attempt, ok := h.loginAllowed(w, r, username, clientIP(r))
+		if !ok {
 			return
 		}
-		loginAttempts[username] = recent
 
 		user, err := h.repo.GetUserByUsername(username)
 		if err != nil {
 			h.log.Printf("Ошибка входа для пользователя %s: %v", username, err)
-			loginAttempts[username] = append(loginAttempts[username], now)
 			http.Redirect(w, r, "/login?error=Неверный логин или пароль", http.StatusSeeOther)
 			return
 		}
 
 		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
 			h.log.Printf("Ошибка входа: неверный пароль для пользователя %s", username)
-			loginAttempts[username] = append(loginAttempts[username], now)
 			http.Redirect(w, r, "/login?error=Неверный логин или пароль", http.StatusSeeOther)
 			return
 		}
 
-		// Успешный вход — сбрасываем попытки
-		delete(loginAttempts, username)
-
-		sessionID := uuid.New().String()
-		expiresAt := time.Now().Add(24 * time.Hour)
-		session := &models.Session{
-			SessionID: sessionID,
-			UserID:    user.ID,
-			Expires:   expiresAt,
+		// С включённой 2FA сессия создаётся только после проверки кода,
+		// а верный пароль не засчитывается как попытка
+		if user.TwoFactorEnabled() {
+			h.loginRefunded(attempt)
+			challenge, err := h.repo.CreateLoginChallenge(user.ID, remember, loginChallengeTTL)
+			if err != nil {
+				h.log.Printf("Ошибка создания запроса 2FA: %v", err)
+				http.Redirect(w, r, "/login?error=Ошибка входа", http.StatusSeeOther)
+				return
+			}
+			h.opts.Cookies.Set(w, &http.Cookie{
+				Name:   "login_challenge",
+				Value:  challenge,
+				Path:   "/login",
+				MaxAge: int(loginChallengeTTL / time.Second),
+			})
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+			return
 		}
 
-		if err := h.repo.CreateSession(session); err != nil {
+		h.loginSucceeded(attempt)
+		if err := h.startSession(w, r, user, remember); err != nil {
 			h.log.Printf("Ошибка создания сессии: %v", err)
 			http.Redirect(w, r, "/login?error=Ошибка входа", http.StatusSeeOther)
 			return
 		}
+		h.log.Printf("Пользователь вошел: %s", username)
+		http.Redirect(w, r, h.afterLoginURL(user), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+}
+
+// startSession creates a session for the user and sets the session cookie.
+// Other sessions stay signed in. Without remember the cookie ends with the
+// browser session.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) error {
+	ttl := h.opts.SessionTTL
+	if remember {
+		ttl = h.opts.RememberMeTTL
+	}
+	session := &models.Session{
+		SessionID: uuid.New().String(),
+		UserID:    user.ID,
+		Expires:   time.Now().Add(ttl),
+		UserAgent: truncate(r.UserAgent(), 255),
+		IP:        clientIP(r),
+	}
+	if err := h.repo.CreateSession(session); err != nil {
+		return err
+	}
 
-		// Удаляем все другие сессии этого пользователя
-		if err := h.repo.DeleteUserSessions(user.ID, sessionID); err != nil {
-			h.log.Printf("Ошибка удаления старых сессий: %v", err)
+	cookie := &http.Cookie{
+		Name:  "session_id",
+		Value: session.SessionID,
+		Path:  "/",
+	}
+	if remember {
+		cookie.Expires = session.Expires
+	}
+	h.opts.Cookies.Set(w, cookie)
+	return nil
+}
+
+// afterLoginURL sends users whose role requires two-factor authentication
+// to set it up; everyone else goes to the home page.
+func (h *AuthHandler) afterLoginURL(user *models.User) string {
+	if !user.TwoFactorEnabled() {
+		for _, role := range h.opts.TwoFactorRoles {
+			if role == user.Role {
+				return "/profile?error=Права модератора и администратора доступны только с двухфакторной аутентификацией. Включите её ниже#two-factor"
+			}
 		}
+	}
+	return "/?success=Вход выполнен"
+}
+
+// loginAllowed checks the lockouts of the username and address and
+// reserves an attempt, which counts as a failure unless loginSucceeded or
+// loginRefunded takes it back. If either is locked it redirects to the login
+// page with the remaining wait.
+func (h *AuthHandler) loginAllowed(w http.ResponseWriter, r *http.Request, username, ip string) (*throttle.Attempt, bool) {
+	attempt, wait, err := h.opts.LoginThrottle.Begin(username, ip)
+	if err != nil {
+		h.log.Printf("Ошибка проверки блокировки входа: %v", err)
+		http.Redirect(w, r, "/login?error=Ошибка входа", http.StatusSeeOther)
+		return nil, false
+	}
+	if wait > 0 {
+		h.log.Printf("Вход заблокирован для %s с адреса %s ещё на %v", username, ip, wait.Round(time.Second))
+		http.Redirect(w, r, "/login?error=Слишком много неудачных попыток. Попробуйте через "+formatWait(wait), http.StatusSeeOther)
+		return nil, false
+	}
+	return attempt, true
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session_id",
-			Value:   sessionID,
-			Path:    "/",
-			Expires: expiresAt,
-		})
+func (h *AuthHandler) loginSucceeded(attempt *throttle.Attempt) {
+	if err := attempt.Succeed(); err != nil {
+		h.log.Printf("Ошибка сброса неудачных входов: %v", err)
+	}
+}
 
-		h.log.Printf("Пользователь вошел: %s", username)
-		http.Redirect(w, r, "/?success=Вход выполнен", http.StatusSeeOther)
-		return
+func (h *AuthHandler) loginRefunded(attempt *throttle.Attempt) {
+	if err := attempt.Refund(); err != nil {
+		h.log.Printf("Ошибка отмены попытки входа: %v", err)
 	}
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+}
+
+// formatWait describes a lockout in whole seconds or minutes, rounded up.
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d сек.", int((d+time.Second-1)/time.Second))
+	}
+	return fmt.Sprintf("%d мин.", int((d+time.Minute-1)/time.Minute))
 }
 
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -220,7 +319,7 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	http.SetCookie(w, &http.Cookie{
+	h.opts.Cookies.Set(w, &http.Cookie{
 		Name:   "session_id",
 		Value:  "",
 		Path:   "/",
@@ -230,3 +329,105 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	h.log.Printf("Пользователь вышел из системы")
 	http.Redirect(w, r, "/?success=Вы успешно вышли", http.StatusSeeOther)
 }
+
+// sendVerification issues a new email verification token and mails the link.
+func (h *AuthHandler) sendVerification(user *models.User) error {
+	token, err := h.repo.CreateEmailVerification(user.ID, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(user.Email, "verify", map[string]interface{}{
+		"Username": user.Username,
+		"Token":    token,
+		"ValidFor": "24 hours",
+	})
+}
+
+// Verify confirms an email address using the token from the verification mail.
+func (h *AuthHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, err := h.repo.VerifyEmail(r.URL.Query().Get("token"))
+	if errors.Is(err, db.ErrInvalidVerificationToken) {
+		renderError(w, http.StatusBadRequest, "Ссылка недействительна", "Ссылка для подтверждения устарела или уже использована. Войдите и запросите новое письмо в профиле.", h.projectRoot)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Ошибка подтверждения email: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	if user, err := h.repo.GetUserByID(userID); err == nil {
+		h.log.Printf("Email подтверждён: %s", user.Username)
+		if err := h.mailer.Send(user.Email, "welcome", map[string]interface{}{"Username": user.Username}); err != nil {
+			h.log.Printf("Ошибка отправки письма: %v", err)
+		}
+	}
+
+	// Залогиненного пользователя отправляем в профиль, остальных — на вход
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		if _, err := h.repo.GetSession(cookie.Value); err == nil {
+			http.Redirect(w, r, "/profile?success=Email подтверждён", http.StatusSeeOther)
+			return
+		}
+	}
+	http.Redirect(w, r, "/login?success=Email подтверждён, можно войти", http.StatusSeeOther)
+}
+
+// ResendVerification sends a new verification mail to the current user. At
+// most one mail per minute and five per day are sent.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		h.log.Printf("Ошибка получения пользователя: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка отправки письма", http.StatusSeeOther)
+		return
+	}
+	if user.EmailVerified() {
+		http.Redirect(w, r, "/profile?success=Email уже подтверждён", http.StatusSeeOther)
+		return
+	}
+
+	if msg, err := h.verificationThrottled(userID); err != nil {
+		h.log.Printf("Ошибка проверки лимита писем: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка отправки письма", http.StatusSeeOther)
+		return
+	} else if msg != "" {
+		http.Redirect(w, r, "/profile?error="+msg, http.StatusSeeOther)
+		return
+	}
+
+	if err := h.sendVerification(user); err != nil {
+		h.log.Printf("Ошибка отправки письма подтверждения: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка отправки письма", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/profile?success=Письмо отправлено повторно", http.StatusSeeOther)
+}
+
+// verificationThrottled returns a message for the user if another
+// verification mail may not be sent yet, or "" if it may.
+func (h *AuthHandler) verificationThrottled(userID int) (string, error) {
+	now := time.Now()
+	recent, err := h.repo.CountEmailVerificationsSince(userID, now.Add(-verificationResendCooldown))
+	if err != nil || recent > 0 {
+		return "Письмо уже отправлено. Повторить можно через минуту", err
+	}
+	today, err := h.repo.CountEmailVerificationsSince(userID, now.Add(-24*time.Hour))
+	if err != nil || today >= verificationMaxPerDay {
+		return "Превышен лимит писем на сегодня. Попробуйте завтра", err
+	}
+	return "", nil
+}
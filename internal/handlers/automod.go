@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"forum/internal/automod"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const adminAutomodMatchesPerPage = 50
+
+// Automod lists the automod rules and the match log, with a form to add a
+// rule or edit the one given by ?edit=.
+func (h *AdminHandler) Automod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	rules, err := h.repo.GetAutomodRules()
+	if err != nil {
+		h.log.Printf("Ошибка загрузки правил автомодерации: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	page, _ := strconv.Atoi(params.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	// One extra row tells whether there is a next page
+	matches, err := h.repo.GetAutomodMatches(adminAutomodMatchesPerPage+1, (page-1)*adminAutomodMatchesPerPage)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки журнала автомодерации: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	form := &models.AutomodRule{Kind: automod.KindWords, Action: automod.ActionHold, Enabled: true}
+	if id, err := strconv.Atoi(params.Get("edit")); err == nil {
+		for _, rule := range rules {
+			if rule.ID == id {
+				form = rule
+			}
+		}
+	}
+	data := map[string]interface{}{
+		"Rules":   rules,
+		"Form":    form,
+		"Kinds":   automod.Kinds,
+		"Actions": automod.Actions,
+		"Matches": matches,
+		"Page":    page,
+		"Error":   params.Get("error"),
+		"Success": params.Get("success"),
+	}
+	if len(matches) > adminAutomodMatchesPerPage {
+		data["Matches"] = matches[:adminAutomodMatchesPerPage]
+		data["NextURL"] = "/admin/automod?page=" + strconv.Itoa(page+1)
+	}
+	if page > 1 {
+		data["PrevURL"] = "/admin/automod?page=" + strconv.Itoa(page-1)
+	}
+	h.render(w, r, "admin_automod.html", data)
+}
+
+// SaveAutomodRule creates a rule, or updates the one given by ?id=.
+func (h *AdminHandler) SaveAutomodRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+
+	rule := &models.AutomodRule{CreatedBy: adminID}
+	var before interface{}
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Redirect(w, r, "/admin/automod?error=Неверный ID правила", http.StatusSeeOther)
+			return
+		}
+		if rule, err = h.repo.GetAutomodRuleByID(id); err != nil {
+			http.Redirect(w, r, "/admin/automod?error=Правило не найдено", http.StatusSeeOther)
+			return
+		}
+		before = automodSnapshot(rule)
+	}
+	rule.Name = strings.TrimSpace(r.FormValue("name"))
+	rule.Kind = r.FormValue("kind")
+	rule.Pattern = strings.TrimSpace(r.FormValue("pattern"))
+	rule.Action = r.FormValue("action")
+	rule.Enabled = r.FormValue("enabled") != ""
+	threshold, err := strconv.Atoi(strings.TrimSpace(r.FormValue("threshold")))
+	if err != nil && strings.TrimSpace(r.FormValue("threshold")) != "" {
+		http.Redirect(w, r, "/admin/automod?error=Порог должен быть числом", http.StatusSeeOther)
+		return
+	}
+	rule.Threshold = threshold
+	days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("max_account_age_days")))
+	if err != nil && strings.TrimSpace(r.FormValue("max_account_age_days")) != "" {
+		http.Redirect(w, r, "/admin/automod?error=Возраст аккаунта должен быть числом", http.StatusSeeOther)
+		return
+	}
+	rule.MaxAccountAgeDays = days
+	if utf8.RuneCountInString(rule.Name) > 100 || utf8.RuneCountInString(rule.Pattern) > 5000 {
+		http.Redirect(w, r, "/admin/automod?error=Название не длиннее 100 символов, шаблон не длиннее 5000", http.StatusSeeOther)
+		return
+	}
+	if err := automod.Validate(rule); err != nil {
+		http.Redirect(w, r, "/admin/automod?error="+automodRuleError(err), http.StatusSeeOther)
+		return
+	}
+
+	action := models.AuditAutomodUpdate
+	if rule.ID == 0 {
+		action = models.AuditAutomodCreate
+		err = h.repo.CreateAutomodRule(rule)
+	} else {
+		err = h.repo.UpdateAutomodRule(rule)
+	}
+	if err != nil {
+		h.log.Printf("Ошибка сохранения правила автомодерации: %v", err)
+		http.Redirect(w, r, "/admin/automod?error=Ошибка сохранения правила", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("Правило автомодерации %d (%s) сохранено администратором %d", rule.ID, rule.Name, adminID)
+	audit(h.repo, h.log, r, action, "automod_rule", rule.ID, before, automodSnapshot(rule))
+	http.Redirect(w, r, "/admin/automod?success=Правило сохранено", http.StatusSeeOther)
+}
+
+// DeleteAutomodRule removes a rule. Its matches stay in the log.
+func (h *AdminHandler) DeleteAutomodRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/automod?error=Неверный ID правила", http.StatusSeeOther)
+		return
+	}
+	rule, err := h.repo.GetAutomodRuleByID(id)
+	if err != nil {
+		http.Redirect(w, r, "/admin/automod?error=Правило не найдено", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteAutomodRule(id); err != nil {
+		h.log.Printf("Ошибка удаления правила автомодерации: %v", err)
+		http.Redirect(w, r, "/admin/automod?error=Ошибка удаления правила", http.StatusSeeOther)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	h.log.Printf("Правило автомодерации %d удалено администратором %d", id, adminID)
+	audit(h.repo, h.log, r, models.AuditAutomodDelete, "automod_rule", id, automodSnapshot(rule), nil)
+	http.Redirect(w, r, "/admin/automod?success=Правило удалено", http.StatusSeeOther)
+}
+
+// automodRuleError explains why automod.Validate refused a rule.
+func automodRuleError(err error) string {
+	switch {
+	case errors.Is(err, automod.ErrEmptyName):
+		return "Укажите название правила"
+	case errors.Is(err, automod.ErrUnknownKind):
+		return "Неизвестный тип правила"
+	case errors.Is(err, automod.ErrUnknownAction):
+		return "Неизвестное действие"
+	case errors.Is(err, automod.ErrEmptyPattern):
+		return "Укажите слова или регулярное выражение"
+	case errors.Is(err, automod.ErrBadThreshold):
+		return "Недопустимый порог или возраст аккаунта"
+	}
+	return "Неверное регулярное выражение: " + err.Error()
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// banDuration is one of the ban lengths offered to moderators. An empty
+// Value means a permanent ban.
+type banDuration struct {
+	Value string
+	Label string
+}
+
+var banDurations = []banDuration{
+	{"1h", "1 hour"},
+	{"24h", "1 day"},
+	{"168h", "7 days"},
+	{"720h", "30 days"},
+	{"", "Permanent"},
+}
+
+// parseBanDuration returns the expiry of a ban of the given length starting
+// now, nil for a permanent ban. Only the offered lengths are accepted.
+func parseBanDuration(value string, now time.Time) (*time.Time, bool) {
+	for _, d := range banDurations {
+		if d.Value != value {
+			continue
+		}
+		if value == "" {
+			return nil, true
+		}
+		length, _ := time.ParseDuration(value)
+		expires := now.Add(length)
+		return &expires, true
+	}
+	return nil, false
+}
+
+// canBan reports whether a user with the given role may ban target.
+// Moderators may ban users, admins may also ban moderators, and admins
+// cannot be banned.
+func canBan(role string, target *models.User) bool {
+	switch target.Role {
+	case "user":
+		return role == "moderator" || role == "admin"
+	case "moderator":
+		return role == "admin"
+	}
+	return false
+}
+
+// BanHandler lets moderators suspend users and shows banned users why they
+// cannot write.
+type BanHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+	mailer      *mail.Mailer
+}
+
+func NewBanHandler(repo *db.Repository, log *log.Logger, projectRoot string, mailer *mail.Mailer) *BanHandler {
+	return &BanHandler{repo: repo, log: log, projectRoot: projectRoot, mailer: mailer}
+}
+
+// Bans lists the bans in force and shows the form to ban a user (only for
+// moderator and admin).
+func (h *BanHandler) Bans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Доступ запрещён", h.projectRoot)
+		return
+	}
+	bans, err := h.repo.ListActiveBans()
+	if err != nil {
+		h.log.Printf("Ошибка загрузки банов: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "bans.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Bans":      bans,
+		"Durations": banDurations,
+		"Username":  r.URL.Query().Get("username"),
+		"Error":     r.URL.Query().Get("error"),
+		"Success":   r.URL.Query().Get("success"),
+	})
+}
+
+// CreateBan bans the user named in the form for the chosen time (only for
+// moderator and admin).
+func (h *BanHandler) CreateBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Доступ запрещён", h.projectRoot)
+		return
+	}
+	moderatorID, _ := r.Context().Value("userID").(int)
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	back := "/bans?username=" + url.QueryEscape(username) + "&"
+	user, err := h.repo.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, back+"error=Пользователь не найден", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Ошибка загрузки пользователя: %v", err)
+		http.Redirect(w, r, back+"error=Внутренняя ошибка сервера", http.StatusSeeOther)
+		return
+	}
+	if user.ID == moderatorID || !canBan(role, user) {
+		http.Redirect(w, r, back+"error=Нельзя заблокировать этого пользователя", http.StatusSeeOther)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if n := utf8.RuneCountInString(reason); n < 3 || n > 500 {
+		http.Redirect(w, r, back+"error=Причина должна быть от 3 до 500 символов", http.StatusSeeOther)
+		return
+	}
+	expires, ok := parseBanDuration(r.FormValue("duration"), time.Now())
+	if !ok {
+		http.Redirect(w, r, back+"error=Неверный срок блокировки", http.StatusSeeOther)
+		return
+	}
+
+	ban := &models.Ban{UserID: user.ID, Reason: reason, IssuedBy: moderatorID, ExpiresAt: expires}
+	if err := h.repo.CreateBan(ban); err != nil {
+		h.log.Printf("Ошибка блокировки пользователя %d: %v", user.ID, err)
+		http.Redirect(w, r, back+"error=Ошибка блокировки", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("Пользователь %d заблокирован модератором %d", user.ID, moderatorID)
+	audit(h.repo, h.log, r, models.AuditUserBan, "user", user.ID, nil, banSnapshot(ban))
+	notifyBan(h.repo, h.mailer, h.log, user, ban, false, moderatorID)
+	http.Redirect(w, r, "/bans?success=Пользователь "+url.QueryEscape(user.Username)+" заблокирован", http.StatusSeeOther)
+}
+
+// LiftBan ends a ban early (only for moderator and admin).
+func (h *BanHandler) LiftBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Доступ запрещён", h.projectRoot)
+		return
+	}
+	moderatorID, _ := r.Context().Value("userID").(int)
+
+	banID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/bans?error=Некорректный id", http.StatusSeeOther)
+		return
+	}
+	ban, err := h.repo.GetBanByID(banID)
+	if err != nil || !ban.Active(time.Now()) {
+		http.Redirect(w, r, "/bans?error=Блокировка не найдена или уже снята", http.StatusSeeOther)
+		return
+	}
+	user, err := h.repo.GetUserByID(ban.UserID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки пользователя %d: %v", ban.UserID, err)
+		http.Redirect(w, r, "/bans?error=Внутренняя ошибка сервера", http.StatusSeeOther)
+		return
+	}
+	if !canBan(role, user) {
+		http.Redirect(w, r, "/bans?error=Нельзя снять эту блокировку", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.LiftBan(ban.ID, moderatorID); err != nil {
+		h.log.Printf("Ошибка снятия блокировки %d: %v", ban.ID, err)
+		http.Redirect(w, r, "/bans?error=Ошибка снятия блокировки", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("Блокировка пользователя %d снята модератором %d", user.ID, moderatorID)
+	var after interface{}
+	if lifted, err := h.repo.GetBanByID(ban.ID); err == nil {
+		after = banSnapshot(lifted)
+	}
+	audit(h.repo, h.log, r, models.AuditUserUnban, "user", user.ID, banSnapshot(ban), after)
+	notifyBan(h.repo, h.mailer, h.log, user, ban, true, moderatorID)
+	http.Redirect(w, r, "/bans?success=Блокировка снята", http.StatusSeeOther)
+}
+
+// Banned explains the current user's ban and when it ends.
+func (h *BanHandler) Banned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	ban := activeBan(r)
+	if ban == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "banned.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Ban":       ban,
+	})
+}
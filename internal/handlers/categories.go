@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"forum/internal/db"
-	"html/template"
+	"forum/internal/models"
 	"log"
 	"net/http"
 )
@@ -30,7 +30,7 @@ func (h *CategoryHandler) ListCategories(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("static/categories.html")
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "categories.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
@@ -53,6 +53,8 @@ func (h *CategoryHandler) ListCategories(w http.ResponseWriter, r *http.Request)
 	}
 
 	data := map[string]interface{}{
+		"CSRFToken":       csrfToken(r),
+		"CSPNonce":        cspNonce(r),
 		"Categories":      categories,
 		"Error":           r.URL.Query().Get("error"),
 		"Success":         r.URL.Query().Get("success"),
@@ -89,11 +91,13 @@ func (h *CategoryHandler) CreateCategory(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, "/categories?error=Введите название категории", http.StatusSeeOther)
 		return
 	}
-	if err := h.repo.CreateCategory(name); err != nil {
+	id, err := h.repo.CreateCategory(name)
+	if err != nil {
 		h.log.Printf("Ошибка создания категории: %v", err)
 		http.Redirect(w, r, "/categories?error=Ошибка создания категории", http.StatusSeeOther)
 		return
 	}
+	audit(h.repo, h.log, r, models.AuditCategoryCreate, "category", id, nil, map[string]string{"name": name})
 	http.Redirect(w, r, "/categories?success=Категория создана", http.StatusSeeOther)
 	return
 }
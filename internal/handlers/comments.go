@@ -1,14 +1,15 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"forum/internal/automod"
 	"forum/internal/db"
 	"forum/internal/models"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
 	"unicode/utf8"
 )
 
@@ -16,10 +17,11 @@ type CommentHandler struct {
 	repo        *db.Repository
 	log         *log.Logger
 	projectRoot string
+	automod     *automod.Engine
 }
 
-func NewCommentHandler(repo *db.Repository, log *log.Logger, projectRoot string) *CommentHandler {
-	return &CommentHandler{repo: repo, log: log, projectRoot: projectRoot}
+func NewCommentHandler(repo *db.Repository, log *log.Logger, projectRoot string, engine *automod.Engine) *CommentHandler {
+	return &CommentHandler{repo: repo, log: log, projectRoot: projectRoot, automod: engine}
 }
 
 func (h *CommentHandler) AddComment(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +42,15 @@ func (h *CommentHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !emailVerified(r) {
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Подтвердите email, чтобы комментировать. Письмо можно отправить повторно в профиле", http.StatusSeeOther)
+		return
+	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
+
 	content := strings.TrimSpace(r.FormValue("content"))
 	if content == "" {
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Комментарий не может быть пустым", http.StatusSeeOther)
@@ -52,15 +63,21 @@ func (h *CommentHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check post existence
-	exists, err := h.repo.PostExists(postID)
+	// Скрытые и ожидающие модерации посты видны только автору и модераторам
+	post, err := viewablePost(h.repo, r, postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, "/posts?error=Пост не существует", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		h.log.Printf("Ошибка проверки поста: %v", err)
 		http.Redirect(w, r, "/posts?error=Ошибка проверки поста", http.StatusSeeOther)
 		return
 	}
-	if !exists {
-		http.Redirect(w, r, "/posts?error=Пост не существует", http.StatusSeeOther)
+	// В закрытом обсуждении могут писать только модераторы
+	role, _ := r.Context().Value("role").(string)
+	if post.Locked && role != "admin" && role != "moderator" {
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Обсуждение закрыто, новые комментарии не принимаются", http.StatusSeeOther)
 		return
 	}
 
@@ -70,25 +87,54 @@ func (h *CommentHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 		Content: content,
 	}
 
+	// Ответ на комментарий: родитель должен принадлежать тому же посту
+	var parent *models.Comment
+	if parentIDStr := r.FormValue("parent_id"); parentIDStr != "" {
+		parentID, err := strconv.Atoi(parentIDStr)
+		if err != nil || parentID <= 0 {
+			http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Неверный ID комментария", http.StatusSeeOther)
+			return
+		}
+		parent, err = viewableComment(h.repo, r, parentID)
+		if err != nil || parent.PostID != postID {
+			http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Комментарий не найден", http.StatusSeeOther)
+			return
+		}
+		comment.ParentID = &parent.ID
+	}
+
+	verdict := screenContent(h.automod, h.log, role, automod.Content{Type: models.RevisionComment, AuthorID: userID, Text: content})
+	if verdict.Rejected() {
+		applyVerdict(h.automod, h.log, verdict, 0)
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Комментарий отклонён автоматической модерацией", http.StatusSeeOther)
+		return
+	}
+	comment.Visibility = verdict.Visibility()
+
 	if err := h.repo.CreateComment(comment); err != nil {
 		h.log.Printf("Ошибка создания комментария: %v", err)
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Ошибка создания комментария", http.StatusSeeOther)
 		return
 	}
-
-	// Notify post author
-	post, err := h.repo.GetPostByID(postID)
-	if err == nil && post.UserID != userID {
-		fromUserID := userID
-		h.repo.CreateNotification(post.UserID, "comment", &fromUserID, &postID, nil)
+	applyVerdict(h.automod, h.log, verdict, comment.ID)
+	if verdict.Queued() {
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&success=Комментарий отправлен на проверку модератору", http.StatusSeeOther)
+		return
 	}
 
+	notified := notifyComment(h.repo, comment, parent)
+	notifyMentions(h.repo, userID, &comment.PostID, &comment.ID, "", comment.Content, notified...)
+
 	h.log.Printf("Комментарий добавлен к посту %d пользователем %d", postID, userID)
 	http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&success=Комментарий успешно добавлен", http.StatusSeeOther)
 }
 
 // DeleteComment deletes a comment (only author, moderator, or admin)
 func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
 		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
@@ -111,11 +157,12 @@ func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Нет прав на удаление", http.StatusForbidden)
 		return
 	}
-	if err := h.repo.DeleteComment(commentID); err != nil {
+	if err := h.repo.DeleteComment(commentID, userID); err != nil {
 		h.log.Printf("Ошибка удаления комментария: %v", err)
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(comment.PostID)+"&error=Ошибка удаления комментария", http.StatusSeeOther)
 		return
 	}
+	audit(h.repo, h.log, r, models.AuditCommentDelete, models.RevisionComment, commentID, commentSnapshot(comment), nil)
 	http.Redirect(w, r, "/post?id="+strconv.Itoa(comment.PostID)+"&success=Комментарий удалён", http.StatusSeeOther)
 }
 
@@ -126,6 +173,10 @@ func (h *CommentHandler) EditComment(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
 		return
 	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
 	role, _ := r.Context().Value("role").(string)
 
 	commentID, err := strconv.Atoi(r.URL.Query().Get("id"))
@@ -155,24 +206,66 @@ func (h *CommentHandler) EditComment(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/edit-comment?id="+strconv.Itoa(commentID)+"&error=Комментарий должен быть от 2 до 1000 символов", http.StatusSeeOther)
 			return
 		}
-		if err := h.repo.UpdateComment(commentID, newContent); err != nil {
+		verdict := screenContent(h.automod, h.log, role, automod.Content{Type: models.RevisionComment, ID: commentID, AuthorID: comment.UserID, Text: newContent})
+		if verdict.Rejected() {
+			applyVerdict(h.automod, h.log, verdict, 0)
+			http.Redirect(w, r, "/edit-comment?id="+strconv.Itoa(commentID)+"&error=Изменения отклонены автоматической модерацией", http.StatusSeeOther)
+			return
+		}
+		if err := h.repo.UpdateComment(commentID, userID, newContent); err != nil {
 			h.log.Printf("Ошибка обновления комментария: %v", err)
 			http.Redirect(w, r, "/edit-comment?id="+strconv.Itoa(commentID)+"&error=Ошибка обновления", http.StatusSeeOther)
 			return
 		}
+		updated := *comment
+		updated.Content = newContent
+		audit(h.repo, h.log, r, models.AuditCommentEdit, models.RevisionComment, commentID, commentSnapshot(comment), commentSnapshot(&updated))
+		applyVerdict(h.automod, h.log, verdict, commentID)
+		if verdict.Held() {
+			http.Redirect(w, r, "/post?id="+strconv.Itoa(comment.PostID)+"&success=Комментарий обновлён и отправлен на проверку модератору", http.StatusSeeOther)
+			return
+		}
+		// Mentions in a queued comment are sent once it is approved
+		if comment.Visibility != models.VisibilityPending {
+			notifyMentions(h.repo, userID, &comment.PostID, &comment.ID, comment.Content, newContent)
+		}
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(comment.PostID)+"&success=Комментарий обновлён", http.StatusSeeOther)
 		return
 	}
 
-	tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "edit_comment.html"))
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "edit_comment.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 	data := map[string]interface{}{
-		"Comment": comment,
-		"Error":   r.URL.Query().Get("error"),
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Comment":   comment,
+		"Error":     r.URL.Query().Get("error"),
 	}
 	tmpl.Execute(w, data)
 }
+
+// notifyComment notifies the author of the parent comment about a reply and
+// the post author about a new comment (once, if they are not already notified
+// about the reply). It returns the IDs of the notified users.
+func notifyComment(repo *db.Repository, comment *models.Comment, parent *models.Comment) []int {
+	fromUserID := comment.UserID
+	postID := comment.PostID
+	commentID := comment.ID
+	var notified []int
+
+	if parent != nil && parent.UserID != comment.UserID {
+		repo.CreateNotification(parent.UserID, "reply", &fromUserID, &postID, &commentID)
+		notified = append(notified, parent.UserID)
+	}
+
+	post, err := repo.GetPostByID(postID)
+	if err == nil && post.UserID != comment.UserID && (parent == nil || parent.UserID != post.UserID) {
+		repo.CreateNotification(post.UserID, "comment", &fromUserID, &postID, nil)
+		notified = append(notified, post.UserID)
+	}
+	return notified
+}
@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"forum/internal/db"
+	"forum/internal/markdown"
+	"forum/internal/models"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"path/filepath"
+	"unicode/utf8"
 )
 
+// errUnknownTarget is returned for a content type other than post or comment.
+var errUnknownTarget = errors.New("unknown target type")
+
 func handleError(w http.ResponseWriter, log *log.Logger, err error, message string, status int) {
 	log.Printf("%s: %v", message, err)
 	http.Error(w, message, status)
@@ -26,3 +36,167 @@ func renderError(w http.ResponseWriter, code int, title, message, projectRoot st
 	}
 	tmpl.Execute(w, data)
 }
+
+// announcementBanner is what the shared banner at the top of every page
+// shows: the announcements the viewer has not dismissed and, for signed-in
+// viewers, what the close buttons need to dismiss them.
+type announcementBanner struct {
+	Announcements []*models.Post
+	Dismissible   bool
+	CSRFToken     string
+}
+
+// parsePage parses a page template from static/ together with the shared
+// partials in static/partials. The announcement banner is loaded for the
+// viewer when the page renders it, so handlers need not pass it in data.
+func parsePage(repo *db.Repository, logger *log.Logger, r *http.Request, projectRoot, name string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"announcements": func() *announcementBanner {
+			userID := viewerID(repo, r)
+			return &announcementBanner{
+				Announcements: announcements(repo, logger, userID),
+				Dismissible:   userID != 0,
+				CSRFToken:     csrfToken(r),
+			}
+		},
+	}
+	return template.New(name).Funcs(funcs).ParseFiles(
+		filepath.Join(projectRoot, "static", name),
+		filepath.Join(projectRoot, "static", "partials", "announcements.html"),
+	)
+}
+
+// viewerID returns the ID of the signed-in user, or 0 for a guest. Public
+// pages are not behind AuthMiddleware, so the session cookie is checked
+// there.
+func viewerID(repo *db.Repository, r *http.Request) int {
+	if userID, ok := r.Context().Value("userID").(int); ok {
+		return userID
+	}
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		return 0
+	}
+	session, err := repo.GetSession(cookie.Value)
+	if err != nil {
+		return 0
+	}
+	return session.UserID
+}
+
+// CSRFRejected answers requests that fail the CSRF check.
+func CSRFRejected(projectRoot string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Срок действия формы истёк или запрос отправлен с другого сайта. Обновите страницу и попробуйте снова", projectRoot)
+	})
+}
+
+// csrfToken returns the token that forms and fetch calls on the page must
+// send back.
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value("csrfToken").(string)
+	return token
+}
+
+// cspNonce returns the nonce that inline scripts on the page must carry to
+// pass the Content-Security-Policy.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value("cspNonce").(string)
+	return nonce
+}
+
+// clientIP returns the address of the remote end of the connection.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// emailVerified reports whether the authenticated user has confirmed their
+// email address. Unverified users may read but not post, comment or vote.
+func emailVerified(r *http.Request) bool {
+	verified, _ := r.Context().Value("emailVerified").(bool)
+	return verified
+}
+
+// activeBan returns the ban in force for the authenticated user, or nil.
+// Banned users may read but not post, comment, vote or report.
+func activeBan(r *http.Request) *models.Ban {
+	ban, _ := r.Context().Value("ban").(*models.Ban)
+	return ban
+}
+
+// renderedContents looks up cached HTML for several posts or comments.
+// Lookup errors are logged and yield an empty cache; contentHTML then
+// renders on the fly.
+func renderedContents(repo *db.Repository, log *log.Logger, targetType string, ids ...int) map[int]string {
+	cache, err := repo.GetRenderedContent(targetType, ids...)
+	if err != nil {
+		log.Printf("Error loading rendered content: %v", err)
+		return map[int]string{}
+	}
+	return cache
+}
+
+// contentHTML returns the sanitized HTML of a post or comment body.
+// Uncached content is rendered without resolving @mentions.
+func contentHTML(cache map[int]string, id int, content string) template.HTML {
+	html, ok := cache[id]
+	if !ok {
+		html = markdown.Render(content, func(string) bool { return false })
+	}
+	return template.HTML(html)
+}
+
+// canViewHidden reports whether content that is hidden by a moderator or
+// waiting in the moderation queue is shown to the given viewer: only its
+// author and the moderators see it.
+func canViewHidden(viewerID int, role string, authorID int) bool {
+	return (viewerID != 0 && viewerID == authorID) || role == "moderator" || role == "admin"
+}
+
+// viewablePost loads a post as the current user may see it. A hidden or
+// pending post is reported as sql.ErrNoRows to everyone except its author
+// and the moderators, so it cannot be commented on, voted on or reported
+// by id.
+func viewablePost(repo *db.Repository, r *http.Request, postID int) (*models.Post, error) {
+	post, err := repo.GetPostByID(postID)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	role, _ := r.Context().Value("role").(string)
+	if post.Visibility != models.VisibilityVisible && !canViewHidden(userID, role, post.UserID) {
+		return nil, sql.ErrNoRows
+	}
+	return post, nil
+}
+
+// viewableComment loads a comment as the current user may see it: both the
+// comment and its post must be visible to them, otherwise it is reported as
+// sql.ErrNoRows.
+func viewableComment(repo *db.Repository, r *http.Request, commentID int) (*models.Comment, error) {
+	comment, err := repo.GetCommentByID(commentID)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	role, _ := r.Context().Value("role").(string)
+	if comment.Visibility != models.VisibilityVisible && !canViewHidden(userID, role, comment.UserID) {
+		return nil, sql.ErrNoRows
+	}
+	if _, err := viewablePost(repo, r, comment.PostID); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
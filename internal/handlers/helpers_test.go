@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"forum/internal/automod"
+	"forum/internal/config"
+	"forum/internal/db"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+func setupTestRepo(t *testing.T) *db.Repository {
+	t.Helper()
+	repo, err := db.NewRepository(&config.Config{DBPath: ":memory:"})
+	if err != nil {
+		t.Fatalf("Ошибка создания репозитория: %v", err)
+	}
+	if err := repo.RunMigrations(); err != nil {
+		t.Fatalf("Ошибка миграций: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// createTestUser creates a user with a verified email and the given role
+// and returns it with an API token that has every scope.
+func createTestUser(t *testing.T, repo *db.Repository, name, role string) (*models.User, string) {
+	t.Helper()
+	user := &models.User{Email: name + "@example.com", Username: name}
+	if err := repo.CreateUser(user, "password123"); err != nil {
+		t.Fatalf("Ошибка создания пользователя: %v", err)
+	}
+	secret, err := repo.CreateEmailVerification(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.VerifyEmail(secret); err != nil {
+		t.Fatal(err)
+	}
+	if role != "user" {
+		if err := repo.ChangeUserRole(user.ID, role, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, _, err := repo.CreateAPIToken(user.ID, "test", []string{models.ScopeRead, models.ScopeWrite, models.ScopeModerate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// newTestAPI serves the JSON API routes the tests use behind the same
+// authentication middleware as the server.
+func newTestAPI(repo *db.Repository) http.Handler {
+	h := NewAPIHandler(repo, testLogger, automod.New(repo, automod.Premoderation{}))
+	auth := middleware.OptionalAuthMiddleware(repo, testLogger, middleware.Policy{})
+	mux := http.NewServeMux()
+	for pattern, handler := range map[string]http.HandlerFunc{
+		"GET /api/v1/posts/{id}":           h.GetPost,
+		"POST /api/v1/posts/{id}/votes":    h.VotePost,
+		"GET /api/v1/posts/{id}/comments":  h.ListComments,
+		"POST /api/v1/posts/{id}/comments": h.CreateComment,
+		"GET /api/v1/comments/{id}":        h.GetComment,
+		"POST /api/v1/comments/{id}/votes": h.VoteComment,
+		"POST /api/v1/reports":             h.CreateReport,
+	} {
+		mux.Handle(pattern, auth(handler))
+	}
+	return mux
+}
+
+// apiCall sends a JSON API request with the bearer token and returns the
+// response status.
+func apiCall(t *testing.T, api http.Handler, token, method, path string, body interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	return rec.Code
+}
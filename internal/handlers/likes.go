@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"forum/internal/db"
 	"forum/internal/models"
 	"log"
@@ -30,6 +32,14 @@ func (h *LikeHandler) Like(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Authentication required", http.StatusUnauthorized)
 		return
 	}
+	if !emailVerified(r) {
+		http.Error(w, "Confirm your email address to vote", http.StatusForbidden)
+		return
+	}
+	if activeBan(r) != nil {
+		http.Error(w, "Your account is suspended", http.StatusForbidden)
+		return
+	}
 
 	postIDStr := r.URL.Query().Get("post_id")
 	commentIDStr := r.URL.Query().Get("comment_id")
@@ -64,36 +74,34 @@ func (h *LikeHandler) Like(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check existence of post or comment
-	if like.PostID != nil {
-		exists, err := h.repo.PostExists(*like.PostID)
-		if err != nil {
-			h.log.Printf("Error checking post: %v", err)
-			http.Error(w, "Error checking post", http.StatusInternalServerError)
-			return
-		}
-		if !exists {
-			http.Error(w, "Post does not exist", http.StatusNotFound)
-			return
-		}
-	} else if like.CommentID != nil {
-		comment, err := h.repo.GetCommentByID(*like.CommentID)
-		if err != nil {
-			h.log.Printf("Error checking comment: %v", err)
+	// The post or comment must exist and be visible to the voter, and so
+	// must the post of a comment
+	if like.CommentID != nil {
+		comment, err := viewableComment(h.repo, r, *like.CommentID)
+		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "Comment does not exist", http.StatusNotFound)
 			return
 		}
-		// Additional check for post existence to which the comment belongs
-		exists, err := h.repo.PostExists(comment.PostID)
 		if err != nil {
-			h.log.Printf("Error checking post of comment: %v", err)
-			http.Error(w, "Error checking post", http.StatusInternalServerError)
-			return
-		}
-		if !exists {
-			http.Error(w, "Comment post does not exist", http.StatusNotFound)
+			h.log.Printf("Error checking comment: %v", err)
+			http.Error(w, "Error checking comment", http.StatusInternalServerError)
 			return
 		}
+		postID = comment.PostID
+	}
+	post, err := viewablePost(h.repo, r, postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Post does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Error checking post: %v", err)
+		http.Error(w, "Error checking post", http.StatusInternalServerError)
+		return
+	}
+	if post.Locked {
+		http.Error(w, "This post is locked", http.StatusForbidden)
+		return
 	}
 
 	if err := h.repo.CreateLike(like); err != nil {
@@ -102,28 +110,7 @@ func (h *LikeHandler) Like(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Notifications
-	if like.PostID != nil {
-		post, err := h.repo.GetPostByID(*like.PostID)
-		if err == nil && post.UserID != userID {
-			nType := "like"
-			if !like.IsLike {
-				nType = "dislike"
-			}
-			fromUserID := userID
-			h.repo.CreateNotification(post.UserID, nType, &fromUserID, like.PostID, nil)
-		}
-	} else if like.CommentID != nil {
-		comment, err := h.repo.GetCommentByID(*like.CommentID)
-		if err == nil && comment.UserID != userID {
-			nType := "like"
-			if !like.IsLike {
-				nType = "dislike"
-			}
-			fromUserID := userID
-			h.repo.CreateNotification(comment.UserID, nType, &fromUserID, nil, like.CommentID)
-		}
-	}
+	notifyLike(h.repo, like)
 
 	var likes, dislikes int
 	if like.PostID != nil {
@@ -138,3 +125,23 @@ func (h *LikeHandler) Like(w http.ResponseWriter, r *http.Request) {
 		"dislikes": dislikes,
 	})
 }
+
+// notifyLike notifies the author of a liked or disliked post or comment.
+func notifyLike(repo *db.Repository, like *models.Like) {
+	nType := "like"
+	if !like.IsLike {
+		nType = "dislike"
+	}
+	fromUserID := like.UserID
+	if like.PostID != nil {
+		post, err := repo.GetPostByID(*like.PostID)
+		if err == nil && post.UserID != like.UserID {
+			repo.CreateNotification(post.UserID, nType, &fromUserID, like.PostID, nil)
+		}
+	} else if like.CommentID != nil {
+		comment, err := repo.GetCommentByID(*like.CommentID)
+		if err == nil && comment.UserID != like.UserID {
+			repo.CreateNotification(comment.UserID, nType, &fromUserID, nil, like.CommentID)
+		}
+	}
+}
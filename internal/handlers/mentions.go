@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"forum/internal/db"
+	"forum/internal/markdown"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxMentionNotifications caps how many users one post or comment can notify,
+// so a wall of @mentions can't be used to spam.
+const maxMentionNotifications = 10
+
+// notifyMentions sends a "mention" notification to every existing user
+// mentioned in newContent but not in oldContent (empty for new content).
+// The author and the users in skip, who were already notified about the
+// same content in another way, are left out.
+func notifyMentions(repo *db.Repository, fromUserID int, postID, commentID *int, oldContent, newContent string, skip ...int) {
+	already := make(map[string]bool)
+	for _, name := range markdown.Mentions(oldContent) {
+		already[name] = true
+	}
+	skipped := map[int]bool{fromUserID: true}
+	for _, id := range skip {
+		skipped[id] = true
+	}
+
+	sent := 0
+	for _, name := range markdown.Mentions(newContent) {
+		if sent == maxMentionNotifications {
+			return
+		}
+		if already[name] {
+			continue
+		}
+		user, err := repo.GetUserByUsername(name)
+		if err != nil || skipped[user.ID] {
+			continue
+		}
+		skipped[user.ID] = true
+		repo.CreateNotification(user.ID, "mention", &fromUserID, postID, commentID)
+		sent++
+	}
+}
+
+// apiUsernameSuggestions is the maximum number of autocomplete results.
+const apiUsernameSuggestions = 10
+
+// AutocompleteUsers handles GET /api/v1/users/autocomplete?q=prefix for the
+// @mention picker in the editor.
+func (h *APIHandler) AutocompleteUsers(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@"))
+	limit := apiUsernameSuggestions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+			return
+		}
+		limit = min(n, apiUsernameSuggestions)
+	}
+
+	type suggestion struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	}
+	data := []suggestion{}
+	if prefix != "" {
+		users, err := h.repo.SearchUsernames(prefix, limit)
+		if err != nil {
+			h.internalError(w, "searching usernames", err)
+			return
+		}
+		for _, u := range users {
+			data = append(data, suggestion{ID: u.ID, Username: u.Username})
+		}
+	}
+	writeJSON(w, http.StatusOK, apiPage{Data: data})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"forum/internal/automod"
+	"forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"log"
+	"strconv"
+)
+
+// Notifications about moderation are best effort: the action has already
+// taken effect, so failures are only logged.
+
+// notifyBan tells the user about a new or lifted ban, in the forum and by
+// email.
+func notifyBan(repo *db.Repository, mailer *mail.Mailer, logger *log.Logger, user *models.User, ban *models.Ban, lifted bool, moderatorID int) {
+	notifType, title, text := "ban", "Your account has been suspended", "A moderator has suspended your account: "+ban.Reason+". "
+	if lifted {
+		notifType, title, text = "unban", "Your suspension has been lifted", "A moderator has lifted the suspension of your account. You can post, comment and vote again."
+	} else if ban.Permanent() {
+		text += "The suspension is permanent."
+	} else {
+		text += "It ends on " + ban.ExpiresAt.UTC().Format("2 January 2006 at 15:04 UTC") + "."
+	}
+	if err := repo.CreateNotification(user.ID, notifType, &moderatorID, nil, nil); err != nil {
+		logger.Printf("Ошибка создания уведомления о бане: %v", err)
+	}
+	link := ""
+	if !lifted {
+		link = "/banned"
+	}
+	if err := mailer.Send(user.Email, "notification", map[string]interface{}{
+		"Username": user.Username,
+		"Title":    title,
+		"Text":     text,
+		"Link":     link,
+	}); err != nil {
+		logger.Printf("Ошибка отправки письма о бане: %v", err)
+	}
+}
+
+// notifyWarning sends a moderator's warning about a post or comment to its
+// author.
+func notifyWarning(repo *db.Repository, mailer *mail.Mailer, logger *log.Logger, user *models.User, postID, commentID *int, note string, moderatorID int) {
+	if err := repo.CreateNotification(user.ID, "warning", &moderatorID, postID, commentID); err != nil {
+		logger.Printf("Ошибка создания предупреждения: %v", err)
+	}
+	link := ""
+	if postID != nil {
+		link = "/post?id=" + strconv.Itoa(*postID)
+	}
+	if err := mailer.Send(user.Email, "notification", map[string]interface{}{
+		"Username": user.Username,
+		"Title":    "A warning from the moderators",
+		"Text":     "A moderator has reviewed a report on your content and sent you a warning: " + note,
+		"Link":     link,
+	}); err != nil {
+		logger.Printf("Ошибка отправки предупреждения: %v", err)
+	}
+}
+
+// notifyReporter tells the reporter that their report was resolved. The
+// notification type is "report_actioned" or "report_rejected". Deleted
+// content is not linked.
+func notifyReporter(repo *db.Repository, logger *log.Logger, rep *models.Report, moderatorID int) {
+	postID, commentID := rep.PostID, rep.CommentID
+	if rep.Action == models.ReportActionDelete {
+		postID, commentID = nil, nil
+	}
+	if err := repo.CreateNotification(rep.ReporterID, "report_"+rep.Status, &moderatorID, postID, commentID); err != nil {
+		logger.Printf("Ошибка уведомления автора жалобы: %v", err)
+	}
+}
+
+// screenContent runs the automod rules on content a user with the given
+// role is about to save. Moderators and admins are trusted and skip them.
+// If the rules cannot be checked the content is held for a moderator.
+func screenContent(engine *automod.Engine, logger *log.Logger, role string, c automod.Content) *automod.Verdict {
+	if role == "moderator" || role == "admin" {
+		return &automod.Verdict{Content: c}
+	}
+	v, err := engine.Check(c)
+	if err != nil {
+		logger.Printf("Ошибка автомодерации, %s пользователя %d задержан: %v", c.Type, c.AuthorID, err)
+	}
+	return v
+}
+
+// applyVerdict carries out the verdict for content saved under id, or
+// rejected if id is 0.
+func applyVerdict(engine *automod.Engine, logger *log.Logger, v *automod.Verdict, id int) {
+	if len(v.Matches) == 0 && !v.Unchecked {
+		return
+	}
+	logger.Printf("Автомодерация: %s %d пользователя %d, правила %s, действие %s", v.Content.Type, id, v.Content.AuthorID, v.RuleNames(), v.Action())
+	if err := engine.Apply(v, id); err != nil {
+		logger.Printf("Ошибка применения автомодерации: %v", err)
+	}
+}
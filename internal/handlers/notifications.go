@@ -1,20 +1,27 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"forum/internal/db"
-	"html/template"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type NotificationsHandler struct {
 	repo        *db.Repository
 	log         *log.Logger
 	projectRoot string
+	heartbeat   time.Duration
 }
 
 func NewNotificationsHandler(repo *db.Repository, log *log.Logger, projectRoot string) *NotificationsHandler {
-	return &NotificationsHandler{repo: repo, log: log, projectRoot: projectRoot}
+	return &NotificationsHandler{repo: repo, log: log, projectRoot: projectRoot, heartbeat: sseHeartbeat}
 }
 
 // ListNotifications отображает уведомления пользователя
@@ -37,15 +44,174 @@ func (h *NotificationsHandler) ListNotifications(w http.ResponseWriter, r *http.
 		return
 	}
 
-	tmpl, err := template.ParseFiles("static/notifications.html")
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "notifications.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", h.projectRoot, "Внутренняя ошибка сервера")
 		return
 	}
 
+	unread := 0
+	for _, n := range notifs {
+		if !n.IsRead {
+			unread++
+		}
+	}
 	data := map[string]interface{}{
+		"CSRFToken":     csrfToken(r),
+		"CSPNonce":      cspNonce(r),
 		"Notifications": notifs,
+		"Unread":        unread,
 	}
 	tmpl.Execute(w, data)
 }
+
+// sseHeartbeat keeps idle SSE connections open through proxies. The session
+// is checked again on every heartbeat.
+const sseHeartbeat = 25 * time.Second
+
+// Stream отправляет новые уведомления и число непрочитанных через Server-Sent Events.
+// Поток закрывается, как только сессия или API-токен перестают действовать
+// или пользователя блокируют
+func (h *NotificationsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+	if activeBan(r) != nil {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	events, cancel := h.repo.NotificationHub().Subscribe(userID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// Начальное состояние: текущее число непрочитанных
+	unread, err := h.repo.CountUnreadNotifications(userID)
+	if err != nil {
+		h.log.Printf("Ошибка подсчёта уведомлений: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	writeSSE(w, db.EventUnreadCount, unread)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !h.streamAllowed(r, userID) {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-events:
+			data := ev.Data
+			if ne, ok := data.(db.NotificationEvent); ok {
+				data = map[string]interface{}{
+					"notification": toAPINotification(ne.Notification),
+					"unread_count": ne.UnreadCount,
+				}
+			}
+			writeSSE(w, ev.Name, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// streamAllowed reports whether the session or API token the stream was
+// opened with still belongs to the user and the user is not banned.
+func (h *NotificationsHandler) streamAllowed(r *http.Request, userID int) bool {
+	if _, secret, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok {
+		token, err := h.repo.AuthenticateAPIToken(strings.TrimSpace(secret))
+		if err != nil || token.UserID != userID {
+			return false
+		}
+	} else {
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			return false
+		}
+		session, err := h.repo.GetSession(cookie.Value)
+		if err != nil || session.UserID != userID {
+			return false
+		}
+	}
+	ban, err := h.repo.GetActiveBan(userID)
+	if err != nil {
+		h.log.Printf("Ошибка проверки блокировки пользователя %d: %v", userID, err)
+	}
+	return err == nil && ban == nil
+}
+
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
+
+// MarkRead отмечает одно уведомление прочитанным
+func (h *NotificationsHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	notifID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "400 Bad Request", "Неверный ID уведомления", h.projectRoot)
+		return
+	}
+	if err := h.repo.MarkNotificationRead(userID, notifID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			renderError(w, http.StatusNotFound, "404 Not Found", "Уведомление не найдено", h.projectRoot)
+			return
+		}
+		h.log.Printf("Ошибка обновления уведомления: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// MarkAllRead отмечает все уведомления пользователя прочитанными
+func (h *NotificationsHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.MarkAllNotificationsRead(userID); err != nil {
+		h.log.Printf("Ошибка обновления уведомлений: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
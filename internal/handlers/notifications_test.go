@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bufio"
+	"forum/internal/db"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The notification stream must end once the credentials it was opened with
+// stop working or the user is banned.
+func TestStreamClosesWhenAccessEnds(t *testing.T) {
+	repo := setupTestRepo(t)
+	h := NewNotificationsHandler(repo, testLogger, "")
+	h.heartbeat = 20 * time.Millisecond
+	server := httptest.NewServer(middleware.AuthMiddleware(repo, testLogger, middleware.Policy{})(http.HandlerFunc(h.Stream)))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		username string
+		bearer   bool
+		revoke   func(user *models.User, session string)
+	}{
+		{"logout", "alice", false, func(_ *models.User, session string) { repo.DeleteSession(session) }},
+		{"sessions revoked", "bob", false, func(user *models.User, _ string) { repo.DeleteAllUserSessions(user.ID) }},
+		{"API token revoked", "carol", true, func(user *models.User, _ string) { repo.DeleteAllUserAPITokens(user.ID) }},
+		{"ban", "dave", false, func(user *models.User, _ string) {
+			repo.CreateBan(&models.Ban{UserID: user.ID, Reason: "спам", IssuedBy: user.ID})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, token := createTestUser(t, repo, tt.username, "user")
+			session := &models.Session{SessionID: "session-" + user.Username, UserID: user.ID, Expires: time.Now().Add(time.Hour)}
+			if err := repo.CreateSession(session); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				req.AddCookie(&http.Cookie{Name: "session_id", Value: session.SessionID})
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body := bufio.NewReader(resp.Body)
+			if line, _ := body.ReadString('\n'); line != "event: "+db.EventUnreadCount+"\n" {
+				t.Fatalf("Поток не открыт: %d %q", resp.StatusCode, line)
+			}
+
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				for {
+					if _, err := body.ReadString('\n'); err != nil {
+						return
+					}
+				}
+			}()
+			select {
+			case <-closed:
+				t.Fatal("Поток закрыт без причины")
+			case <-time.After(100 * time.Millisecond):
+			}
+			tt.revoke(user, session.SessionID)
+			select {
+			case <-closed:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Поток не закрыт")
+			}
+		})
+	}
+}
+
+func TestStreamRefusesBannedUsers(t *testing.T) {
+	repo := setupTestRepo(t)
+	h := NewNotificationsHandler(repo, testLogger, "")
+	user, token := createTestUser(t, repo, "bob", "user")
+	repo.CreateBan(&models.Ban{UserID: user.ID, Reason: "спам", IssuedBy: user.ID})
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	middleware.AuthMiddleware(repo, testLogger, middleware.Policy{})(http.HandlerFunc(h.Stream)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "event:") {
+		t.Errorf("Поток открыт заблокированному пользователю: %d", rec.Code)
+	}
+}
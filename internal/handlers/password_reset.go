@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"forum/internal/db"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	resetTokenTTL = time.Hour
+	// Не больше писем на один аккаунт и запросов с одного IP за час
+	resetMaxPerAccount = 3
+	resetMaxPerIP      = 10
+)
+
+// resetRequestedMessage is shown after every accepted request so the page
+// does not reveal whether an account with the email exists.
+const resetRequestedMessage = "Если аккаунт с таким email существует, мы отправили на него ссылку для сброса пароля"
+
+// ForgotPassword shows the "forgot password" form and mails a reset link.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.renderPage(w, r, "forgot_password.html", map[string]interface{}{
+			"Error":   r.URL.Query().Get("error"),
+			"Success": r.URL.Query().Get("success"),
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := clientIP(r)
+	since := time.Now().Add(-time.Hour)
+	count, err := h.repo.CountPasswordResetRequestsByIP(ip, since)
+	if err != nil {
+		h.log.Printf("Ошибка проверки лимита сброса пароля: %v", err)
+		http.Redirect(w, r, "/forgot-password?error=Ошибка сервера, попробуйте позже", http.StatusSeeOther)
+		return
+	}
+	if count >= resetMaxPerIP {
+		http.Redirect(w, r, "/forgot-password?error=Слишком много запросов. Попробуйте через час", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.RecordPasswordResetRequest(ip); err != nil {
+		h.log.Printf("Ошибка записи запроса сброса пароля: %v", err)
+	}
+
+	email := strings.TrimSpace(strings.ToLower(r.FormValue("email")))
+	if user, err := h.repo.GetUserByEmail(email); err == nil {
+		// Сверх лимита письмо молча не отправляется, ответ тот же
+		sent, err := h.repo.CountPasswordResetsSince(user.ID, since)
+		if err != nil {
+			h.log.Printf("Ошибка проверки лимита сброса пароля: %v", err)
+		} else if sent < resetMaxPerAccount {
+			token, err := h.repo.CreatePasswordReset(user.ID, resetTokenTTL)
+			if err == nil {
+				err = h.mailer.Send(user.Email, "password_reset", map[string]interface{}{
+					"Username": user.Username,
+					"Token":    token,
+					"ValidFor": "1 hour",
+				})
+			}
+			if err != nil {
+				h.log.Printf("Ошибка отправки письма для сброса пароля: %v", err)
+			}
+		}
+	}
+
+	http.Redirect(w, r, "/forgot-password?success="+resetRequestedMessage, http.StatusSeeOther)
+}
+
+// ResetPassword sets a new password using a token from the reset mail and
+// signs the user out of all sessions and revokes their API tokens.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if r.Method == http.MethodGet {
+		data := map[string]interface{}{
+			"Token": token,
+			"Error": r.URL.Query().Get("error"),
+		}
+		if err := h.repo.CheckPasswordReset(token); err != nil {
+			if !errors.Is(err, db.ErrInvalidResetToken) {
+				h.log.Printf("Ошибка проверки токена сброса пароля: %v", err)
+			}
+			data["Invalid"] = true
+		}
+		h.renderPage(w, r, "reset_password.html", data)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	retry := "/reset-password?token=" + url.QueryEscape(token) + "&error="
+	password := strings.TrimSpace(r.FormValue("password"))
+	if runeLen := utf8.RuneCountInString(password); runeLen < 6 || runeLen > 50 {
+		http.Redirect(w, r, retry+"Пароль должен быть от 6 до 50 символов", http.StatusSeeOther)
+		return
+	}
+	if password != strings.TrimSpace(r.FormValue("confirm_password")) {
+		http.Redirect(w, r, retry+"Пароли не совпадают", http.StatusSeeOther)
+		return
+	}
+
+	userID, err := h.repo.ResetPassword(token, password)
+	if errors.Is(err, db.ErrInvalidResetToken) {
+		http.Redirect(w, r, "/forgot-password?error=Ссылка для сброса пароля устарела или уже использована", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Ошибка сброса пароля: %v", err)
+		http.Redirect(w, r, retry+"Ошибка сброса пароля", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteAllUserSessions(userID); err != nil {
+		h.log.Printf("Ошибка удаления сессий после сброса пароля: %v", err)
+	}
+	if err := h.repo.DeleteAllUserAPITokens(userID); err != nil {
+		h.log.Printf("Ошибка отзыва API-токенов после сброса пароля: %v", err)
+	}
+
+	h.log.Printf("Пароль сброшен для пользователя %d", userID)
+	h.opts.Cookies.Set(w, &http.Cookie{
+		Name:   "session_id",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+	http.Redirect(w, r, "/login?success=Пароль изменён. Войдите с новым паролем", http.StatusSeeOther)
+}
+
+// renderPage executes a template from static/ with data and the CSRF token.
+func (h *AuthHandler) renderPage(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, name)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	data["CSRFToken"] = csrfToken(r)
+	data["CSPNonce"] = cspNonce(r)
+	tmpl.Execute(w, data)
+}
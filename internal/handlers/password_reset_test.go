@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordRevokesAPITokens(t *testing.T) {
+	repo := setupTestRepo(t)
+	user, token := createTestUser(t, repo, "bob", "user")
+	h := NewAuthHandler(repo, testLogger, "", nil, AuthOptions{})
+	api := newTestAPI(repo)
+
+	pid, _ := repo.CreatePost(&models.Post{UserID: user.ID, Title: "Пост", Content: "Текст поста"})
+	postPath := "/api/v1/posts/" + strconv.Itoa(int(pid))
+	if code := apiCall(t, api, token, http.MethodGet, postPath, nil); code != http.StatusOK {
+		t.Fatalf("Токен не работает до сброса: %d", code)
+	}
+
+	secret, err := repo.CreatePasswordReset(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"token": {secret}, "password": {"newpassword"}, "confirm_password": {"newpassword"}}
+	req := httptest.NewRequest(http.MethodPost, "/reset-password", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ResetPassword(rec, req)
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/login?success=") {
+		t.Fatalf("Сброс пароля не удался: %d %s", rec.Code, loc)
+	}
+
+	if code := apiCall(t, api, token, http.MethodGet, postPath, nil); code != http.StatusUnauthorized {
+		t.Errorf("Токен после сброса пароля: статус %d, ожидался 401", code)
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"forum/internal/db"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Post flag actions accepted by SetPostFlag.
+const (
+	flagLock       = "lock"
+	flagUnlock     = "unlock"
+	flagPin        = "pin"
+	flagUnpin      = "unpin"
+	flagAnnounce   = "announce"
+	flagUnannounce = "unannounce"
+)
+
+// flagSnapshot is the audit log snapshot of a post's flags.
+func flagSnapshot(p *models.Post) map[string]interface{} {
+	return map[string]interface{}{
+		"locked":             p.Locked,
+		"pinned":             p.PinnedAt != nil,
+		"pinned_category_id": p.PinnedCategoryID,
+		"announcement":       p.AnnouncedAt != nil,
+	}
+}
+
+// SetPostFlag locks, pins or announces the post given by ?id=, or undoes
+// it, according to the "action" form field (only for moderator and admin).
+// A pin applies to every listing unless a category_id of one of the post's
+// categories is given.
+func (h *PostHandler) SetPostFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	moderatorID, _ := r.Context().Value("userID").(int)
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || postID <= 0 {
+		http.Redirect(w, r, "/posts?error=Invalid post ID", http.StatusSeeOther)
+		return
+	}
+	post, err := h.repo.GetPostByID(postID)
+	if err != nil {
+		http.Redirect(w, r, "/posts?error=Post not found", http.StatusSeeOther)
+		return
+	}
+	back := "/post?id=" + strconv.Itoa(postID)
+
+	var auditAction, success string
+	action := r.FormValue("action")
+	switch action {
+	case flagLock:
+		err = h.repo.SetPostLocked(postID, true)
+		auditAction, success = models.AuditPostLock, "Post locked"
+	case flagUnlock:
+		err = h.repo.SetPostLocked(postID, false)
+		auditAction, success = models.AuditPostUnlock, "Post unlocked"
+	case flagPin:
+		var categoryID *int
+		if s := r.FormValue("category_id"); s != "" {
+			id, convErr := strconv.Atoi(s)
+			if convErr != nil || !h.inCategory(postID, id) {
+				http.Redirect(w, r, back+"&error=The post is not in this category", http.StatusSeeOther)
+				return
+			}
+			categoryID = &id
+		}
+		err = h.repo.PinPost(postID, categoryID)
+		auditAction, success = models.AuditPostPin, "Post pinned"
+	case flagUnpin:
+		err = h.repo.UnpinPost(postID)
+		auditAction, success = models.AuditPostUnpin, "Post unpinned"
+	case flagAnnounce:
+		err = h.repo.SetPostAnnouncement(postID, true)
+		auditAction, success = models.AuditPostAnnounce, "Post announced site-wide"
+	case flagUnannounce:
+		err = h.repo.SetPostAnnouncement(postID, false)
+		auditAction, success = models.AuditPostUnannounce, "Announcement ended"
+	default:
+		http.Redirect(w, r, back+"&error=Unknown action", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Error setting %s on post %d: %v", action, postID, err)
+		http.Redirect(w, r, back+"&error=Error updating post", http.StatusSeeOther)
+		return
+	}
+	var after interface{}
+	if updated, err := h.repo.GetPostByID(postID); err == nil {
+		after = flagSnapshot(updated)
+	}
+	audit(h.repo, h.log, r, auditAction, models.RevisionPost, postID, flagSnapshot(post), after)
+	h.log.Printf("Post %d: %s by moderator %d", postID, action, moderatorID)
+	http.Redirect(w, r, back+"&success="+success, http.StatusSeeOther)
+}
+
+// inCategory reports whether the post is in the category.
+func (h *PostHandler) inCategory(postID, categoryID int) bool {
+	categories, err := h.repo.GetCategoriesByPostID(postID)
+	if err != nil {
+		return false
+	}
+	for _, c := range categories {
+		if c.ID == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// DismissAnnouncement hides the announcement given by ?id= from the current
+// user. It answers 204 for the banner's fetch call.
+func (h *PostHandler) DismissAnnouncement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+	if err := h.repo.DismissAnnouncement(userID, postID); err != nil {
+		h.log.Printf("Error dismissing announcement %d: %v", postID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// announcements returns the site-wide announcements to show to the user, or
+// to a guest if userID is 0.
+func announcements(repo *db.Repository, logger *log.Logger, userID int) []*models.Post {
+	posts, err := repo.GetAnnouncements(userID)
+	if err != nil {
+		logger.Printf("Error loading announcements: %v", err)
+	}
+	return posts
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"forum/internal/automod"
 	"forum/internal/db"
 	"forum/internal/models"
 	"html/template"
@@ -18,14 +19,17 @@ import (
 
 // PostHandler handles requests related to posts.
 type PostHandler struct {
-	repo        *db.Repository
-	log         *log.Logger
-	projectRoot string
+	repo            *db.Repository
+	log             *log.Logger
+	projectRoot     string
+	commentMaxDepth int
+	automod         *automod.Engine
 }
 
-// NewPostHandler creates a new PostHandler.
-func NewPostHandler(repo *db.Repository, log *log.Logger, projectRoot string) *PostHandler {
-	return &PostHandler{repo: repo, log: log, projectRoot: projectRoot}
+// NewPostHandler creates a new PostHandler. commentMaxDepth limits how deeply
+// replies are nested in the post view; engine screens new and edited posts.
+func NewPostHandler(repo *db.Repository, log *log.Logger, projectRoot string, commentMaxDepth int, engine *automod.Engine) *PostHandler {
+	return &PostHandler{repo: repo, log: log, projectRoot: projectRoot, commentMaxDepth: commentMaxDepth, automod: engine}
 }
 
 // Posts handles displaying the list of posts.
@@ -44,6 +48,12 @@ func (h *PostHandler) Posts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+	rendered := renderedContents(h.repo, h.log, models.RevisionPost, postIDs...)
+
 	var postViews []*PostView
 	for _, post := range posts {
 		likes, dislikes, _ := h.repo.GetLikesDislikes(post.ID)
@@ -57,11 +67,14 @@ func (h *PostHandler) Posts(w http.ResponseWriter, r *http.Request) {
 			ID:        post.ID,
 			Title:     post.Title,
 			Content:   post.Content,
+			HTML:      contentHTML(rendered, post.ID, post.Content),
 			CreatedAt: post.CreatedAt,
 			Username:  username,
 			Likes:     likes,
 			Dislikes:  dislikes,
 			Category:  category,
+			Locked:    post.Locked,
+			Pinned:    post.PinnedAt != nil && (post.PinnedCategoryID == nil || strconv.Itoa(*post.PinnedCategoryID) == categoryID),
 		})
 	}
 
@@ -80,12 +93,12 @@ func (h *PostHandler) Posts(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tmplPath := "static/index.html"
+	page := "index.html"
 	if r.URL.Path == "/posts" {
-		tmplPath = "static/posts.html"
+		page = "posts.html"
 	}
 
-	tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, tmplPath))
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, page)
 	if err != nil {
 		h.log.Printf("Error loading template: %v", err)
 		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
@@ -93,6 +106,8 @@ func (h *PostHandler) Posts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
+		"CSRFToken":       csrfToken(r),
+		"CSPNonce":        cspNonce(r),
 		"Posts":           postViews,
 		"Error":           r.URL.Query().Get("error"),
 		"Success":         r.URL.Query().Get("success"),
@@ -110,13 +125,23 @@ type PostView struct {
 	ID        int
 	UserID    int
 	Title     string
-	Content   string
+	Content   string        // markdown source
+	HTML      template.HTML // rendered and sanitized Content
 	CreatedAt interface{}
 	Username  string
 	Likes     int
 	Dislikes  int
 	ImagePath string
 	Category  *models.Category // Added Category field
+	UpdatedAt *time.Time       // nil if the post was never edited
+	Hidden    bool             // hidden by a moderator
+	Pending   bool             // waiting in the moderation queue
+	Locked    bool             // closed to new comments and votes
+	Pinned    bool             // pinned to the top of the listing shown
+	// Set on the post page only
+	PinnedCategory *models.Category // the category the post is pinned in, nil if pinned everywhere
+	Announcement   bool             // shown as a site-wide announcement
+	Categories     []*models.Category
 }
 
 // Post handles displaying a single post.
@@ -138,6 +163,22 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The route is behind OptionalAuthMiddleware, whose role already has
+	// the two-factor and ban downgrades applied
+	userID, isAuthenticated := r.Context().Value("userID").(int)
+	role, _ := r.Context().Value("role").(string)
+	currentUsername := ""
+	if isAuthenticated {
+		if user, err := h.repo.GetUserByID(userID); err == nil {
+			currentUsername = user.Username
+		}
+	}
+
+	if post.Visibility != models.VisibilityVisible && !canViewHidden(userID, role, post.UserID) {
+		renderError(w, http.StatusNotFound, "404 Not Found", "Post not found", h.projectRoot)
+		return
+	}
+
 	likes, dislikes, err := h.repo.GetLikesDislikes(post.ID)
 	if err != nil {
 		h.log.Printf("Error loading likes/dislikes: %v", err)
@@ -150,17 +191,33 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	imagePath, _ := h.repo.GetImagePathByPostID(postID)
+	renderedPost := renderedContents(h.repo, h.log, models.RevisionPost, post.ID)
 
 	postView := &PostView{
-		ID:        post.ID,
-		UserID:    post.UserID,
-		Title:     post.Title,
-		Content:   post.Content,
-		CreatedAt: post.CreatedAt,
-		Username:  username,
-		Likes:     likes,
-		Dislikes:  dislikes,
-		ImagePath: imagePath,
+		ID:           post.ID,
+		UserID:       post.UserID,
+		Title:        post.Title,
+		Content:      post.Content,
+		HTML:         contentHTML(renderedPost, post.ID, post.Content),
+		CreatedAt:    post.CreatedAt,
+		Username:     username,
+		Likes:        likes,
+		Dislikes:     dislikes,
+		ImagePath:    imagePath,
+		UpdatedAt:    post.UpdatedAt,
+		Hidden:       post.Visibility == models.VisibilityHidden,
+		Pending:      post.Visibility == models.VisibilityPending,
+		Locked:       post.Locked,
+		Pinned:       post.PinnedAt != nil,
+		Announcement: post.AnnouncedAt != nil,
+	}
+	if categories, err := h.repo.GetCategoriesByPostID(post.ID); err == nil {
+		postView.Categories = categories
+		for _, c := range categories {
+			if post.PinnedCategoryID != nil && c.ID == *post.PinnedCategoryID {
+				postView.PinnedCategory = c
+			}
+		}
 	}
 
 	comments, err := h.repo.GetCommentsByPostID(postID)
@@ -170,8 +227,28 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	commentIDs := make([]int, 0, len(comments))
+	for _, c := range comments {
+		commentIDs = append(commentIDs, c.ID)
+	}
+	renderedComments := renderedContents(h.repo, h.log, models.RevisionComment, commentIDs...)
+
 	var commentViews []*CommentView
 	for _, c := range comments {
+		// Deleted comments leave a placeholder so their replies keep their
+		// place in the thread.
+		if c.DeletedAt != nil {
+			commentViews = append(commentViews, &CommentView{
+				ID:        c.ID,
+				PostID:    c.PostID,
+				ParentID:  c.ParentID,
+				CreatedAt: c.CreatedAt,
+				Deleted:   true,
+				Redacted:  true,
+			})
+			continue
+		}
+
 		username := ""
 		user, err := h.repo.GetUserByID(c.UserID)
 		if err == nil {
@@ -180,38 +257,46 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 
 		likes, dislikes, _ := h.repo.GetCommentLikesDislikes(c.ID)
 
+		// Pending comments have not been published yet, so others do not
+		// see them at all; replies to them move up to the top level.
+		pending := c.Visibility == models.VisibilityPending
+		if pending && !canViewHidden(userID, role, c.UserID) {
+			continue
+		}
+
+		// Hidden comments keep their place in the thread so replies still
+		// make sense, but only the author and moderators see the text.
+		hidden := c.Visibility == models.VisibilityHidden
+		if hidden && !canViewHidden(userID, role, c.UserID) {
+			commentViews = append(commentViews, &CommentView{
+				ID:        c.ID,
+				PostID:    c.PostID,
+				ParentID:  c.ParentID,
+				CreatedAt: c.CreatedAt,
+				Hidden:    true,
+				Redacted:  true,
+			})
+			continue
+		}
+
 		commentViews = append(commentViews, &CommentView{
 			ID:        c.ID,
 			PostID:    c.PostID,
 			UserID:    c.UserID,
+			ParentID:  c.ParentID,
 			Username:  username,
 			Content:   c.Content,
+			HTML:      contentHTML(renderedComments, c.ID, c.Content),
 			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
 			Likes:     likes,
 			Dislikes:  dislikes,
+			Hidden:    hidden,
+			Pending:   pending,
 		})
 	}
 
-	// Manual user determination from cookie
-	cookie, err := r.Cookie("session_id")
-	isAuthenticated := false
-	currentUsername := ""
-	userID := 0
-	role := ""
-	if err == nil {
-		session, err := h.repo.GetSession(cookie.Value)
-		if err == nil {
-			user, err := h.repo.GetUserByID(session.UserID)
-			if err == nil {
-				isAuthenticated = true
-				currentUsername = user.Username
-				userID = user.ID
-				role = user.Role
-			}
-		}
-	}
-
-	tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "post.html"))
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "post.html")
 	if err != nil {
 		h.log.Printf("Error loading template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -219,14 +304,17 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
+		"CSRFToken":       csrfToken(r),
+		"CSPNonce":        cspNonce(r),
 		"Post":            postView,
-		"Comments":        commentViews,
+		"Comments":        flattenCommentTree(buildCommentTree(commentViews, h.commentMaxDepth)),
 		"Error":           r.URL.Query().Get("error"),
 		"Success":         r.URL.Query().Get("success"),
 		"IsAuthenticated": isAuthenticated,
 		"Username":        currentUsername,
 		"UserID":          userID,
 		"Role":            role,
+		"Banned":          activeBan(r) != nil,
 	}
 	if err := tmpl.Execute(w, data); err != nil {
 		h.log.Printf("Error rendering template: %v", err)
@@ -237,6 +325,14 @@ func (h *PostHandler) Post(w http.ResponseWriter, r *http.Request) {
 // CreatePost handles post creation.
 func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	h.log.Printf("CreatePost called with method: %s", r.Method)
+	if _, ok := r.Context().Value("userID").(int); ok && !emailVerified(r) {
+		http.Redirect(w, r, "/profile?error=Confirm your email address before creating posts", http.StatusSeeOther)
+		return
+	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
 	if r.Method == http.MethodGet {
 		userID, ok := r.Context().Value("userID").(int)
 		if !ok {
@@ -248,13 +344,15 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			username = user.Username
 		}
-		tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "create_post.html"))
+		tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "create_post.html")
 		if err != nil {
 			h.log.Printf("Template load error: %v", err)
 			renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
 			return
 		}
 		data := map[string]interface{}{
+			"CSRFToken":       csrfToken(r),
+			"CSPNonce":        cspNonce(r),
 			"Error":           r.URL.Query().Get("error"),
 			"Success":         r.URL.Query().Get("success"),
 			"IsAuthenticated": true,
@@ -277,6 +375,7 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, 21<<20)
 			err := r.ParseMultipartForm(21 << 20) // 21 MB
 			if err != nil {
 				http.Redirect(w, r, "/create-post?error=Error loading form", http.StatusSeeOther)
@@ -303,6 +402,14 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			role, _ := r.Context().Value("role").(string)
+			verdict := screenContent(h.automod, h.log, role, automod.Content{Type: models.RevisionPost, AuthorID: userID, Title: title, Text: content})
+			if verdict.Rejected() {
+				applyVerdict(h.automod, h.log, verdict, 0)
+				http.Redirect(w, r, "/create-post?error=Your post was blocked by automatic moderation", http.StatusSeeOther)
+				return
+			}
+
 			// Image handling
 			var imagePath string
 			file, header, err := r.FormFile("image")
@@ -346,9 +453,10 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 			}
 
 			post := &models.Post{
-				UserID:  userID,
-				Title:   title,
-				Content: content,
+				UserID:     userID,
+				Title:      title,
+				Content:    content,
+				Visibility: verdict.Visibility(),
 			}
 
 			postID, err := h.repo.CreatePost(post)
@@ -388,12 +496,21 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
+			id := int(postID)
+			applyVerdict(h.automod, h.log, verdict, id)
+			if verdict.Queued() {
+				h.log.Printf("Post %s by user %d queued for review", title, userID)
+				http.Redirect(w, r, "/post?id="+strconv.Itoa(id)+"&success=Your post is waiting for a moderator to review it", http.StatusSeeOther)
+				return
+			}
+			notifyMentions(h.repo, userID, &id, nil, "", content)
+
 			h.log.Printf("Post %s created by user %d", title, userID)
 			http.Redirect(w, r, "/?success=Post successfully created", http.StatusSeeOther)
 			return
 		}
 
-		tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "create_post.html"))
+		tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "create_post.html")
 		if err != nil {
 			h.log.Printf("Error loading template: %v", err)
 			renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
@@ -401,6 +518,8 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		}
 
 		data := map[string]interface{}{
+			"CSRFToken":       csrfToken(r),
+			"CSPNonce":        cspNonce(r),
 			"Error":           r.URL.Query().Get("error"),
 			"Success":         r.URL.Query().Get("success"),
 			"IsAuthenticated": isAuthenticated,
@@ -422,6 +541,10 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login?error=Authentication required", http.StatusSeeOther)
 		return
 	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
 	role, _ := r.Context().Value("role").(string)
 
 	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
@@ -441,15 +564,17 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "edit_post.html"))
+		tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "edit_post.html")
 		if err != nil {
 			h.log.Printf("Template load error: %v", err)
 			renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
 			return
 		}
 		data := map[string]interface{}{
-			"Post":  post,
-			"Error": r.URL.Query().Get("error"),
+			"CSRFToken": csrfToken(r),
+			"CSPNonce":  cspNonce(r),
+			"Post":      post,
+			"Error":     r.URL.Query().Get("error"),
 		}
 		tmpl.Execute(w, data)
 		return
@@ -473,18 +598,36 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/edit-post?id="+strconv.Itoa(postID)+"&error=Fill all fields", http.StatusSeeOther)
 			return
 		}
-		if err := h.repo.UpdatePost(postID, title, content); err != nil {
+		verdict := screenContent(h.automod, h.log, role, automod.Content{Type: models.RevisionPost, ID: postID, AuthorID: post.UserID, Title: title, Text: content})
+		if verdict.Rejected() {
+			applyVerdict(h.automod, h.log, verdict, 0)
+			http.Redirect(w, r, "/edit-post?id="+strconv.Itoa(postID)+"&error=Your changes were blocked by automatic moderation", http.StatusSeeOther)
+			return
+		}
+		if err := h.repo.UpdatePost(postID, userID, title, content); err != nil {
 			h.log.Printf("Post update error: %v", err)
 			http.Redirect(w, r, "/edit-post?id="+strconv.Itoa(postID)+"&error=Update error", http.StatusSeeOther)
 			return
 		}
+		updated := *post
+		updated.Title, updated.Content = title, content
+		audit(h.repo, h.log, r, models.AuditPostEdit, models.RevisionPost, postID, postSnapshot(post), postSnapshot(&updated))
+		applyVerdict(h.automod, h.log, verdict, postID)
+		if verdict.Held() {
+			http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&success=Post updated and waiting for a moderator to review it", http.StatusSeeOther)
+			return
+		}
+		// Mentions in a queued post are sent once it is approved
+		if post.Visibility != models.VisibilityPending {
+			notifyMentions(h.repo, userID, &postID, nil, post.Content, content)
+		}
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&success=Post updated", http.StatusSeeOther)
 		return
 	}
 	renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Method not allowed", h.projectRoot)
 }
 
-// DeletePost deletes a post (only author, moderator, or admin)
+// DeletePost moves a post to the trash (only author, moderator, or admin)
 func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
@@ -512,11 +655,12 @@ func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No permission to delete", http.StatusForbidden)
 		return
 	}
-	if err := h.repo.DeletePost(postID); err != nil {
+	if err := h.repo.DeletePost(postID, userID); err != nil {
 		h.log.Printf("Error deleting post: %v", err)
 		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID)+"&error=Error deleting post", http.StatusSeeOther)
 		return
 	}
+	audit(h.repo, h.log, r, models.AuditPostDelete, models.RevisionPost, postID, postSnapshot(post), nil)
 	http.Redirect(w, r, "/posts?success=Post deleted", http.StatusSeeOther)
 }
 
@@ -532,9 +676,80 @@ type CommentView struct {
 	ID        int
 	PostID    int
 	UserID    int
+	ParentID  *int
 	Username  string
 	Content   string
+	HTML      template.HTML
 	CreatedAt interface{}
+	UpdatedAt *time.Time
 	Likes     int
 	Dislikes  int
+	Depth     int
+	Replies   []*CommentView
+	Hidden    bool // hidden by a moderator
+	Pending   bool // waiting in the moderation queue
+	Deleted   bool // in the trash
+	Redacted  bool // hidden or deleted and the viewer may not see the text
+}
+
+// Indent returns the left margin of a comment in the thread, in rem.
+func (c *CommentView) Indent() int {
+	return c.Depth * 2
+}
+
+// buildCommentTree assembles comments (newest first, as returned by the
+// repository) into threads. Top-level comments stay newest first, replies are
+// ordered oldest first. Replies deeper than maxDepth are attached to their
+// ancestor at maxDepth, and replies whose parent no longer exists become
+// top-level comments.
+func buildCommentTree(comments []*CommentView, maxDepth int) []*CommentView {
+	byID := make(map[int]*CommentView, len(comments))
+	for _, c := range comments {
+		c.Replies = nil
+		byID[c.ID] = c
+	}
+
+	var roots []*CommentView
+	// Walk oldest first so every reply list ends up in chronological order
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		var parent *CommentView
+		if c.ParentID != nil {
+			parent = byID[*c.ParentID]
+		}
+		if parent == nil {
+			roots = append([]*CommentView{c}, roots...)
+			continue
+		}
+		parent.Replies = append(parent.Replies, c)
+	}
+
+	var setDepth func(list []*CommentView, depth int) []*CommentView
+	setDepth = func(list []*CommentView, depth int) []*CommentView {
+		var flattened []*CommentView
+		for _, c := range list {
+			c.Depth = depth
+			if maxDepth > 0 && depth >= maxDepth {
+				// Too deep: hoist the whole subtree to this level
+				flattened = append(flattened, c)
+				flattened = append(flattened, setDepth(c.Replies, depth)...)
+				c.Replies = nil
+				continue
+			}
+			c.Replies = setDepth(c.Replies, depth+1)
+			flattened = append(flattened, c)
+		}
+		return flattened
+	}
+	return setDepth(roots, 0)
+}
+
+// flattenCommentTree returns the comments of a tree in display order (depth first).
+func flattenCommentTree(tree []*CommentView) []*CommentView {
+	var list []*CommentView
+	for _, c := range tree {
+		list = append(list, c)
+		list = append(list, flattenCommentTree(c.Replies)...)
+	}
+	return list
 }
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"forum/internal/automod"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The post page takes the viewer's role from the auth middleware, so a
+// moderator without the required two-factor authentication, or with a ban,
+// sees hidden and pending content no more than any other user.
+func TestPostPageUsesMiddlewareRole(t *testing.T) {
+	repo := setupTestRepo(t)
+	bob, _ := createTestUser(t, repo, "bob", "user")
+	mod, _ := createTestUser(t, repo, "mod", "moderator")
+	pid, _ := repo.CreatePost(&models.Post{UserID: bob.ID, Title: "Ждёт проверки", Content: "Текст поста", Visibility: models.VisibilityPending})
+	session := &models.Session{SessionID: "session-mod", UserID: mod.ID, Expires: time.Now().Add(time.Hour)}
+	if err := repo.CreateSession(session); err != nil {
+		t.Fatal(err)
+	}
+	posts := NewPostHandler(repo, testLogger, "../..", 3, automod.New(repo, automod.Premoderation{}))
+
+	view := func(policy middleware.Policy) int {
+		req := httptest.NewRequest(http.MethodGet, "/post?id="+strconv.Itoa(int(pid)), nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: session.SessionID})
+		rec := httptest.NewRecorder()
+		middleware.OptionalAuthMiddleware(repo, testLogger, policy)(http.HandlerFunc(posts.Post)).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := view(middleware.Policy{}); code != http.StatusOK {
+		t.Fatalf("Модератор не видит пост на проверке: статус %d", code)
+	}
+	if code := view(middleware.Policy{TwoFactorRoles: []string{"moderator"}}); code != http.StatusNotFound {
+		t.Errorf("Модератор без обязательной 2FA видит пост на проверке: статус %d", code)
+	}
+	if err := repo.CreateBan(&models.Ban{UserID: mod.ID, Reason: "спам", IssuedBy: mod.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if code := view(middleware.Policy{}); code != http.StatusNotFound {
+		t.Errorf("Забаненный модератор видит пост на проверке: статус %d", code)
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"forum/internal/db"
-	"html/template"
+	"forum/internal/models"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type ProfileHandler struct {
@@ -30,20 +33,163 @@ func (h *ProfileHandler) Activity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.render(w, r, userID, map[string]interface{}{
+		"Error":   r.URL.Query().Get("error"),
+		"Success": r.URL.Query().Get("success"),
+	})
+}
+
+// render executes profile.html with the user, their activity, API tokens and
+// two-factor state plus extra data.
+func (h *ProfileHandler) render(w http.ResponseWriter, r *http.Request, userID int, data map[string]interface{}) {
 	posts, _ := h.repo.GetPostsByUser(userID)
 	comments, _ := h.repo.GetCommentsByUser(userID)
 	likes, _ := h.repo.GetLikesByUser(userID)
+	tokens, _ := h.repo.GetAPITokensByUser(userID)
+	user, _ := h.repo.GetUserByID(userID)
+	recoveryLeft, _ := h.repo.CountRecoveryCodes(userID)
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "profile.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	data["Posts"] = posts
+	data["Comments"] = comments
+	data["Likes"] = likes
+	data["Tokens"] = tokens
+	data["User"] = user
+	data["RecoveryCodesLeft"] = recoveryLeft
+	data["TwoFactorRequired"], _ = r.Context().Value("twoFactorRequired").(bool)
+	data["Role"], _ = r.Context().Value("role").(string)
+	data["CSRFToken"] = csrfToken(r)
+	data["CSPNonce"] = cspNonce(r)
+	tmpl.Execute(w, data)
+}
+
+// CreateToken creates a personal API token. The secret is shown only once,
+// on the page rendered in response.
+func (h *ProfileHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	// Tokens must not be able to mint new tokens
+	if r.Context().Value("apiToken") != nil {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "API-токен не может создавать токены", h.projectRoot)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Redirect(w, r, "/profile?error=Ошибка обработки формы", http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if nameLen := utf8.RuneCountInString(name); nameLen < 1 || nameLen > 50 {
+		http.Redirect(w, r, "/profile?error=Название токена должно быть от 1 до 50 символов", http.StatusSeeOther)
+		return
+	}
+
+	role, _ := r.Context().Value("role").(string)
+	var scopes []string
+	for _, scope := range r.Form["scopes"] {
+		switch scope {
+		case models.ScopeRead, models.ScopeWrite:
+		case models.ScopeModerate:
+			if role != "admin" && role != "moderator" {
+				http.Redirect(w, r, "/profile?error=Область moderate доступна только модераторам", http.StatusSeeOther)
+				return
+			}
+		default:
+			http.Redirect(w, r, "/profile?error=Неизвестная область доступа", http.StatusSeeOther)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		http.Redirect(w, r, "/profile?error=Выберите хотя бы одну область доступа", http.StatusSeeOther)
+		return
+	}
+
+	secret, token, err := h.repo.CreateAPIToken(userID, name, scopes)
+	if err != nil {
+		h.log.Printf("Ошибка создания токена: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка создания токена", http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, userID, map[string]interface{}{
+		"NewToken":     secret,
+		"NewTokenName": token.Name,
+	})
+}
+
+// RevokeToken deletes one of the user's API tokens.
+func (h *ProfileHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	tokenID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/profile?error=Неверный ID токена", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteAPIToken(tokenID, userID); err != nil {
+		h.log.Printf("Ошибка удаления токена: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка удаления токена", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/profile?success=Токен отозван", http.StatusSeeOther)
+}
+
+// Public displays a user's public profile (/user?username=) with their posts.
+// @mentions in posts and comments link here.
+func (h *ProfileHandler) Public(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	user, err := h.repo.GetUserByUsername(r.URL.Query().Get("username"))
+	if err != nil {
+		renderError(w, http.StatusNotFound, "404 Not Found", "Пользователь не найден", h.projectRoot)
+		return
+	}
+	posts, err := h.repo.GetPostsByUser(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки постов: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	// Authors find their hidden and pending posts on their own profile page.
+	visible := posts[:0]
+	for _, p := range posts {
+		if p.Visibility == models.VisibilityVisible {
+			visible = append(visible, p)
+		}
+	}
+	posts = visible
 
-	tmpl, err := template.ParseFiles("static/profile.html")
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "user.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
 		return
 	}
 	data := map[string]interface{}{
+		"User":     user,
 		"Posts":    posts,
-		"Comments": comments,
-		"Likes":    likes,
+		"CSPNonce": cspNonce(r),
 	}
 	tmpl.Execute(w, data)
 }
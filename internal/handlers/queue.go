@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"forum/internal/db"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// QueueHandler serves the moderation queue of pending posts and comments.
+type QueueHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+}
+
+func NewQueueHandler(repo *db.Repository, log *log.Logger, projectRoot string) *QueueHandler {
+	return &QueueHandler{repo: repo, log: log, projectRoot: projectRoot}
+}
+
+// Queue lists the posts and comments waiting for approval, oldest first
+// (only for moderator and admin).
+func (h *QueueHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+	items, err := h.repo.GetModerationQueue()
+	if err != nil {
+		h.log.Printf("Ошибка загрузки очереди модерации: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "moderation_queue.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Items":     items,
+		"Error":     r.URL.Query().Get("error"),
+		"Success":   r.URL.Query().Get("success"),
+	}
+	tmpl.Execute(w, data)
+}
+
+// Approve publishes the pending post or comment given by ?type= and ?id=.
+// The notifications held back while it was queued are sent now, and the
+// author is told it was approved.
+func (h *QueueHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, true)
+}
+
+// Reject hides the pending post or comment given by ?type= and ?id= for
+// good and tells the author.
+func (h *QueueHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, false)
+}
+
+func (h *QueueHandler) decide(w http.ResponseWriter, r *http.Request, approve bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+	moderatorID, _ := r.Context().Value("userID").(int)
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/moderation/queue?error=Некорректный id", http.StatusSeeOther)
+		return
+	}
+	visibility, notifType, success := models.VisibilityHidden, "content_rejected", "Отклонено"
+	if approve {
+		visibility, notifType, success = models.VisibilityVisible, "content_approved", "Опубликовано"
+	}
+
+	switch r.URL.Query().Get("type") {
+	case models.RevisionPost:
+		post, err := h.repo.GetPostByID(id)
+		if err != nil {
+			http.Redirect(w, r, "/moderation/queue?error=Пост не найден", http.StatusSeeOther)
+			return
+		}
+		if post.Visibility != models.VisibilityPending {
+			http.Redirect(w, r, "/moderation/queue?error=Пост уже рассмотрен", http.StatusSeeOther)
+			return
+		}
+		if err := h.repo.SetPostVisibility(id, visibility); err != nil {
+			h.log.Printf("Ошибка модерации поста %d: %v", id, err)
+			http.Redirect(w, r, "/moderation/queue?error=Ошибка обновления поста", http.StatusSeeOther)
+			return
+		}
+		action := models.AuditPostReject
+		if approve {
+			action = models.AuditPostApprove
+		}
+		after := *post
+		after.Visibility = visibility
+		audit(h.repo, h.log, r, action, models.RevisionPost, id, postSnapshot(post), postSnapshot(&after))
+		if approve {
+			notifyMentions(h.repo, post.UserID, &id, nil, "", post.Content)
+		}
+		if err := h.repo.CreateNotification(post.UserID, notifType, &moderatorID, &id, nil); err != nil {
+			h.log.Printf("Ошибка уведомления автора поста %d: %v", id, err)
+		}
+	case models.RevisionComment:
+		comment, err := h.repo.GetCommentByID(id)
+		if err != nil {
+			http.Redirect(w, r, "/moderation/queue?error=Комментарий не найден", http.StatusSeeOther)
+			return
+		}
+		if comment.Visibility != models.VisibilityPending {
+			http.Redirect(w, r, "/moderation/queue?error=Комментарий уже рассмотрен", http.StatusSeeOther)
+			return
+		}
+		if err := h.repo.SetCommentVisibility(id, visibility); err != nil {
+			h.log.Printf("Ошибка модерации комментария %d: %v", id, err)
+			http.Redirect(w, r, "/moderation/queue?error=Ошибка обновления комментария", http.StatusSeeOther)
+			return
+		}
+		action := models.AuditCommentReject
+		if approve {
+			action = models.AuditCommentApprove
+		}
+		after := *comment
+		after.Visibility = visibility
+		audit(h.repo, h.log, r, action, models.RevisionComment, id, commentSnapshot(comment), commentSnapshot(&after))
+		if approve {
+			var parent *models.Comment
+			if comment.ParentID != nil {
+				parent, _ = h.repo.GetCommentByID(*comment.ParentID)
+			}
+			notified := notifyComment(h.repo, comment, parent)
+			notifyMentions(h.repo, comment.UserID, &comment.PostID, &comment.ID, "", comment.Content, notified...)
+		}
+		if err := h.repo.CreateNotification(comment.UserID, notifType, &moderatorID, &comment.PostID, &comment.ID); err != nil {
+			h.log.Printf("Ошибка уведомления автора комментария %d: %v", id, err)
+		}
+	default:
+		http.Redirect(w, r, "/moderation/queue?error=Неизвестный тип содержимого", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("%s %d: %s модератором %d", r.URL.Query().Get("type"), id, visibility, moderatorID)
+	http.Redirect(w, r, "/moderation/queue?success="+success, http.StatusSeeOther)
+}
@@ -2,21 +2,84 @@ package handlers
 
 import (
 	"forum/internal/db"
-	"html/template"
+	"forum/internal/mail"
+	"forum/internal/models"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type ReportHandler struct {
 	repo        *db.Repository
 	log         *log.Logger
 	projectRoot string
+	mailer      *mail.Mailer
 }
 
-func NewReportHandler(repo *db.Repository, log *log.Logger, projectRoot string) *ReportHandler {
-	return &ReportHandler{repo: repo, log: log, projectRoot: projectRoot}
+func NewReportHandler(repo *db.Repository, log *log.Logger, projectRoot string, mailer *mail.Mailer) *ReportHandler {
+	return &ReportHandler{repo: repo, log: log, projectRoot: projectRoot, mailer: mailer}
+}
+
+// ReportView is a report with the reported content and the people involved,
+// as shown in the moderation queue.
+type ReportView struct {
+	*models.Report
+	ReporterName string
+	// Kind is "post" or "comment"
+	Kind       string
+	Title      string // post title, or an excerpt of the comment
+	URL        string // empty when the content was deleted
+	AuthorID   int
+	AuthorName string
+	Hidden     bool
+	Missing    bool
+}
+
+// reportTarget loads the post or comment a report is about. A comment
+// report usually carries the post ID too, so the comment is checked first.
+func (h *ReportHandler) reportTarget(rep *models.Report) (post *models.Post, comment *models.Comment, err error) {
+	if rep.CommentID != nil {
+		comment, err = h.repo.GetCommentByID(*rep.CommentID)
+		return nil, comment, err
+	}
+	if rep.PostID != nil {
+		post, err = h.repo.GetPostByID(*rep.PostID)
+		return post, nil, err
+	}
+	return nil, nil, errUnknownTarget
+}
+
+func (h *ReportHandler) reportView(rep *models.Report) *ReportView {
+	view := &ReportView{Report: rep, Kind: "post"}
+	if rep.CommentID != nil {
+		view.Kind = "comment"
+	}
+	if reporter, err := h.repo.GetUserByID(rep.ReporterID); err == nil {
+		view.ReporterName = reporter.Username
+	}
+	post, comment, err := h.reportTarget(rep)
+	switch {
+	case err != nil:
+		view.Missing = true
+		return view
+	case comment != nil:
+		view.Title = truncate(comment.Content, 120)
+		view.URL = "/post?id=" + strconv.Itoa(comment.PostID) + "#comment-" + strconv.Itoa(comment.ID)
+		view.AuthorID = comment.UserID
+		view.Hidden = comment.Visibility == models.VisibilityHidden
+	default:
+		view.Title = post.Title
+		view.URL = "/post?id=" + strconv.Itoa(post.ID)
+		view.AuthorID = post.UserID
+		view.Hidden = post.Visibility == models.VisibilityHidden
+	}
+	if author, err := h.repo.GetUserByID(view.AuthorID); err == nil {
+		view.AuthorName = author.Username
+	}
+	return view
 }
 
 // ReportForm displays the report submission form
@@ -26,14 +89,20 @@ func (h *ReportHandler) ReportForm(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
 		return
 	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
 
-	tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "report_form.html"))
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "report_form.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 	data := map[string]interface{}{
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
 		"PostID":    r.URL.Query().Get("post_id"),
 		"CommentID": r.URL.Query().Get("comment_id"),
 		"Error":     r.URL.Query().Get("error"),
@@ -52,6 +121,10 @@ func (h *ReportHandler) SubmitReport(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
 		return
 	}
+	if activeBan(r) != nil {
+		http.Redirect(w, r, "/banned", http.StatusSeeOther)
+		return
+	}
 
 	postIDStr := r.FormValue("post_id")
 	commentIDStr := r.FormValue("comment_id")
@@ -79,6 +152,17 @@ func (h *ReportHandler) SubmitReport(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/?error=Ошибка жалобы", http.StatusSeeOther)
 		return
 	}
+	// Пожаловаться можно только на то, что пользователь видит
+	var err error
+	if commentIDPtr != nil {
+		_, err = viewableComment(h.repo, r, *commentIDPtr)
+	} else {
+		_, err = viewablePost(h.repo, r, *postIDPtr)
+	}
+	if err != nil {
+		http.Redirect(w, r, "/?error=Содержимое не найдено", http.StatusSeeOther)
+		return
+	}
 
 	if err := h.repo.CreateReport(userID, postIDPtr, commentIDPtr, reason); err != nil {
 		h.log.Printf("Ошибка создания жалобы: %v", err)
@@ -88,7 +172,8 @@ func (h *ReportHandler) SubmitReport(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/?success=Жалоба отправлена", http.StatusSeeOther)
 }
 
-// ListReports displays all reports (only for moderator and admin)
+// ListReports displays the moderation queue, optionally filtered by status
+// (only for moderator and admin)
 func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
@@ -100,27 +185,49 @@ func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reports, err := h.repo.GetAllReports()
+	status := r.URL.Query().Get("status")
+	var reports []*models.Report
+	var err error
+	if status == "" {
+		reports, err = h.repo.GetAllReports()
+	} else {
+		reports, err = h.repo.GetReportsPage(status, 0, -1) // -1: no limit
+	}
 	if err != nil {
 		h.log.Printf("Ошибка получения всех жалоб: %v", err)
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
+	views := make([]*ReportView, 0, len(reports))
+	for _, rep := range reports {
+		views = append(views, h.reportView(rep))
+	}
 
-	tmpl, err := template.ParseFiles(filepath.Join(h.projectRoot, "static", "reports.html"))
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "reports.html")
 	if err != nil {
 		h.log.Printf("Ошибка загрузки шаблона: %v", err)
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 	data := map[string]interface{}{
-		"Reports": reports,
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Reports":   views,
+		"Status":    status,
+		"Statuses":  []string{models.ReportOpen, models.ReportInReview, models.ReportActioned, models.ReportRejected},
+		"Durations": banDurations,
+		"Error":     r.URL.Query().Get("error"),
+		"Success":   r.URL.Query().Get("success"),
 	}
 	tmpl.Execute(w, data)
 }
 
-// CloseReport handles report closure (only for moderator and admin)
-func (h *ReportHandler) CloseReport(w http.ResponseWriter, r *http.Request) {
+// ResolveReport applies a moderator's decision to a report (only for
+// moderator and admin). The action is one of "review", which only marks the
+// report as being looked at, "hide" or "delete" for the reported content,
+// "warn" or "suspend" for its author, and "dismiss". Every action but
+// review resolves the report and notifies the reporter.
+func (h *ReportHandler) ResolveReport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -130,20 +237,182 @@ func (h *ReportHandler) CloseReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Доступ запрещён", http.StatusForbidden)
 		return
 	}
-	reportIDStr := r.URL.Query().Get("id")
-	if reportIDStr == "" {
-		http.Redirect(w, r, "/reports?error=Нет id жалобы", http.StatusSeeOther)
+	moderatorID, _ := r.Context().Value("userID").(int)
+
+	reportID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/reports?error=Некорректный id", http.StatusSeeOther)
 		return
 	}
-	reportID, err := strconv.Atoi(reportIDStr)
+	rep, err := h.repo.GetReportByID(reportID)
 	if err != nil {
-		http.Redirect(w, r, "/reports?error=Некорректный id", http.StatusSeeOther)
+		http.Redirect(w, r, "/reports?error=Жалоба не найдена", http.StatusSeeOther)
+		return
+	}
+	if rep.Resolved() {
+		http.Redirect(w, r, "/reports?error=Жалоба уже рассмотрена", http.StatusSeeOther)
+		return
+	}
+	action := r.FormValue("action")
+	note := strings.TrimSpace(r.FormValue("note"))
+	if utf8.RuneCountInString(note) > 1000 {
+		http.Redirect(w, r, "/reports?error=Комментарий модератора не длиннее 1000 символов", http.StatusSeeOther)
+		return
+	}
+
+	if action == "review" {
+		if err := h.repo.UpdateReportStatus(rep.ID, models.ReportInReview, "", note, moderatorID); err != nil {
+			h.log.Printf("Ошибка обновления жалобы %d: %v", rep.ID, err)
+			http.Redirect(w, r, "/reports?error=Ошибка обновления жалобы", http.StatusSeeOther)
+			return
+		}
+		after := *rep
+		after.Status, after.ResolutionNote = models.ReportInReview, note
+		audit(h.repo, h.log, r, models.AuditReportResolve, "report", rep.ID, reportSnapshot(rep), reportSnapshot(&after))
+		http.Redirect(w, r, "/reports?success=Жалоба взята в работу", http.StatusSeeOther)
 		return
 	}
-	if err := h.repo.CloseReport(reportID); err != nil {
-		h.log.Printf("Ошибка закрытия жалобы: %v", err)
-		http.Redirect(w, r, "/reports?error=Ошибка закрытия", http.StatusSeeOther)
+	if action == models.ReportActionDismiss {
+		h.resolve(w, r, rep, models.ReportRejected, action, note, moderatorID, "Жалоба отклонена")
+		return
+	}
+
+	post, comment, err := h.reportTarget(rep)
+	if err != nil {
+		http.Redirect(w, r, "/reports?error=Содержимое жалобы не найдено, её можно только отклонить", http.StatusSeeOther)
+		return
+	}
+	var postID, commentID *int
+	authorID := 0
+	if comment != nil {
+		postID, commentID, authorID = &comment.PostID, &comment.ID, comment.UserID
+	} else {
+		postID, authorID = &post.ID, post.UserID
+	}
+
+	switch action {
+	case models.ReportActionHide:
+		if comment != nil {
+			err = h.repo.SetCommentVisibility(comment.ID, models.VisibilityHidden)
+		} else {
+			err = h.repo.SetPostVisibility(post.ID, models.VisibilityHidden)
+		}
+		if err != nil {
+			h.log.Printf("Ошибка скрытия содержимого по жалобе %d: %v", rep.ID, err)
+			http.Redirect(w, r, "/reports?error=Ошибка скрытия", http.StatusSeeOther)
+			return
+		}
+		h.auditVisibility(r, post, comment, models.VisibilityHidden)
+		h.resolve(w, r, rep, models.ReportActioned, action, note, moderatorID, "Содержимое скрыто")
+
+	case models.ReportActionRestore:
+		if comment != nil {
+			err = h.repo.SetCommentVisibility(comment.ID, models.VisibilityVisible)
+		} else {
+			err = h.repo.SetPostVisibility(post.ID, models.VisibilityVisible)
+		}
+		if err != nil {
+			h.log.Printf("Ошибка восстановления содержимого по жалобе %d: %v", rep.ID, err)
+			http.Redirect(w, r, "/reports?error=Ошибка восстановления", http.StatusSeeOther)
+			return
+		}
+		h.auditVisibility(r, post, comment, models.VisibilityVisible)
+		h.resolve(w, r, rep, models.ReportRejected, action, note, moderatorID, "Содержимое снова показано")
+
+	case models.ReportActionDelete:
+		if comment != nil {
+			err = h.repo.DeleteComment(comment.ID, moderatorID)
+		} else {
+			err = h.repo.DeletePost(post.ID, moderatorID)
+		}
+		if err != nil {
+			h.log.Printf("Ошибка удаления содержимого по жалобе %d: %v", rep.ID, err)
+			http.Redirect(w, r, "/reports?error=Ошибка удаления", http.StatusSeeOther)
+			return
+		}
+		if comment != nil {
+			audit(h.repo, h.log, r, models.AuditCommentDelete, models.RevisionComment, comment.ID, commentSnapshot(comment), nil)
+		} else {
+			audit(h.repo, h.log, r, models.AuditPostDelete, models.RevisionPost, post.ID, postSnapshot(post), nil)
+		}
+		h.resolve(w, r, rep, models.ReportActioned, action, note, moderatorID, "Содержимое удалено")
+
+	case models.ReportActionWarn, models.ReportActionSuspend:
+		if n := utf8.RuneCountInString(note); n < 3 {
+			http.Redirect(w, r, "/reports?error=Укажите причину для автора (не короче 3 символов)", http.StatusSeeOther)
+			return
+		}
+		author, err := h.repo.GetUserByID(authorID)
+		if err != nil {
+			http.Redirect(w, r, "/reports?error=Автор не найден", http.StatusSeeOther)
+			return
+		}
+		if action == models.ReportActionWarn {
+			notifyWarning(h.repo, h.mailer, h.log, author, postID, commentID, note, moderatorID)
+			h.log.Printf("Пользователь %d предупреждён модератором %d по жалобе %d", author.ID, moderatorID, rep.ID)
+			audit(h.repo, h.log, r, models.AuditUserWarn, "user", author.ID, nil, map[string]interface{}{"report_id": rep.ID, "reason": note})
+			h.resolve(w, r, rep, models.ReportActioned, action, note, moderatorID, "Автору отправлено предупреждение")
+			return
+		}
+		if author.ID == moderatorID || !canBan(role, author) {
+			http.Redirect(w, r, "/reports?error=Нельзя заблокировать этого пользователя", http.StatusSeeOther)
+			return
+		}
+		expires, ok := parseBanDuration(r.FormValue("duration"), time.Now())
+		if !ok {
+			http.Redirect(w, r, "/reports?error=Неверный срок блокировки", http.StatusSeeOther)
+			return
+		}
+		ban := &models.Ban{UserID: author.ID, Reason: note, IssuedBy: moderatorID, ExpiresAt: expires}
+		if err := h.repo.CreateBan(ban); err != nil {
+			h.log.Printf("Ошибка блокировки пользователя %d: %v", author.ID, err)
+			http.Redirect(w, r, "/reports?error=Ошибка блокировки", http.StatusSeeOther)
+			return
+		}
+		h.log.Printf("Пользователь %d заблокирован модератором %d по жалобе %d", author.ID, moderatorID, rep.ID)
+		audit(h.repo, h.log, r, models.AuditUserBan, "user", author.ID, nil, banSnapshot(ban))
+		notifyBan(h.repo, h.mailer, h.log, author, ban, false, moderatorID)
+		h.resolve(w, r, rep, models.ReportActioned, action, note, moderatorID, "Автор заблокирован")
+
+	default:
+		http.Redirect(w, r, "/reports?error=Неизвестное действие", http.StatusSeeOther)
+	}
+}
+
+// auditVisibility records hiding or showing again the reported comment, or
+// the post if comment is nil.
+func (h *ReportHandler) auditVisibility(r *http.Request, post *models.Post, comment *models.Comment, visibility string) {
+	if comment != nil {
+		action := models.AuditCommentUnhide
+		if visibility == models.VisibilityHidden {
+			action = models.AuditCommentHide
+		}
+		after := *comment
+		after.Visibility = visibility
+		audit(h.repo, h.log, r, action, models.RevisionComment, comment.ID, commentSnapshot(comment), commentSnapshot(&after))
+		return
+	}
+	action := models.AuditPostUnhide
+	if visibility == models.VisibilityHidden {
+		action = models.AuditPostHide
+	}
+	after := *post
+	after.Visibility = visibility
+	audit(h.repo, h.log, r, action, models.RevisionPost, post.ID, postSnapshot(post), postSnapshot(&after))
+}
+
+// resolve closes the report with the given status, tells the reporter and
+// redirects back to the queue.
+func (h *ReportHandler) resolve(w http.ResponseWriter, r *http.Request, rep *models.Report, status, action, note string, moderatorID int, success string) {
+	if err := h.repo.UpdateReportStatus(rep.ID, status, action, note, moderatorID); err != nil {
+		h.log.Printf("Ошибка обновления жалобы %d: %v", rep.ID, err)
+		http.Redirect(w, r, "/reports?error=Ошибка обновления жалобы", http.StatusSeeOther)
 		return
 	}
-	http.Redirect(w, r, "/reports?success=Жалоба закрыта", http.StatusSeeOther)
+	before := reportSnapshot(rep)
+	rep.Status, rep.Action, rep.ResolutionNote = status, action, note
+	audit(h.repo, h.log, r, models.AuditReportResolve, "report", rep.ID, before, reportSnapshot(rep))
+	notifyReporter(h.repo, h.log, rep, moderatorID)
+	h.log.Printf("Жалоба %d рассмотрена модератором %d: %s", rep.ID, moderatorID, action)
+	http.Redirect(w, r, "/reports?success="+success, http.StatusSeeOther)
 }
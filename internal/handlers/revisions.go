@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/db"
+	"forum/internal/diff"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// RevisionHandler shows the edit history of posts and comments.
+type RevisionHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+}
+
+// NewRevisionHandler creates a new RevisionHandler.
+func NewRevisionHandler(repo *db.Repository, log *log.Logger, projectRoot string) *RevisionHandler {
+	return &RevisionHandler{repo: repo, log: log, projectRoot: projectRoot}
+}
+
+// RevisionView is a revision with its editor's name for the template.
+type RevisionView struct {
+	*models.Revision
+	Number         int
+	EditorUsername string
+}
+
+// historyTarget loads the post or comment a history request refers to and
+// returns its author and the post it belongs to.
+func (h *RevisionHandler) historyTarget(targetType string, targetID int) (authorID, postID int, err error) {
+	switch targetType {
+	case models.RevisionPost:
+		post, err := h.repo.GetPostByID(targetID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return post.UserID, post.ID, nil
+	case models.RevisionComment:
+		comment, err := h.repo.GetCommentByID(targetID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return comment.UserID, comment.PostID, nil
+	}
+	return 0, 0, errUnknownTarget
+}
+
+// History lists the revisions of a post or comment and shows a line diff
+// between two of them (the two latest by default). Available to the author,
+// moderators and admins.
+func (h *RevisionHandler) History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Method not supported", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Authentication required", http.StatusSeeOther)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	targetType := r.URL.Query().Get("type")
+	targetID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || targetID <= 0 {
+		renderError(w, http.StatusBadRequest, "400 Bad Request", "Invalid ID", h.projectRoot)
+		return
+	}
+	authorID, postID, err := h.historyTarget(targetType, targetID)
+	if err != nil {
+		renderError(w, http.StatusNotFound, "404 Not Found", "Content not found", h.projectRoot)
+		return
+	}
+	if authorID != userID && role != "admin" && role != "moderator" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "No permission to view history", h.projectRoot)
+		return
+	}
+
+	revisions, err := h.repo.GetRevisions(targetType, targetID)
+	if err != nil {
+		h.log.Printf("Error loading revisions: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
+		return
+	}
+
+	var views []*RevisionView
+	byID := make(map[int]*RevisionView)
+	for i, rev := range revisions {
+		editor := ""
+		if user, err := h.repo.GetUserByID(rev.EditorID); err == nil {
+			editor = user.Username
+		}
+		view := &RevisionView{Revision: rev, Number: i + 1, EditorUsername: editor}
+		views = append(views, view)
+		byID[rev.ID] = view
+	}
+
+	// Pick the two revisions to compare
+	var from, to *RevisionView
+	if len(views) >= 2 {
+		from, to = views[len(views)-2], views[len(views)-1]
+	}
+	if id, err := strconv.Atoi(r.URL.Query().Get("from")); err == nil {
+		if v, ok := byID[id]; ok {
+			from = v
+		}
+	}
+	if id, err := strconv.Atoi(r.URL.Query().Get("to")); err == nil {
+		if v, ok := byID[id]; ok {
+			to = v
+		}
+	}
+
+	var lines []diff.Line
+	var titleFrom, titleTo string
+	if from != nil && to != nil {
+		lines = diff.Lines(from.Content, to.Content)
+		if from.Title != nil && to.Title != nil && *from.Title != *to.Title {
+			titleFrom, titleTo = *from.Title, *to.Title
+		}
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "history.html")
+	if err != nil {
+		h.log.Printf("Error loading template: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
+		return
+	}
+	data := map[string]interface{}{
+		"CSRFToken":  csrfToken(r),
+		"CSPNonce":   cspNonce(r),
+		"TargetType": targetType,
+		"TargetID":   targetID,
+		"PostID":     postID,
+		"Revisions":  views,
+		"From":       from,
+		"To":         to,
+		"Diff":       lines,
+		"TitleFrom":  titleFrom,
+		"TitleTo":    titleTo,
+		"CanRestore": role == "admin" || role == "moderator",
+		"Error":      r.URL.Query().Get("error"),
+		"Success":    r.URL.Query().Get("success"),
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		h.log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// Rollback restores a post or comment to an earlier revision (only moderator or admin).
+// Deleted posts and comments cannot be rolled back; restore them from the
+// trash first.
+func (h *RevisionHandler) Rollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Method not supported", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Authentication required", http.StatusSeeOther)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "No permission to restore revisions", h.projectRoot)
+		return
+	}
+
+	revisionID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || revisionID <= 0 {
+		renderError(w, http.StatusBadRequest, "400 Bad Request", "Invalid revision ID", h.projectRoot)
+		return
+	}
+	rev, err := h.repo.GetRevisionByID(revisionID)
+	if err != nil {
+		renderError(w, http.StatusNotFound, "404 Not Found", "Revision not found", h.projectRoot)
+		return
+	}
+	before := h.targetSnapshot(rev)
+	if _, err := h.repo.RollbackToRevision(revisionID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			renderError(w, http.StatusNotFound, "404 Not Found", "The post or comment is deleted", h.projectRoot)
+			return
+		}
+		h.log.Printf("Error restoring revision %d: %v", revisionID, err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Error restoring revision", h.projectRoot)
+		return
+	}
+	h.log.Printf("%s %d restored to revision %d by user %d", rev.TargetType, rev.TargetID, rev.ID, userID)
+	action := models.AuditPostRollback
+	if rev.TargetType == models.RevisionComment {
+		action = models.AuditCommentRollback
+	}
+	audit(h.repo, h.log, r, action, rev.TargetType, rev.TargetID, before, h.targetSnapshot(rev))
+	http.Redirect(w, r, "/history?type="+rev.TargetType+"&id="+strconv.Itoa(rev.TargetID)+"&success=Revision restored", http.StatusSeeOther)
+}
+
+// targetSnapshot is the audit log snapshot of the post or comment a
+// revision belongs to, nil if it cannot be loaded.
+func (h *RevisionHandler) targetSnapshot(rev *models.Revision) interface{} {
+	if rev.TargetType == models.RevisionComment {
+		if c, err := h.repo.GetCommentByID(rev.TargetID); err == nil {
+			return commentSnapshot(c)
+		}
+		return nil
+	}
+	if p, err := h.repo.GetPostByID(rev.TargetID); err == nil {
+		return postSnapshot(p)
+	}
+	return nil
+}
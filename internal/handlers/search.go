@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"forum/internal/db"
+	"forum/internal/models"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchHandler handles full-text search over posts and comments.
+type SearchHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+}
+
+// NewSearchHandler creates a new SearchHandler.
+func NewSearchHandler(repo *db.Repository, log *log.Logger, projectRoot string) *SearchHandler {
+	return &SearchHandler{repo: repo, log: log, projectRoot: projectRoot}
+}
+
+// SearchResultView is a search hit prepared for the template.
+type SearchResultView struct {
+	*models.SearchResult
+	SnippetHTML template.HTML
+}
+
+// Search displays the search form and ranked results.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Method not supported", h.projectRoot)
+		return
+	}
+
+	params := r.URL.Query()
+	query := models.SearchQuery{
+		Text:   strings.TrimSpace(params.Get("q")),
+		Author: strings.TrimSpace(params.Get("author")),
+		Limit:  50,
+	}
+	var formError string
+	if categoryID, err := strconv.Atoi(params.Get("category")); err == nil && categoryID > 0 {
+		query.CategoryID = categoryID
+	}
+	if from := params.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			formError = "Invalid start date"
+		} else {
+			query.From = &t
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			formError = "Invalid end date"
+		} else {
+			// The end date is inclusive for the user, so search up to the next day
+			t = t.AddDate(0, 0, 1)
+			query.To = &t
+		}
+	}
+
+	var results []*SearchResultView
+	if query.Text != "" && formError == "" {
+		hits, err := h.repo.SearchPosts(query)
+		if err != nil {
+			h.log.Printf("Search error: %v", err)
+			renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
+			return
+		}
+		for _, hit := range hits {
+			results = append(results, &SearchResultView{
+				SearchResult: hit,
+				SnippetHTML:  highlightSnippet(hit.Snippet),
+			})
+		}
+	}
+
+	categories, err := h.repo.GetAllCategories()
+	if err != nil {
+		h.log.Printf("Error loading categories: %v", err)
+	}
+
+	// Manual user determination from cookie
+	cookie, err := r.Cookie("session_id")
+	isAuthenticated := false
+	username := ""
+	if err == nil {
+		session, err := h.repo.GetSession(cookie.Value)
+		if err == nil {
+			user, err := h.repo.GetUserByID(session.UserID)
+			if err == nil {
+				isAuthenticated = true
+				username = user.Username
+			}
+		}
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "search.html")
+	if err != nil {
+		h.log.Printf("Error loading template: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Query":           query.Text,
+		"Author":          query.Author,
+		"CategoryID":      query.CategoryID,
+		"From":            params.Get("from"),
+		"To":              params.Get("to"),
+		"Categories":      categories,
+		"Results":         results,
+		"Searched":        query.Text != "" && formError == "",
+		"Error":           formError,
+		"IsAuthenticated": isAuthenticated,
+		"Username":        username,
+		"CSPNonce":        cspNonce(r),
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		h.log.Printf("Error rendering template: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Internal server error", h.projectRoot)
+	}
+}
+
+// highlightSnippet escapes a raw snippet and turns the match markers into <mark> tags.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, models.SnippetMatchStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, models.SnippetMatchEnd, "</mark>")
+	return template.HTML(escaped)
+}
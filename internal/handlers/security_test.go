@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"forum/internal/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The nonce in the Content-Security-Policy header must be the one the page
+// puts on its inline scripts, and must change with every request.
+func TestPageScriptsCarryCSPNonce(t *testing.T) {
+	repo := setupTestRepo(t)
+	h := NewAuthHandler(repo, testLogger, "../..", nil, AuthOptions{})
+	handler := middleware.SecurityHeaders(testLogger, middleware.SecurityOptions{
+		ContentSecurityPolicy: "script-src 'self' 'nonce-{nonce}'",
+	})(http.HandlerFunc(h.Login))
+
+	var previous string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+		csp := rec.Header().Get("Content-Security-Policy")
+		nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "script-src 'self' 'nonce-"), "'")
+		if nonce == "" || nonce == csp {
+			t.Fatalf("Нет nonce в заголовке %q", csp)
+		}
+		if !strings.Contains(rec.Body.String(), `<script nonce="`+nonce+`">`) {
+			t.Errorf("Встроенный скрипт страницы не помечен nonce %q", nonce)
+		}
+		if nonce == previous {
+			t.Errorf("nonce повторился: %q", nonce)
+		}
+		previous = nonce
+	}
+}
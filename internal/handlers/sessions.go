@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// sessionOwner returns the current user and the cookie of the session making
+// the request. Sessions are managed only from a browser session, not with an
+// API token. On failure it writes a response and returns ok=false.
+func (h *ProfileHandler) sessionOwner(w http.ResponseWriter, r *http.Request) (userID int, current string, ok bool) {
+	userID, ok = r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return 0, "", false
+	}
+	cookie, err := r.Cookie("session_id")
+	if r.Context().Value("apiToken") != nil || err != nil {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "Сессиями можно управлять только из браузера", h.projectRoot)
+		return 0, "", false
+	}
+	return userID, cookie.Value, true
+}
+
+// Sessions lists the devices the user is signed in on.
+func (h *ProfileHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, current, ok := h.sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := h.repo.GetUserSessions(userID)
+	if err != nil {
+		h.log.Printf("Ошибка загрузки сессий: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "sessions.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		renderError(w, http.StatusInternalServerError, "500 Internal Server Error", "Внутренняя ошибка сервера", h.projectRoot)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"CSRFToken": csrfToken(r),
+		"CSPNonce":  cspNonce(r),
+		"Sessions":  sessions,
+		"Current":   current,
+		"Error":     r.URL.Query().Get("error"),
+		"Success":   r.URL.Query().Get("success"),
+	})
+}
+
+// RevokeSession signs out one of the user's other sessions.
+func (h *ProfileHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, _, ok := h.sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Redirect(w, r, "/profile/sessions?error=Неверный ID сессии", http.StatusSeeOther)
+		return
+	}
+	if err := h.repo.DeleteUserSessionByID(userID, id); err != nil {
+		h.log.Printf("Ошибка удаления сессии: %v", err)
+		http.Redirect(w, r, "/profile/sessions?error=Ошибка завершения сессии", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/profile/sessions?success=Сессия завершена", http.StatusSeeOther)
+}
+
+// RevokeOtherSessions signs out every session except the current one.
+func (h *ProfileHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, current, ok := h.sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteUserSessions(userID, current); err != nil {
+		h.log.Printf("Ошибка удаления сессий: %v", err)
+		http.Redirect(w, r, "/profile/sessions?error=Ошибка завершения сессий", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/profile/sessions?success=Все остальные сессии завершены", http.StatusSeeOther)
+}
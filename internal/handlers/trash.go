@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/db"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TrashHandler lets moderators see deleted posts and comments and restore
+// them before they are purged.
+type TrashHandler struct {
+	repo        *db.Repository
+	log         *log.Logger
+	projectRoot string
+	retention   time.Duration
+}
+
+func NewTrashHandler(repo *db.Repository, log *log.Logger, projectRoot string, retention time.Duration) *TrashHandler {
+	return &TrashHandler{repo: repo, log: log, projectRoot: projectRoot, retention: retention}
+}
+
+// TrashView is a deleted post or comment with the time it will be purged.
+type TrashView struct {
+	*models.TrashedItem
+	PurgeAt time.Time
+}
+
+// Trash lists the deleted posts and comments (only for moderator and admin).
+func (h *TrashHandler) Trash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+	items, err := h.repo.GetTrash()
+	if err != nil {
+		h.log.Printf("Ошибка загрузки корзины: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	views := make([]*TrashView, 0, len(items))
+	for _, it := range items {
+		views = append(views, &TrashView{TrashedItem: it, PurgeAt: it.DeletedAt.Add(h.retention)})
+	}
+
+	tmpl, err := parsePage(h.repo, h.log, r, h.projectRoot, "trash.html")
+	if err != nil {
+		h.log.Printf("Ошибка загрузки шаблона: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"CSRFToken":     csrfToken(r),
+		"CSPNonce":      cspNonce(r),
+		"Items":         views,
+		"RetentionDays": int(h.retention.Hours() / 24),
+		"Error":         r.URL.Query().Get("error"),
+		"Success":       r.URL.Query().Get("success"),
+	}
+	tmpl.Execute(w, data)
+}
+
+// Restore takes the post or comment given by ?type= and ?id= out of the
+// trash (only for moderator and admin).
+func (h *TrashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	if role != "admin" && role != "moderator" {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+	moderatorID, _ := r.Context().Value("userID").(int)
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Redirect(w, r, "/moderation/trash?error=Некорректный id", http.StatusSeeOther)
+		return
+	}
+	kind := r.URL.Query().Get("type")
+	switch kind {
+	case models.RevisionPost:
+		err = h.repo.RestorePost(id)
+	case models.RevisionComment:
+		err = h.repo.RestoreComment(id)
+	default:
+		http.Redirect(w, r, "/moderation/trash?error=Неизвестный тип содержимого", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, "/moderation/trash?error=Этого нет в корзине", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.log.Printf("Ошибка восстановления %s %d: %v", kind, id, err)
+		http.Redirect(w, r, "/moderation/trash?error=Ошибка восстановления", http.StatusSeeOther)
+		return
+	}
+	action := models.AuditPostRestore
+	if kind == models.RevisionComment {
+		action = models.AuditCommentRestore
+	}
+	audit(h.repo, h.log, r, action, kind, id, nil, nil)
+	h.log.Printf("%s %d восстановлен модератором %d", kind, id, moderatorID)
+	http.Redirect(w, r, "/moderation/trash?success=Восстановлено", http.StatusSeeOther)
+}
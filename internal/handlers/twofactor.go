@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"forum/internal/db"
+	"forum/internal/models"
+	"forum/internal/totp"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpIssuer is the account label shown in authenticator apps.
+const totpIssuer = "Klondike Developers"
+
+// loginChallengeTTL is how long the second sign-in step may take.
+const loginChallengeTTL = 5 * time.Minute
+
+// LoginTwoFactor is the second sign-in step for users with two-factor
+// authentication: it accepts a TOTP code or a recovery code.
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("login_challenge")
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	userID, remember, err := h.repo.GetLoginChallenge(cookie.Value)
+	if err != nil {
+		if !errors.Is(err, db.ErrInvalidLoginChallenge) {
+			h.log.Printf("Ошибка проверки запроса 2FA: %v", err)
+		}
+		h.clearChallengeCookie(w)
+		http.Redirect(w, r, "/login?error=Время на ввод кода истекло. Войдите снова", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		h.renderPage(w, r, "login_2fa.html", map[string]interface{}{
+			"Error": r.URL.Query().Get("error"),
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil || !user.TwoFactorEnabled() {
+		h.clearChallengeCookie(w)
+		http.Redirect(w, r, "/login?error=Ошибка входа", http.StatusSeeOther)
+		return
+	}
+
+	// Неверные коды считаются вместе с неверными паролями, иначе перебор
+	// кода можно продолжать, заново начиная вход
+	attempt, allowed := h.loginAllowed(w, r, user.Username, clientIP(r))
+	if !allowed {
+		return
+	}
+	ok, err := h.checkSecondFactor(user.ID, user.TOTPSecret, r.FormValue("code"))
+	if err != nil {
+		h.log.Printf("Ошибка проверки кода 2FA: %v", err)
+		h.loginRefunded(attempt)
+		http.Redirect(w, r, "/login/2fa?error=Ошибка проверки кода", http.StatusSeeOther)
+		return
+	}
+	if !ok {
+		h.log.Printf("Неверный код 2FA для пользователя %s", user.Username)
+		if err := h.repo.FailLoginChallenge(cookie.Value); err != nil {
+			h.log.Printf("Ошибка записи попытки 2FA: %v", err)
+		}
+		http.Redirect(w, r, "/login/2fa?error=Неверный код", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.repo.DeleteLoginChallenge(cookie.Value); err != nil {
+		h.log.Printf("Ошибка удаления запроса 2FA: %v", err)
+	}
+	h.clearChallengeCookie(w)
+	h.loginSucceeded(attempt)
+	if err := h.startSession(w, r, user, remember); err != nil {
+		h.log.Printf("Ошибка создания сессии: %v", err)
+		http.Redirect(w, r, "/login?error=Ошибка входа", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("Пользователь вошел с 2FA: %s", user.Username)
+	http.Redirect(w, r, h.afterLoginURL(user), http.StatusSeeOther)
+}
+
+// checkSecondFactor accepts a current, unused TOTP code or an unused
+// recovery code.
+func (h *AuthHandler) checkSecondFactor(userID int, secret, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return h.repo.UseTOTPStep(userID, step)
+	}
+	if len(code) <= totp.Digits {
+		return false, nil
+	}
+	used, err := h.repo.UseRecoveryCode(userID, code)
+	if used {
+		h.log.Printf("Использован код восстановления пользователем %d", userID)
+	}
+	return used, err
+}
+
+// totpURI returns the enrollment URI. It is marked safe because html/template
+// would otherwise replace the otpauth: scheme in links.
+func totpURI(username, secret string) template.URL {
+	return template.URL(totp.URI(totpIssuer, username, secret))
+}
+
+func (h *AuthHandler) clearChallengeCookie(w http.ResponseWriter) {
+	h.opts.Cookies.Set(w, &http.Cookie{
+		Name:   "login_challenge",
+		Value:  "",
+		Path:   "/login",
+		MaxAge: -1,
+	})
+}
+
+// SetupTwoFactor generates a new authenticator secret and shows it with its
+// otpauth URI, to be confirmed by EnableTwoFactor.
+func (h *ProfileHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	if r.Context().Value("apiToken") != nil {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "API-токен не может менять настройки входа", h.projectRoot)
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		h.log.Printf("Ошибка получения пользователя: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка настройки 2FA#two-factor", http.StatusSeeOther)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/profile?error=Двухфакторная аутентификация уже включена#two-factor", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		err = h.repo.SetPendingTOTPSecret(userID, secret)
+	}
+	if err != nil {
+		h.log.Printf("Ошибка создания секрета 2FA: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка настройки 2FA#two-factor", http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, userID, map[string]interface{}{
+		"TOTPSecret": secret,
+		"TOTPURI":    totpURI(user.Username, secret),
+	})
+}
+
+// EnableTwoFactor turns on two-factor authentication once the user proves
+// their authenticator works, and shows the recovery codes once.
+func (h *ProfileHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return
+	}
+	if r.Context().Value("apiToken") != nil {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "API-токен не может менять настройки входа", h.projectRoot)
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil || user.TOTPSecret == "" || user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/profile?error=Сначала начните настройку 2FA#two-factor", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if !ok {
+		// Показываем тот же секрет снова, чтобы не сканировать код заново
+		h.render(w, r, userID, map[string]interface{}{
+			"Error":      "Неверный код. Проверьте время на устройстве и попробуйте ещё раз",
+			"TOTPSecret": user.TOTPSecret,
+			"TOTPURI":    totpURI(user.Username, user.TOTPSecret),
+		})
+		return
+	}
+	codes, err := h.repo.EnableTOTP(userID, step)
+	if err != nil {
+		h.log.Printf("Ошибка включения 2FA: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка включения 2FA#two-factor", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("2FA включена пользователем %s", user.Username)
+	h.render(w, r, userID, map[string]interface{}{
+		"Success":       "Двухфакторная аутентификация включена",
+		"RecoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication. It asks for the
+// password and a current code so a stolen session cannot do it.
+func (h *ProfileHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.confirmTwoFactorChange(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.DisableTOTP(user.ID); err != nil {
+		h.log.Printf("Ошибка отключения 2FA: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка отключения 2FA#two-factor", http.StatusSeeOther)
+		return
+	}
+	h.log.Printf("2FA отключена пользователем %s", user.Username)
+	http.Redirect(w, r, "/profile?success=Двухфакторная аутентификация отключена#two-factor", http.StatusSeeOther)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes and shows the new ones
+// once.
+func (h *ProfileHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.confirmTwoFactorChange(w, r)
+	if !ok {
+		return
+	}
+	codes, err := h.repo.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		h.log.Printf("Ошибка создания кодов восстановления: %v", err)
+		http.Redirect(w, r, "/profile?error=Ошибка создания кодов восстановления#two-factor", http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, user.ID, map[string]interface{}{
+		"Success":       "Новые коды восстановления созданы, старые больше не действуют",
+		"RecoveryCodes": codes,
+	})
+}
+
+// confirmTwoFactorChange checks the password and a current TOTP code before
+// a change to an enabled second factor. On failure it redirects and returns
+// false.
+func (h *ProfileHandler) confirmTwoFactorChange(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if r.Method != http.MethodPost {
+		renderError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed", "Метод не поддерживается", h.projectRoot)
+		return nil, false
+	}
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
+		return nil, false
+	}
+	if r.Context().Value("apiToken") != nil {
+		renderError(w, http.StatusForbidden, "403 Forbidden", "API-токен не может менять настройки входа", h.projectRoot)
+		return nil, false
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil || !user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/profile?error=Двухфакторная аутентификация не включена#two-factor", http.StatusSeeOther)
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(r.FormValue("password"))) != nil {
+		http.Redirect(w, r, "/profile?error=Неверный пароль#two-factor", http.StatusSeeOther)
+		return nil, false
+	}
+	step, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if ok {
+		ok, err = h.repo.UseTOTPStep(user.ID, step)
+	}
+	if err != nil || !ok {
+		http.Redirect(w, r, "/profile?error=Неверный код#two-factor", http.StatusSeeOther)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,176 @@
+// Package mail sends email through a persistent outbox. Handlers enqueue
+// messages with Mailer.Send, which only writes to the database; a background
+// worker (Mailer.Run) delivers them through a pluggable Transport and retries
+// failures with exponential backoff.
+package mail
+
+import (
+	"context"
+	"forum/internal/models"
+	"log"
+	"time"
+)
+
+// Message is a rendered email.
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string // optional
+}
+
+// Transport delivers a single message.
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Store is the persistent outbox; *db.Repository implements it.
+type Store interface {
+	EnqueueMail(m *models.OutboxMail) error
+	GetDueMail(now time.Time, limit int) ([]*models.OutboxMail, error)
+	MarkMailSent(mailID int) error
+	MarkMailAttemptFailed(mailID int, sendErr string, nextAttempt *time.Time) error
+}
+
+const (
+	// MaxAttempts is how many times delivery is tried before giving up.
+	MaxAttempts = 8
+	// retryBase is the delay after the first failure; it doubles each time.
+	retryBase = 30 * time.Second
+	retryMax  = 2 * time.Hour
+	// pollInterval is how often the worker checks for due retries.
+	pollInterval = 15 * time.Second
+	batchSize    = 20
+)
+
+// RetryDelay returns how long to wait after the given number of failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
+
+// Mailer renders templates into the outbox and delivers them in the background.
+type Mailer struct {
+	store     Store
+	transport Transport
+	log       *log.Logger
+	baseURL   string
+	wake      chan struct{}
+	now       func() time.Time
+}
+
+// NewMailer creates a mailer. baseURL is made available to templates as
+// .BaseURL for building absolute links.
+func NewMailer(store Store, transport Transport, logger *log.Logger, baseURL string) *Mailer {
+	return &Mailer{
+		store:     store,
+		transport: transport,
+		log:       logger,
+		baseURL:   baseURL,
+		wake:      make(chan struct{}, 1),
+		now:       time.Now,
+	}
+}
+
+// Send renders the named template with data and queues the result for to.
+// It does not wait for delivery.
+func (m *Mailer) Send(to, templateName string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["BaseURL"] = m.baseURL
+	msg, err := Render(templateName, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return m.Enqueue(msg)
+}
+
+// Enqueue queues an already rendered message and wakes the worker.
+func (m *Mailer) Enqueue(msg *Message) error {
+	err := m.store.EnqueueMail(&models.OutboxMail{
+		To:       msg.To,
+		Subject:  msg.Subject,
+		TextBody: msg.TextBody,
+		HTMLBody: msg.HTMLBody,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued mail until ctx is cancelled.
+func (m *Mailer) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		m.DeliverDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every message that is due now and records the outcome.
+func (m *Mailer) DeliverDue() {
+	for {
+		mails, err := m.store.GetDueMail(m.now(), batchSize)
+		if err != nil {
+			m.log.Printf("Mail outbox error: %v", err)
+			return
+		}
+		for _, om := range mails {
+			m.deliver(om)
+		}
+		if len(mails) < batchSize {
+			return
+		}
+	}
+}
+
+func (m *Mailer) deliver(om *models.OutboxMail) {
+	err := m.transport.Send(&Message{To: om.To, Subject: om.Subject, TextBody: om.TextBody, HTMLBody: om.HTMLBody})
+	if err == nil {
+		if err := m.store.MarkMailSent(om.ID); err != nil {
+			m.log.Printf("Mail outbox error: %v", err)
+		}
+		return
+	}
+
+	attempts := om.Attempts + 1
+	var next *time.Time
+	if attempts < MaxAttempts {
+		t := m.now().Add(RetryDelay(attempts))
+		next = &t
+		m.log.Printf("Mail %d to %s failed (attempt %d), retrying at %s: %v", om.ID, om.To, attempts, t.Format(time.RFC3339), err)
+	} else {
+		m.log.Printf("Mail %d to %s failed permanently after %d attempts: %v", om.ID, om.To, attempts, err)
+	}
+	if err := m.store.MarkMailAttemptFailed(om.ID, err.Error(), next); err != nil {
+		m.log.Printf("Mail outbox error: %v", err)
+	}
+}
+
+// LogTransport writes messages to a logger instead of sending them. It is
+// used when no SMTP server is configured.
+type LogTransport struct {
+	Log *log.Logger
+}
+
+// Send implements Transport.
+func (t LogTransport) Send(msg *Message) error {
+	t.Log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.TextBody)
+	return nil
+}
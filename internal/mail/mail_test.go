@@ -0,0 +1,214 @@
+package mail
+
+import (
+	"errors"
+	"forum/internal/mail/mailtest"
+	"forum/internal/models"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMTPTransport(t *testing.T) {
+	srv, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Addr)
+	portNum, _ := strconv.Atoi(port)
+	tr := &SMTPTransport{Host: host, Port: portNum, From: "Forum <noreply@example.com>", Timeout: 5 * time.Second}
+
+	msg, err := Render("welcome", map[string]interface{}{"Username": "alice", "BaseURL": "https://forum.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.To = "alice@example.com"
+	if err := tr.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := srv.Messages()
+	if len(got) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(got))
+	}
+	if got[0].From != "noreply@example.com" || len(got[0].To) != 1 || got[0].To[0] != "alice@example.com" {
+		t.Errorf("unexpected envelope: %+v", got[0])
+	}
+	if s := got[0].Header("Subject"); s != "Welcome to Klondike Developers" {
+		t.Errorf("Subject = %q", s)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(got[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	boundary := strings.TrimPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative; boundary=")
+	mr := multipart.NewReader(parsed.Body, boundary)
+	var types []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part) // quoted-printable is decoded by NextPart
+		if !strings.Contains(string(body), "https://forum.example/login") {
+			t.Errorf("%s part lacks login link: %q", part.Header.Get("Content-Type"), body)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	if len(types) != 2 {
+		t.Errorf("parts = %v, want text and html", types)
+	}
+}
+
+func TestSMTPTransportError(t *testing.T) {
+	srv, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.FailRcpt = true
+	host, port, _ := net.SplitHostPort(srv.Addr)
+	portNum, _ := strconv.Atoi(port)
+	tr := &SMTPTransport{Host: host, Port: portNum, From: "noreply@example.com"}
+	if err := tr.Send(&Message{To: "a@example.com", Subject: "x", TextBody: "y"}); err == nil {
+		t.Error("expected error when the server rejects the recipient")
+	}
+}
+
+// memStore is an in-memory Store.
+type memStore struct {
+	mails []*models.OutboxMail
+}
+
+func (s *memStore) EnqueueMail(m *models.OutboxMail) error {
+	m.ID = len(s.mails) + 1
+	m.Status = models.MailPending
+	s.mails = append(s.mails, m)
+	return nil
+}
+
+func (s *memStore) GetDueMail(now time.Time, limit int) ([]*models.OutboxMail, error) {
+	var due []*models.OutboxMail
+	for _, m := range s.mails {
+		if m.Status == models.MailPending && !m.NextAttemptAt.After(now) && len(due) < limit {
+			copied := *m
+			due = append(due, &copied)
+		}
+	}
+	return due, nil
+}
+
+func (s *memStore) MarkMailSent(id int) error {
+	s.mails[id-1].Status = models.MailSent
+	s.mails[id-1].Attempts++
+	return nil
+}
+
+func (s *memStore) MarkMailAttemptFailed(id int, sendErr string, next *time.Time) error {
+	m := s.mails[id-1]
+	m.Attempts++
+	m.LastError = sendErr
+	if next == nil {
+		m.Status = models.MailFailed
+	} else {
+		m.NextAttemptAt = *next
+	}
+	return nil
+}
+
+type flakyTransport struct {
+	failures int
+	sent     []*Message
+}
+
+func (f *flakyTransport) Send(msg *Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection refused")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func TestMailerRetriesWithBackoff(t *testing.T) {
+	store := &memStore{}
+	tr := &flakyTransport{failures: 2}
+	m := NewMailer(store, tr, log.New(io.Discard, "", 0), "https://forum.example")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	if err := m.Send("bob@example.com", "notification", map[string]interface{}{
+		"Username": "bob", "Title": "New reply", "Text": "alice replied", "Link": "/post?id=1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.mails[0].NextAttemptAt = now
+
+	m.DeliverDue()
+	if store.mails[0].Attempts != 1 || !store.mails[0].NextAttemptAt.Equal(now.Add(RetryDelay(1))) {
+		t.Fatalf("after first failure: %+v", store.mails[0])
+	}
+	m.DeliverDue() // not due yet
+	if store.mails[0].Attempts != 1 {
+		t.Fatalf("retried before the backoff elapsed")
+	}
+
+	now = now.Add(RetryDelay(1))
+	m.DeliverDue()
+	now = now.Add(RetryDelay(2))
+	m.DeliverDue()
+	if store.mails[0].Status != models.MailSent || len(tr.sent) != 1 {
+		t.Fatalf("mail not delivered after retries: %+v", store.mails[0])
+	}
+	if !strings.Contains(tr.sent[0].TextBody, "https://forum.example/post?id=1") {
+		t.Errorf("link missing from body: %q", tr.sent[0].TextBody)
+	}
+}
+
+func TestMailerGivesUp(t *testing.T) {
+	store := &memStore{}
+	m := NewMailer(store, &flakyTransport{failures: MaxAttempts}, log.New(io.Discard, "", 0), "")
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	m.Enqueue(&Message{To: "x@example.com", Subject: "s", TextBody: "t"})
+	for i := 0; i < MaxAttempts; i++ {
+		now = now.Add(retryMax)
+		m.DeliverDue()
+	}
+	if store.mails[0].Status != models.MailFailed || store.mails[0].Attempts != MaxAttempts {
+		t.Errorf("mail should have failed permanently: %+v", store.mails[0])
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if RetryDelay(1) != retryBase || RetryDelay(2) != 2*retryBase || RetryDelay(100) != retryMax {
+		t.Errorf("unexpected delays: %v %v %v", RetryDelay(1), RetryDelay(2), RetryDelay(100))
+	}
+}
+
+func TestRenderVerify(t *testing.T) {
+	msg, err := Render("verify", map[string]interface{}{
+		"Username": "bob", "Token": "abc123", "ValidFor": "24 hours", "BaseURL": "https://forum.example",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := "https://forum.example/verify?token=abc123"
+	if msg.Subject != "Confirm your email address" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.TextBody, link) || !strings.Contains(msg.HTMLBody, link) {
+		t.Errorf("verification link missing:\n%s\n%s", msg.TextBody, msg.HTMLBody)
+	}
+}
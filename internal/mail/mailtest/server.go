@@ -0,0 +1,135 @@
+// Package mailtest provides a minimal in-process SMTP server for tests.
+package mailtest
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// Received is a message accepted by the fake server.
+type Received struct {
+	From string
+	To   []string
+	Data string // raw message including headers
+}
+
+// Header parses the message and returns a header value.
+func (r Received) Header(key string) string {
+	msg, err := mail.ReadMessage(strings.NewReader(r.Data))
+	if err != nil {
+		return ""
+	}
+	return msg.Header.Get(key)
+}
+
+// Server is a fake SMTP server listening on 127.0.0.1. It supports the
+// commands net/smtp needs for unauthenticated, unencrypted delivery. Set
+// FailRcpt to make RCPT TO fail with a temporary error.
+type Server struct {
+	Addr     string
+	FailRcpt bool
+
+	ln       net.Listener
+	mu       sync.Mutex
+	messages []Received
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a fake SMTP server. Call Close when done.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: ln.Addr().String(), ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received(nil), s.messages...)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost fake SMTP")
+	var cur Received
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			cur = Received{From: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			if s.FailRcpt {
+				reply("451 Try again later")
+				continue
+			}
+			cur.To = append(cur.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			cur.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, cur)
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
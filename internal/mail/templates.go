@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email has a plain-text template <name>.txt, which must define a
+// "subject" block, and optionally an HTML template <name>.html, which is
+// rendered inside layout.html.
+//
+//go:embed templates/*
+var templateFS embed.FS
+
+// Render executes the named email templates with data.
+func Render(name string, data interface{}) (*Message, error) {
+	textTmpl, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return nil, fmt.Errorf("mail template %q: %w", name, err)
+	}
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("mail template %q subject: %w", name, err)
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("mail template %q: %w", name, err)
+	}
+	msg := &Message{
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if _, err := templateFS.Open("templates/" + name + ".html"); err == nil {
+		htmlTmpl, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+		if err != nil {
+			return nil, fmt.Errorf("mail template %q: %w", name, err)
+		}
+		var html bytes.Buffer
+		if err := htmlTmpl.ExecuteTemplate(&html, "layout.html", data); err != nil {
+			return nil, fmt.Errorf("mail template %q: %w", name, err)
+		}
+		msg.HTMLBody = html.String()
+	}
+	return msg, nil
+}
@@ -0,0 +1,99 @@
+// Package markdown renders user-written post and comment content. Source is
+// parsed as CommonMark (plus GitHub-style tables, strikethrough and
+// autolinks), and the resulting HTML is always passed through a strict
+// allow-list sanitizer, so the output is safe to embed in pages as-is.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+var md = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Table,
+		extension.Strikethrough,
+		extension.Linkify,
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithInlineParsers(util.Prioritized(mentionParser{}, 500)),
+	),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(util.Prioritized(mentionRenderer{}, 500)),
+	),
+	// Raw HTML in the source is dropped by the renderer (no html.WithUnsafe);
+	// the sanitizer below is the second line of defence.
+)
+
+// headingIDPrefix starts the ID of every heading in rendered content, as on
+// GitHub, so that headings written by users cannot clobber the IDs or window
+// globals the page's own scripts rely on.
+const headingIDPrefix = "user-content-"
+
+// prefixedIDs generates heading IDs with goldmark's default generator and
+// prefixes them with headingIDPrefix.
+type prefixedIDs struct {
+	parser.IDs
+}
+
+func (p prefixedIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	return append([]byte(headingIDPrefix), p.IDs.Generate(value, kind)...)
+}
+
+// policy allows only the elements the renderer produces for ordinary
+// markdown. Anything else — scripts, styles, event handlers, iframes,
+// images, javascript: URLs — is removed.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "blockquote", "pre",
+		"h1", "h2", "h3", "h4", "h5", "h6",
+		"strong", "em", "del", "code",
+		"ul", "ol", "li",
+		"table", "thead", "tbody", "tr", "th", "td",
+	)
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^`+headingIDPrefix+`[a-z0-9-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	// Fenced code blocks keep their language for client-side highlighting
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render converts markdown source to sanitized HTML. @mentions of users
+// accepted by resolve become links to their profiles; with a nil resolver
+// every mention is linked.
+func Render(source string, resolve UserResolver) string {
+	ctx := parser.NewContext(parser.WithIDs(prefixedIDs{parser.NewContext().IDs()}))
+	ctx.Set(resolverKey, resolve)
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf, parser.WithContext(ctx)); err != nil {
+		// goldmark only fails on writer errors, which bytes.Buffer never returns
+		return Sanitize("<p>" + bluemonday.StrictPolicy().Sanitize(source) + "</p>")
+	}
+	return Sanitize(buf.String())
+}
+
+// Sanitize removes everything from html that is not on the allow-list.
+func Sanitize(html string) string {
+	return policy.Sanitize(html)
+}
@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []string
+	}{
+		{"emphasis", "some **bold** and _em_", []string{"<strong>bold</strong>", "<em>em</em>"}},
+		{"fenced code", "```go\nfmt.Println(\"<hi>\")\n```", []string{`<pre><code class="language-go">`, "&lt;hi&gt;"}},
+		{"list", "1. one\n2. two", []string{"<ol>", "<li>one</li>"}},
+		{"link", "[site](https://example.com)", []string{`href="https://example.com"`, `rel="nofollow noopener"`}},
+		{"autolink", "see https://example.com now", []string{`href="https://example.com"`}},
+		{"heading ids", "# Getting started\n## Getting started", []string{`<h1 id="user-content-getting-started">`, `<h2 id="user-content-getting-started-1">`}},
+	}
+	for _, tt := range tests {
+		got := Render(tt.src, nil)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: Render(%q) = %q, missing %q", tt.name, tt.src, got, want)
+			}
+		}
+	}
+}
+
+func TestRenderRemovesUnsafeHTML(t *testing.T) {
+	inputs := []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[x](javascript:alert(1))",
+		"<a href=\"javascript:alert(1)\">x</a>",
+		"<iframe src=\"https://evil.example\"></iframe>",
+		"```\" onmouseover=\"alert(1)\nx\n```",
+		"<div style=\"position:fixed\">x</div>",
+	}
+	for _, in := range inputs {
+		got := strings.ToLower(Render(in, nil))
+		for _, bad := range []string{"<script", "onerror", "javascript:", "<iframe", "onmouseover", "style=", "<img"} {
+			if strings.Contains(got, bad) {
+				t.Errorf("Render(%q) = %q contains %q", in, got, bad)
+			}
+		}
+	}
+}
+
+// Headings must not take over IDs the page uses (DOM clobbering), and raw
+// HTML with an arbitrary id must lose it.
+func TestRenderPrefixesHeadingIDs(t *testing.T) {
+	inputs := []string{"# csrf-token", "## notification-badge", "<h1 id=\"csrf-token\">x</h1>", "<p id=\"x\">y</p>"}
+	for _, in := range inputs {
+		got := Render(in, nil)
+		for _, m := range regexp.MustCompile(`id="([^"]*)"`).FindAllStringSubmatch(got, -1) {
+			if !strings.HasPrefix(m[1], headingIDPrefix) {
+				t.Errorf("Render(%q) = %q has unprefixed id %q", in, got, m[1])
+			}
+		}
+	}
+	if got := Sanitize(`<h2 id="csrf-token">x</h2>`); strings.Contains(got, "id=") {
+		t.Errorf("Sanitize kept an unprefixed heading id: %q", got)
+	}
+}
+
+func TestRenderMentions(t *testing.T) {
+	exists := func(name string) bool { return name == "alice" }
+	got := Render("hi @Alice and @bob, mail me at carol@example.com. `@alice`", exists)
+	if !strings.Contains(got, `<a href="/user?username=alice" class="mention" rel="nofollow">@alice</a>`) {
+		t.Errorf("mention of alice not linked: %q", got)
+	}
+	if strings.Contains(got, "username=bob") || !strings.Contains(got, "@bob") {
+		t.Errorf("unknown user bob must stay plain text: %q", got)
+	}
+	if strings.Contains(got, "username=example") {
+		t.Errorf("e-mail address treated as mention: %q", got)
+	}
+	if strings.Count(got, `class="mention"`) != 1 {
+		t.Errorf("mention inside code span linked: %q", got)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	got := Mentions("@bob thanks @Alice. cc @bob\n```\n@carol\n```\nme@dave.org")
+	want := []string{"bob", "alice"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Mentions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package markdown
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// maxUsernameLength matches the registration limit.
+const maxUsernameLength = 30
+
+// UserResolver reports whether a mentioned username belongs to an existing
+// user. Only resolved mentions become profile links.
+type UserResolver func(username string) bool
+
+var resolverKey = parser.NewContextKey()
+
+// KindMention is the AST kind of an @username mention.
+var KindMention = ast.NewNodeKind("Mention")
+
+// Mention is an inline @username node.
+type Mention struct {
+	ast.BaseInline
+	Username string // lower-cased, without the @
+}
+
+// Kind implements ast.Node.
+func (n *Mention) Kind() ast.NodeKind { return KindMention }
+
+// Dump implements ast.Node.
+func (n *Mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Username": n.Username}, nil)
+}
+
+type mentionParser struct{}
+
+func (mentionParser) Trigger() []byte { return []byte{'@'} }
+
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	// "a@b" is an e-mail address or similar, not a mention
+	if prev := block.PrecendingCharacter(); isUsernameRune(prev) || prev == '@' {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	name := scanUsername(line[1:])
+	if name == "" {
+		return nil
+	}
+	username := strings.ToLower(name)
+	if resolve, ok := pc.Get(resolverKey).(UserResolver); ok && resolve != nil && !resolve(username) {
+		return nil
+	}
+	block.Advance(1 + len(name))
+	return &Mention{Username: username}
+}
+
+// scanUsername returns the longest username prefix of b. Trailing dots and
+// dashes are treated as punctuation ("thanks @bob.").
+func scanUsername(b []byte) string {
+	end, runes := 0, 0
+	for end < len(b) && runes < maxUsernameLength {
+		r, size := utf8.DecodeRune(b[end:])
+		if !isUsernameRune(r) && r != '.' && r != '-' {
+			break
+		}
+		end += size
+		runes++
+	}
+	return strings.TrimRight(string(b[:end]), ".-")
+}
+
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+type mentionRenderer struct{}
+
+func (mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMention, renderMention)
+}
+
+func renderMention(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Mention)
+	w.WriteString(`<a href="/user?username=`)
+	w.WriteString(url.QueryEscape(n.Username))
+	w.WriteString(`" class="mention">@`)
+	w.Write(util.EscapeHTML([]byte(n.Username)))
+	w.WriteString(`</a>`)
+	return ast.WalkSkipChildren, nil
+}
+
+// Mentions returns the distinct usernames mentioned in source, in order of
+// first appearance. Mentions inside code spans and blocks are ignored.
+// Usernames are not checked for existence.
+func Mentions(source string) []string {
+	src := []byte(source)
+	doc := md.Parser().Parse(text.NewReader(src))
+	var names []string
+	seen := make(map[string]bool)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if m, ok := n.(*Mention); ok && entering && !seen[m.Username] {
+			seen[m.Username] = true
+			names = append(names, m.Username)
+		}
+		return ast.WalkContinue, nil
+	})
+	return names
+}
@@ -2,14 +2,63 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"forum/internal/db"
+	"forum/internal/models"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
-func AuthMiddleware(repo *db.Repository, logger *log.Logger) func(http.Handler) http.Handler {
+// Policy holds site-wide authentication rules.
+type Policy struct {
+	// TwoFactorRoles lists roles whose privileges require two-factor
+	// authentication. Until such a user enables it they act as a plain
+	// "user" and "twoFactorRequired" is set in the request context.
+	TwoFactorRoles []string
+}
+
+func (p Policy) requiresTwoFactor(role string) bool {
+	for _, r := range p.TwoFactorRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// userContext adds the role and account state of user to ctx, applying the
+// policy. A banned user keeps only the plain "user" role, and their ban is
+// added as "ban" so that write handlers can refuse them.
+func userContext(ctx context.Context, repo *db.Repository, logger *log.Logger, user *models.User, role string, policy Policy) context.Context {
+	if policy.requiresTwoFactor(user.Role) && !user.TwoFactorEnabled() {
+		ctx = context.WithValue(ctx, "twoFactorRequired", true)
+		role = "user"
+	}
+	ban, err := repo.GetActiveBan(user.ID)
+	if err != nil {
+		logger.Printf("Ban lookup error: %v", err)
+	}
+	if ban != nil {
+		ctx = context.WithValue(ctx, "ban", ban)
+		role = "user"
+	}
+	ctx = context.WithValue(ctx, "role", role)
+	return context.WithValue(ctx, "emailVerified", user.EmailVerified())
+}
+
+func AuthMiddleware(repo *db.Repository, logger *log.Logger, policy Policy) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasBearerToken(r) {
+				ctx, ok := bearerContext(repo, logger, policy, w, r)
+				if ok {
+					next.ServeHTTP(w, r.WithContext(ctx))
+				}
+				return
+			}
+
 			cookie, err := r.Cookie("session_id")
 			if err != nil {
 				http.Redirect(w, r, "/login?error=Требуется авторизация", http.StatusSeeOther)
@@ -21,16 +70,132 @@ func AuthMiddleware(repo *db.Repository, logger *log.Logger) func(http.Handler)
 				http.Redirect(w, r, "/login?error=Сессия недействительна", http.StatusSeeOther)
 				return
 			}
+			touchSession(repo, logger, session)
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, "userID", session.UserID)
 
 			// Получаем роль пользователя и добавляем в контекст
 			user, err := repo.GetUserByID(session.UserID)
 			if err == nil {
-				ctx = context.WithValue(ctx, "role", user.Role)
+				ctx = userContext(ctx, repo, logger, user, user.Role, policy)
 			}
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// OptionalAuthMiddleware adds userID, role and emailVerified to the context when the request
+// carries a valid session or API token, and passes anonymous requests through
+// unchanged. Handlers decide themselves how to respond to missing
+// authentication. An invalid bearer token is always rejected.
+func OptionalAuthMiddleware(repo *db.Repository, logger *log.Logger, policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasBearerToken(r) {
+				ctx, ok := bearerContext(repo, logger, policy, w, r)
+				if ok {
+					next.ServeHTTP(w, r.WithContext(ctx))
+				}
+				return
+			}
+
+			cookie, err := r.Cookie("session_id")
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			session, err := repo.GetSession(cookie.Value)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			touchSession(repo, logger, session)
+			ctx := context.WithValue(r.Context(), "userID", session.UserID)
+			if user, err := repo.GetUserByID(session.UserID); err == nil {
+				ctx = userContext(ctx, repo, logger, user, user.Role, policy)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// touchInterval limits how often a session's last-seen time is written.
+const touchInterval = time.Minute
+
+// touchSession updates the last-seen time shown on the sessions page.
+func touchSession(repo *db.Repository, logger *log.Logger, session *models.Session) {
+	if time.Since(session.LastSeenAt) < touchInterval {
+		return
+	}
+	if err := repo.TouchSession(session.SessionID); err != nil {
+		logger.Printf("Session touch error: %v", err)
+	}
+}
+
+func hasBearerToken(r *http.Request) bool {
+	return r.Header.Get("Authorization") != ""
+}
+
+// bearerContext authenticates an "Authorization: Bearer <token>" request and
+// applies the token's scopes: safe methods need read, everything else needs
+// write, and without moderate the user acts with the plain "user" role.
+// Banned users may only read. On failure it writes a JSON error and returns
+// false.
+func bearerContext(repo *db.Repository, logger *log.Logger, policy Policy, w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || secret == "" {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_token", "Authorization header must be \"Bearer <token>\"")
+		return nil, false
+	}
+	token, err := repo.AuthenticateAPIToken(strings.TrimSpace(secret))
+	if err != nil {
+		if err != db.ErrInvalidAPIToken {
+			logger.Printf("API token lookup error: %v", err)
+		}
+		writeTokenError(w, http.StatusUnauthorized, "invalid_token", "Invalid API token")
+		return nil, false
+	}
+	user, err := repo.GetUserByID(token.UserID)
+	if err != nil {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_token", "Invalid API token")
+		return nil, false
+	}
+
+	needed := models.ScopeWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		needed = models.ScopeRead
+	}
+	if !token.HasScope(needed) {
+		writeTokenError(w, http.StatusForbidden, "insufficient_scope", "Token lacks the "+needed+" scope")
+		return nil, false
+	}
+
+	role := user.Role
+	if !token.HasScope(models.ScopeModerate) && (role == "admin" || role == "moderator") {
+		role = "user"
+	}
+	ctx := context.WithValue(r.Context(), "userID", user.ID)
+	ctx = userContext(ctx, repo, logger, user, role, policy)
+	ctx = context.WithValue(ctx, "apiToken", token)
+
+	// Suspended accounts keep read access only
+	if _, banned := ctx.Value("ban").(*models.Ban); banned && needed == models.ScopeWrite {
+		writeTokenError(w, http.StatusForbidden, "account_suspended", "Your account is suspended")
+		return nil, false
+	}
+	return ctx, true
+}
+
+// writeTokenError uses the same error envelope as the JSON API, since bearer
+// tokens are only used by programmatic clients.
+func writeTokenError(w http.ResponseWriter, status int, code, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum"`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	// CSRFCookie holds the browser's CSRF token.
+	CSRFCookie = "csrf_token"
+	// CSRFField is the form field that must repeat the token.
+	CSRFField = "csrf_token"
+	// CSRFHeader carries the token for fetch requests.
+	CSRFHeader = "X-CSRF-Token"
+
+	// csrfMaxFormBytes caps a url-encoded body parsed to find the token.
+	csrfMaxFormBytes = 1 << 20
+	// csrfMultipartPeek is how much of a multipart body is read to find
+	// the token, which must be the form's first field.
+	csrfMultipartPeek = 4 << 10
+)
+
+// CSRF protects state-changing requests with a double-submit token. Every
+// browser gets a random token in a cookie, and the token is added to the
+// request context as "csrfToken" for templates. A POST, PUT, PATCH or
+// DELETE must repeat it in the csrf_token form field or the X-CSRF-Token
+// header; a cross-site page cannot read the cookie, so it cannot forge
+// either. Only a bounded part of the body is read to find the field: a
+// url-encoded form may be up to 1 MB, and in a multipart form the token
+// must be the first field. Requests with an Authorization header are exempt
+// because browsers never attach one on their own. Failed checks are passed
+// to reject, except under /api/ where the API's JSON error is written.
+func CSRF(logger *log.Logger, cookies CookiePolicy, reject http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if cookie, err := r.Cookie(CSRFCookie); err == nil && len(cookie.Value) == 64 {
+				token = cookie.Value
+			}
+
+			if !safeMethod(r.Method) && !hasBearerToken(r) {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = formToken(w, r)
+				}
+				if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					logger.Printf("CSRF check failed: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+					if strings.HasPrefix(r.URL.Path, "/api/") {
+						writeTokenError(w, http.StatusForbidden, "csrf_failed", "Missing or invalid "+CSRFHeader+" header")
+						return
+					}
+					reject.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if token == "" {
+				buf := make([]byte, 32)
+				if _, err := rand.Read(buf); err != nil {
+					logger.Printf("CSRF token error: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				token = hex.EncodeToString(buf)
+				cookies.Set(w, &http.Cookie{
+					Name:  CSRFCookie,
+					Value: token,
+					Path:  "/",
+				})
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "csrfToken", token)))
+		})
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// formToken returns the csrf_token field of a form body, reading no more of
+// the body than the limits above. The handler still sees the whole body.
+func formToken(w http.ResponseWriter, r *http.Request) string {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, csrfMaxFormBytes)
+		return r.PostFormValue(CSRFField)
+	case "multipart/form-data":
+		head, err := io.ReadAll(io.LimitReader(r.Body, csrfMultipartPeek))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+		if err != nil || params["boundary"] == "" {
+			return ""
+		}
+		part, err := multipart.NewReader(bytes.NewReader(head), params["boundary"]).NextPart()
+		if err != nil || part.FormName() != CSRFField {
+			return ""
+		}
+		value, _ := io.ReadAll(io.LimitReader(part, 128))
+		return string(value)
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+const testToken = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// csrfServer wraps a handler that echoes the parsed "title" field, so tests
+// can see that the body still reaches it, behind CSRF. Rejected requests
+// get 418 from the reject handler.
+func csrfServer() http.Handler {
+	reject := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			r.ParseMultipartForm(1 << 20)
+		}
+		io.WriteString(w, r.FormValue("title"))
+	})
+	return CSRF(testLogger, CookiePolicy{}, reject)(next)
+}
+
+func multipartBody(t *testing.T, fields ...string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i < len(fields); i += 2 {
+		mw.WriteField(fields[i], fields[i+1])
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestCSRF(t *testing.T) {
+	form := func(token string) url.Values {
+		return url.Values{CSRFField: {token}, "title": {"Hello"}}
+	}
+	tokenFirst, tokenFirstType := multipartBody(t, CSRFField, testToken, "title", "Hello")
+	tokenLast, tokenLastType := multipartBody(t, "title", "Hello", CSRFField, testToken)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cookie   string
+		header   map[string]string
+		body     io.Reader
+		wantCode int
+		wantBody string
+	}{
+		{name: "safe method without token", method: http.MethodGet, path: "/", wantCode: http.StatusOK},
+		{name: "header", method: http.MethodPost, path: "/like", cookie: testToken,
+			header: map[string]string{CSRFHeader: testToken}, wantCode: http.StatusOK},
+		{name: "form field", method: http.MethodPost, path: "/comment", cookie: testToken,
+			header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:   strings.NewReader(form(testToken).Encode()), wantCode: http.StatusOK, wantBody: "Hello"},
+		{name: "multipart with the token first", method: http.MethodPost, path: "/create-post", cookie: testToken,
+			header: map[string]string{"Content-Type": tokenFirstType}, body: tokenFirst,
+			wantCode: http.StatusOK, wantBody: "Hello"},
+		{name: "multipart with the token after other fields", method: http.MethodPost, path: "/create-post", cookie: testToken,
+			header: map[string]string{"Content-Type": tokenLastType}, body: tokenLast, wantCode: http.StatusTeapot},
+		{name: "oversized form", method: http.MethodPost, path: "/comment", cookie: testToken,
+			header:   map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:     strings.NewReader("title=" + strings.Repeat("a", csrfMaxFormBytes) + "&" + CSRFField + "=" + testToken),
+			wantCode: http.StatusTeapot},
+		{name: "bearer token is exempt", method: http.MethodPost, path: "/api/v1/posts",
+			header: map[string]string{"Authorization": "Bearer secret"}, wantCode: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, path: "/comment", cookie: testToken, wantCode: http.StatusTeapot},
+		{name: "mismatched header", method: http.MethodDelete, path: "/delete-post", cookie: testToken,
+			header: map[string]string{CSRFHeader: strings.Repeat("f", 64)}, wantCode: http.StatusTeapot},
+		{name: "mismatched form field", method: http.MethodPost, path: "/comment", cookie: testToken,
+			header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:   strings.NewReader(form(strings.Repeat("f", 64)).Encode()), wantCode: http.StatusTeapot},
+		{name: "token without cookie", method: http.MethodPost, path: "/like",
+			header: map[string]string{CSRFHeader: testToken}, wantCode: http.StatusTeapot},
+		{name: "api gets a JSON error", method: http.MethodPost, path: "/api/v1/posts", cookie: testToken,
+			wantCode: http.StatusForbidden, wantBody: "csrf_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, tt.body)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookie})
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			csrfServer().ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCSRFIssuesToken(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value("csrfToken").(string)
+	})
+	rec := httptest.NewRecorder()
+	CSRF(testLogger, CookiePolicy{}, http.NotFoundHandler())(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || len(cookies[0].Value) != 64 {
+		t.Fatalf("expected a new %s cookie, got %v", CSRFCookie, cookies)
+	}
+	if seen != cookies[0].Value {
+		t.Errorf("context token %q does not match the cookie %q", seen, cookies[0].Value)
+	}
+
+	// An existing token is kept
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: testToken})
+	rec = httptest.NewRecorder()
+	CSRF(testLogger, CookiePolicy{}, http.NotFoundHandler())(next).ServeHTTP(rec, req)
+	if len(rec.Result().Cookies()) != 0 || seen != testToken {
+		t.Errorf("the existing token should be reused")
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SecurityOptions
+		want map[string]string // "" means the header must be absent
+	}{
+		{
+			name: "everything",
+			opts: SecurityOptions{
+				ContentSecurityPolicy: "default-src 'self'",
+				FrameAncestors:        "'none'",
+				HSTS:                  "max-age=63072000",
+				ReferrerPolicy:        "same-origin",
+				NoSniff:               true,
+			},
+			want: map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; frame-ancestors 'none'",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "max-age=63072000",
+				"Referrer-Policy":           "same-origin",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name: "same-origin framing",
+			opts: SecurityOptions{FrameAncestors: "'self'"},
+			want: map[string]string{
+				"Content-Security-Policy": "frame-ancestors 'self'",
+				"X-Frame-Options":         "SAMEORIGIN",
+			},
+		},
+		{
+			name: "framing by a listed origin has no X-Frame-Options equivalent",
+			opts: SecurityOptions{FrameAncestors: "https://example.com"},
+			want: map[string]string{
+				"Content-Security-Policy": "frame-ancestors https://example.com",
+				"X-Frame-Options":         "",
+			},
+		},
+		{
+			name: "nothing configured",
+			opts: SecurityOptions{},
+			want: map[string]string{
+				"Content-Security-Policy":   "",
+				"X-Frame-Options":           "",
+				"Strict-Transport-Security": "",
+				"Referrer-Policy":           "",
+				"X-Content-Type-Options":    "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			// http.NotFound would set X-Content-Type-Options itself
+			empty := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+			SecurityHeaders(testLogger, tt.opts)(empty).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			for header, want := range tt.want {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value("cspNonce").(string)
+	})
+	handler := SecurityHeaders(testLogger, SecurityOptions{ContentSecurityPolicy: "script-src 'nonce-{nonce}'"})(next)
+
+	nonces := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		csp := rec.Header().Get("Content-Security-Policy")
+		nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "script-src 'nonce-"), "'")
+		if len(nonce) < 22 || nonce == csp {
+			t.Fatalf("no nonce in %q", csp)
+		}
+		if seen != nonce {
+			t.Errorf("context nonce %q differs from the header's %q", seen, nonce)
+		}
+		if nonces[nonce] {
+			t.Errorf("nonce %q reused", nonce)
+		}
+		nonces[nonce] = true
+	}
+}
+
+func TestCookiePolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       CookiePolicy
+		sameSite     http.SameSite
+		wantSecure   bool
+		wantSameSite http.SameSite
+	}{
+		{"plain HTTP", CookiePolicy{}, 0, false, http.SameSiteLaxMode},
+		{"HTTPS", CookiePolicy{Secure: true}, 0, true, http.SameSiteLaxMode},
+		{"explicit SameSite is kept", CookiePolicy{Secure: true}, http.SameSiteStrictMode, true, http.SameSiteStrictMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.policy.Set(rec, &http.Cookie{Name: "session_id", Value: "x", Path: "/", SameSite: tt.sameSite})
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("got %d cookies, want 1", len(cookies))
+			}
+			c := cookies[0]
+			if !c.HttpOnly {
+				t.Errorf("cookie is not HttpOnly")
+			}
+			if c.Secure != tt.wantSecure {
+				t.Errorf("Secure = %v, want %v", c.Secure, tt.wantSecure)
+			}
+			if c.SameSite != tt.wantSameSite {
+				t.Errorf("SameSite = %v, want %v", c.SameSite, tt.wantSameSite)
+			}
+		})
+	}
+}
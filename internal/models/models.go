@@ -10,24 +10,62 @@ type User struct {
 	PasswordHash string
 	Role         string
 	CreatedAt    time.Time
+	// EmailVerifiedAt is nil until the user confirms their email address
+	EmailVerifiedAt *time.Time
+	// TOTPSecret is the base32 authenticator secret; it only protects the
+	// account once TOTPEnabledAt is set
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
 }
 
+// EmailVerified reports whether the user has confirmed their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TwoFactorEnabled reports whether signing in requires a TOTP code.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// Visibility of posts and comments
+const (
+	VisibilityVisible = "visible"
+	// VisibilityHidden content was hidden by a moderator; only its author
+	// and moderators can still see it
+	VisibilityHidden = "hidden"
+	// VisibilityPending content waits in the moderation queue; only its
+	// author and moderators can see it until it is approved
+	VisibilityPending = "pending"
+)
+
 // Post represents a forum post
 type Post struct {
-	ID        int
-	UserID    int
-	Title     string
-	Content   string
-	CreatedAt time.Time
+	ID               int
+	UserID           int
+	Title            string
+	Content          string
+	CreatedAt        time.Time
+	UpdatedAt        *time.Time // nil if never edited
+	Visibility       string
+	Locked           bool       // no new comments or votes
+	PinnedAt         *time.Time // nil unless pinned to the top of the listing
+	PinnedCategoryID *int       // the category the post is pinned in, nil for every listing
+	AnnouncedAt      *time.Time // nil unless shown as a site-wide announcement
 }
 
 // Comment represents a comment to a post
 type Comment struct {
-	ID        int
-	PostID    int
-	UserID    int
-	Content   string
-	CreatedAt time.Time
+	ID         int
+	PostID     int
+	UserID     int
+	ParentID   *int // nil for top-level comments
+	Content    string
+	CreatedAt  time.Time
+	UpdatedAt  *time.Time // nil if never edited
+	Visibility string
+	DeletedAt  *time.Time // set while the comment is in the trash
+	DeletedBy  *int
 }
 
 // Category represents a post category
@@ -72,6 +110,25 @@ type Notification struct {
 	IsRead     bool
 }
 
+// Report statuses
+const (
+	ReportOpen     = "open"
+	ReportInReview = "in_review"
+	ReportActioned = "actioned"
+	ReportRejected = "rejected"
+)
+
+// Report actions a moderator can take on the reported content
+const (
+	ReportActionHide    = "hide"
+	ReportActionDelete  = "delete"
+	ReportActionWarn    = "warn"
+	ReportActionSuspend = "suspend"
+	ReportActionDismiss = "dismiss"
+	// ReportActionRestore shows hidden content again and rejects the report
+	ReportActionRestore = "restore"
+)
+
 // Report represents a report on a post or comment
 type Report struct {
 	ID         int
@@ -81,11 +138,329 @@ type Report struct {
 	Reason     string
 	CreatedAt  time.Time
 	Status     string
+	// Action is the last moderation action taken, empty while open
+	Action         string
+	ResolutionNote string
+	ResolvedBy     *int
+	ResolvedAt     *time.Time
+}
+
+// Resolved reports whether a moderator has closed the report.
+func (r *Report) Resolved() bool {
+	return r.Status == ReportActioned || r.Status == ReportRejected
 }
 
 // Session represents a user session
 type Session struct {
-	SessionID string
+	// ID is a public handle for the session; SessionID is the cookie secret
+	// and must not be shown
+	ID         int
+	SessionID  string
+	UserID     int
+	Expires    time.Time
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// Roles a user can have, from least to most privileged
+var Roles = []string{"user", "moderator", "admin"}
+
+// UserQuery describes a search of the user list in the admin console
+type UserQuery struct {
+	Text     string // substring of the username or email
+	Role     string // empty for any role
+	Verified string // "yes", "no" or empty for both
+	Limit    int
+	Offset   int
+}
+
+// UserSummary is a user with activity counts for the admin console
+type UserSummary struct {
+	User
+	PostCount    int
+	CommentCount int
+}
+
+// RoleChange records who changed a user's role and when
+type RoleChange struct {
+	ID        int
 	UserID    int
-	Expires   time.Time
+	OldRole   string
+	NewRole   string
+	ChangedBy int
+	// ChangedByName is the username of ChangedBy
+	ChangedByName string
+	CreatedAt     time.Time
+}
+
+// LoginThrottle holds the failed sign-ins counted against a username or a
+// client address
+type LoginThrottle struct {
+	Key           string // "user:<name>" or "ip:<address>"
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Ban suspends a user's right to post, comment, vote and report
+type Ban struct {
+	ID           int
+	UserID       int
+	Username     string // username of UserID
+	Reason       string
+	IssuedBy     int
+	IssuedByName string // username of IssuedBy
+	CreatedAt    time.Time
+	ExpiresAt    *time.Time // nil for a permanent ban
+	LiftedAt     *time.Time // set when a moderator ends the ban early
+	LiftedBy     *int
+}
+
+// Permanent reports whether the ban never expires.
+func (b *Ban) Permanent() bool {
+	return b.ExpiresAt == nil
+}
+
+// Active reports whether the ban is in force at the given time.
+func (b *Ban) Active(now time.Time) bool {
+	return b.LiftedAt == nil && (b.ExpiresAt == nil || b.ExpiresAt.After(now))
+}
+
+// SearchQuery describes a full-text search request with optional filters
+type SearchQuery struct {
+	Text       string
+	Author     string     // username, empty for any author
+	CategoryID int        // 0 for any category
+	From       *time.Time // inclusive, может быть nil
+	To         *time.Time // exclusive, может быть nil
+	Limit      int
+}
+
+// SearchResult is a single ranked hit from the full-text index
+type SearchResult struct {
+	Kind      string // "post" or "comment"
+	PostID    int
+	CommentID *int // nil for posts
+	Title     string
+	Snippet   string // content excerpt, matches wrapped in SnippetMatchStart/SnippetMatchEnd
+	UserID    int
+	Username  string
+	CreatedAt time.Time
+	Rank      float64
+}
+
+// Markers surrounding matched terms in SearchResult.Snippet.
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// Revision target types
+const (
+	RevisionPost    = "post"
+	RevisionComment = "comment"
+)
+
+// Revision is a stored version of a post or comment
+type Revision struct {
+	ID         int
+	TargetType string
+	TargetID   int
+	Title      *string // nil for comments
+	Content    string
+	EditorID   int
+	CreatedAt  time.Time
+}
+
+// API token scopes
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeModerate = "moderate"
+)
+
+// APIToken is a personal access token; only a hash of the secret is stored
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string // first characters of the secret, shown to identify the token
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Outbox mail statuses
+const (
+	MailPending = "pending"
+	MailSent    = "sent"
+	MailFailed  = "failed"
+)
+
+// OutboxMail is an email waiting in (or delivered from) the persistent outbox
+type OutboxMail struct {
+	ID            int
+	To            string
+	Subject       string
+	TextBody      string
+	HTMLBody      string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+// AutomodRule is a check an admin sets up for new and edited posts and
+// comments. The meaning of Pattern and Threshold depends on Kind; see
+// package automod.
+type AutomodRule struct {
+	ID        int
+	Name      string
+	Kind      string
+	Pattern   string
+	Threshold int
+	// MaxAccountAgeDays limits the rule to accounts younger than this many
+	// days; 0 applies it to everyone
+	MaxAccountAgeDays int
+	Action            string
+	Enabled           bool
+	CreatedBy         int
+	CreatedAt         time.Time
+	UpdatedAt         *time.Time
+}
+
+// AutomodMatch records a rule matching a post or comment
+type AutomodMatch struct {
+	ID         int
+	RuleID     *int // nil once the rule is deleted
+	RuleName   string
+	UserID     int
+	Username   string
+	TargetType string // "post" or "comment"
+	TargetID   *int   // nil if the content was rejected
+	Action     string
+	Excerpt    string
+	CreatedAt  time.Time
+}
+
+// QueuedItem is a post or comment waiting in the moderation queue
+type QueuedItem struct {
+	Type      string // "post" or "comment"
+	ID        int
+	PostID    int
+	PostTitle string
+	UserID    int
+	Username  string
+	Content   string
+	CreatedAt time.Time
+	// Rules names the automod rules that held the item, empty if it was
+	// queued by premoderation
+	Rules string
+}
+
+// TrashedItem is a deleted post or comment kept in the trash until it is
+// restored or purged
+type TrashedItem struct {
+	Type          string // "post" or "comment"
+	ID            int
+	PostID        int
+	PostTitle     string
+	UserID        int
+	Username      string
+	Content       string
+	CreatedAt     time.Time
+	DeletedAt     time.Time
+	DeletedBy     int
+	DeletedByName string
+}
+
+// Audit log actions
+const (
+	AuditPostEdit           = "post.edit"
+	AuditPostDelete         = "post.delete"
+	AuditPostRestore        = "post.restore"
+	AuditPostApprove        = "post.approve"
+	AuditPostReject         = "post.reject"
+	AuditPostLock           = "post.lock"
+	AuditPostUnlock         = "post.unlock"
+	AuditPostPin            = "post.pin"
+	AuditPostUnpin          = "post.unpin"
+	AuditPostAnnounce       = "post.announce"
+	AuditPostUnannounce     = "post.unannounce"
+	AuditPostHide           = "post.hide"
+	AuditPostUnhide         = "post.unhide"
+	AuditPostRollback       = "post.rollback"
+	AuditCommentEdit        = "comment.edit"
+	AuditCommentDelete      = "comment.delete"
+	AuditCommentRestore     = "comment.restore"
+	AuditCommentApprove     = "comment.approve"
+	AuditCommentReject      = "comment.reject"
+	AuditCommentHide        = "comment.hide"
+	AuditCommentUnhide      = "comment.unhide"
+	AuditCommentRollback    = "comment.rollback"
+	AuditReportResolve      = "report.resolve"
+	AuditCategoryCreate     = "category.create"
+	AuditUserWarn           = "user.warn"
+	AuditUserBan            = "user.ban"
+	AuditUserUnban          = "user.unban"
+	AuditUserRole           = "user.role"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserSessionsRevoke = "user.sessions_revoke"
+	AuditLockoutClear       = "lockout.clear"
+	AuditAutomodCreate      = "automod.create"
+	AuditAutomodUpdate      = "automod.update"
+	AuditAutomodDelete      = "automod.delete"
+)
+
+// AuditActions lists the audit log actions in the order the admin page
+// offers them as filters
+var AuditActions = []string{
+	AuditPostEdit, AuditPostDelete, AuditPostRestore, AuditPostApprove, AuditPostReject,
+	AuditPostLock, AuditPostUnlock, AuditPostPin, AuditPostUnpin, AuditPostAnnounce, AuditPostUnannounce,
+	AuditPostHide, AuditPostUnhide, AuditPostRollback,
+	AuditCommentEdit, AuditCommentDelete, AuditCommentRestore, AuditCommentApprove, AuditCommentReject,
+	AuditCommentHide, AuditCommentUnhide, AuditCommentRollback,
+	AuditReportResolve, AuditCategoryCreate,
+	AuditUserWarn, AuditUserBan, AuditUserUnban, AuditUserRole, AuditUserPasswordReset, AuditUserSessionsRevoke,
+	AuditLockoutClear, AuditAutomodCreate, AuditAutomodUpdate, AuditAutomodDelete,
+}
+
+// AuditEntry records a privileged action by a moderator or admin. Before
+// and After are JSON snapshots of the target, empty when there is nothing
+// to show.
+type AuditEntry struct {
+	ID         int
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   int
+	Before     string
+	After      string
+	IP         string
+	CreatedAt  time.Time
+}
+
+// AuditQuery describes a search of the audit log
+type AuditQuery struct {
+	Actor  string     // username, empty for anyone
+	Action string     // empty for any action
+	From   *time.Time // inclusive, может быть nil
+	To     *time.Time // exclusive, может быть nil
+	Limit  int        // -1 for no limit
+	Offset int
 }
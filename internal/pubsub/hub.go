@@ -0,0 +1,73 @@
+// Package pubsub is an in-process publish/subscribe hub for pushing events
+// to connected users, e.g. over Server-Sent Events.
+package pubsub
+
+import "sync"
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events to it are dropped.
+const subscriberBuffer = 16
+
+// Event is a message delivered to a user's subscribers.
+type Event struct {
+	Name string      // event type, used as the SSE event name
+	Data interface{} // JSON-encodable payload
+}
+
+// Hub fans events out to every subscription of a user. It is safe for
+// concurrent use.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[int]map[chan Event]struct{}
+}
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int]map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscription for userID. The returned cancel
+// function must be called to release it; it closes the channel.
+func (h *Hub) Subscribe(userID int) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan Event]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs[userID], ch)
+			if len(h.subs[userID]) == 0 {
+				delete(h.subs, userID)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Publish sends an event to all of userID's subscribers without blocking.
+// Subscribers whose buffer is full miss the event.
+func (h *Hub) Publish(userID int, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions for userID.
+func (h *Hub) Subscribers(userID int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[userID])
+}
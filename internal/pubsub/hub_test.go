@@ -0,0 +1,44 @@
+package pubsub
+
+import "testing"
+
+func TestPublishSubscribe(t *testing.T) {
+	hub := NewHub()
+	ch, cancel := hub.Subscribe(1)
+	other, cancelOther := hub.Subscribe(2)
+	defer cancelOther()
+
+	hub.Publish(1, Event{Name: "unread", Data: 3})
+	select {
+	case ev := <-ch:
+		if ev.Name != "unread" || ev.Data != 3 {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	default:
+		t.Fatal("event not delivered")
+	}
+	select {
+	case ev := <-other:
+		t.Errorf("event leaked to another user: %+v", ev)
+	default:
+	}
+
+	cancel()
+	cancel()
+	if _, open := <-ch; open {
+		t.Error("channel not closed after cancel")
+	}
+	if n := hub.Subscribers(1); n != 0 {
+		t.Errorf("Subscribers = %d after cancel", n)
+	}
+	hub.Publish(1, Event{Name: "unread"}) // must not panic or block
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	hub := NewHub()
+	_, cancel := hub.Subscribe(1)
+	defer cancel()
+	for i := 0; i < subscriberBuffer*2; i++ {
+		hub.Publish(1, Event{Name: "notification", Data: i})
+	}
+}
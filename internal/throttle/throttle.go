@@ -0,0 +1,196 @@
+// Package throttle slows down password guessing. It counts failed sign-ins
+// per username and per client IP and, past a number of free attempts, locks
+// the key for an exponentially growing delay. Counts live in a Store so they
+// survive restarts.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Store persists failure counts. Keys are opaque strings such as
+// "user:alice" or "ip:10.0.0.1".
+type Store interface {
+	// RecordLoginFailure adds a failure for key and returns the new count.
+	// The count starts over if the previous failure is older than window.
+	RecordLoginFailure(key string, now time.Time, window time.Duration) (int, error)
+	// SetLoginLockout locks key until the given time.
+	SetLoginLockout(key string, until time.Time) error
+	// GetLoginLockout returns the time key is locked until, or nil.
+	GetLoginLockout(key string) (*time.Time, error)
+	// ClearLoginFailures forgets all failures for key.
+	ClearLoginFailures(key string) error
+	// RefundLoginFailure takes back one failure of key, if it has any.
+	RefundLoginFailure(key string) error
+}
+
+// Policy sets how quickly a key is locked.
+type Policy struct {
+	// Free is how many failures are allowed before the first lockout.
+	Free int
+	// Base is the first lockout; each further failure doubles it up to Max.
+	Base, Max time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Delay returns the lockout after the given number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.Free {
+		return 0
+	}
+	d := p.Base
+	for i := p.Free; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+// DefaultUserPolicy allows 5 wrong passwords per account, then locks it for
+// 30 s, 1 min, 2 min and so on up to an hour.
+var DefaultUserPolicy = Policy{Free: 5, Base: 30 * time.Second, Max: time.Hour, Window: 24 * time.Hour}
+
+// DefaultIPPolicy is looser, since many users may share an address.
+var DefaultIPPolicy = Policy{Free: 20, Base: 30 * time.Second, Max: time.Hour, Window: 24 * time.Hour}
+
+// Key prefixes for usernames and client addresses.
+const (
+	UserPrefix = "user:"
+	IPPrefix   = "ip:"
+)
+
+// Throttler applies the user and IP policies. It is safe for concurrent use.
+type Throttler struct {
+	store Store
+	user  Policy
+	ip    Policy
+	// mu serializes updates so concurrent failures for the same key cannot
+	// overwrite each other's lockout
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New creates a Throttler.
+func New(store Store, user, ip Policy) *Throttler {
+	return &Throttler{store: store, user: user, ip: ip, now: time.Now}
+}
+
+// fail records a failure for the username and address and returns the
+// lockouts it set, nil where it set none. t.mu must be held.
+func (t *Throttler) fail(username, ip string, now time.Time) ([]reservation, error) {
+	var res []reservation
+	for _, k := range []struct {
+		key    string
+		policy Policy
+	}{
+		{UserPrefix + username, t.user},
+		{IPPrefix + ip, t.ip},
+	} {
+		failures, err := t.store.RecordLoginFailure(k.key, now, k.policy.Window)
+		if err != nil {
+			return res, err
+		}
+		r := reservation{key: k.key}
+		if d := k.policy.Delay(failures); d > 0 {
+			until := now.Add(d)
+			if err := t.store.SetLoginLockout(k.key, until); err != nil {
+				return res, err
+			}
+			r.set = &until
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// An Attempt is a sign-in attempt reserved by Begin. It is counted as a
+// failure from the start, so concurrent guesses cannot all pass the check
+// before any of them is recorded; Succeed and Refund take it back.
+type Attempt struct {
+	t        *Throttler
+	username string
+	at       time.Time
+	res      []reservation
+}
+
+// reservation is the failure an Attempt recorded for one key and the
+// lockout it imposed, if any.
+type reservation struct {
+	key string
+	set *time.Time
+}
+
+// Begin checks the lockouts of the username and address and, if neither is
+// locked, reserves an attempt by recording it as a failure. Otherwise it
+// returns how long they must wait and a nil Attempt. A wrong password needs
+// nothing more; a right one must call Succeed, and an attempt that was
+// never judged must call Refund.
+func (t *Throttler) Begin(username, ip string) (*Attempt, time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range []string{UserPrefix + username, IPPrefix + ip} {
+		until, err := t.store.GetLoginLockout(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		if until != nil && until.After(now) {
+			wait = max(wait, until.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return nil, wait, nil
+	}
+	res, err := t.fail(username, ip, now)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Attempt{t: t, username: username, at: now, res: res}, 0, nil
+}
+
+// Succeed forgets the failures of the username and takes back the
+// attempt's failure of the address, which keeps its earlier count.
+func (a *Attempt) Succeed() error {
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	if err := a.t.store.ClearLoginFailures(UserPrefix + a.username); err != nil {
+		return err
+	}
+	return a.refund(a.res[1:])
+}
+
+// Refund takes the attempt back entirely, as if it never happened.
+func (a *Attempt) Refund() error {
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	return a.refund(a.res)
+}
+
+// refund takes back the failures and lifts the lockouts they imposed, unless
+// a later failure has locked the key again since. Any earlier lockout had
+// already expired, or the attempt would not have been allowed. t.mu must be
+// held.
+func (a *Attempt) refund(res []reservation) error {
+	for _, r := range res {
+		if err := a.t.store.RefundLoginFailure(r.key); err != nil {
+			return err
+		}
+		if r.set == nil {
+			continue
+		}
+		cur, err := a.t.store.GetLoginLockout(r.key)
+		if err != nil {
+			return err
+		}
+		if cur == nil || !cur.Equal(*r.set) {
+			continue
+		}
+		if err := a.t.store.SetLoginLockout(r.key, a.at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil *time.Time
+}
+
+// memStore is an in-memory Store. Like the database, each call is atomic on
+// its own.
+type memStore struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func newMemStore() *memStore {
+	return &memStore{entries: map[string]*entry{}}
+}
+
+func (s *memStore) RecordLoginFailure(key string, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || now.Sub(e.lastFailure) > window {
+		e = &entry{}
+		s.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	return e.failures, nil
+}
+
+func (s *memStore) SetLoginLockout(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key].lockedUntil = &until
+	return nil
+}
+
+func (s *memStore) GetLoginLockout(key string) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e.lockedUntil, nil
+	}
+	return nil, nil
+}
+
+func (s *memStore) ClearLoginFailures(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memStore) RefundLoginFailure(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && e.failures > 0 {
+		e.failures--
+	}
+	return nil
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Free: 3, Base: time.Second, Max: 10 * time.Second}
+	want := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for failures, w := range want {
+		if got := p.Delay(failures); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", failures, got, w)
+		}
+	}
+	if got := p.Delay(1000); got != p.Max {
+		t.Errorf("Delay(1000) = %v, want cap %v", got, p.Max)
+	}
+}
+
+// wait returns how long the username and address must wait before the next
+// attempt. An allowed attempt is refunded, so probing leaves no trace.
+func wait(t *testing.T, th *Throttler, username, ip string) time.Duration {
+	t.Helper()
+	a, d, err := th.Begin(username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != nil {
+		if err := a.Refund(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// fail makes an attempt that turns out to be a wrong password.
+func fail(t *testing.T, th *Throttler, username, ip string) {
+	t.Helper()
+	if a, d, err := th.Begin(username, ip); err != nil || a == nil {
+		t.Fatalf("attempt refused: wait %v, err %v", d, err)
+	}
+}
+
+func TestThrottlerLocksAndBacksOff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	th := New(newMemStore(), Policy{Free: 2, Base: time.Minute, Max: time.Hour, Window: 24 * time.Hour}, DefaultIPPolicy)
+	th.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if w := wait(t, th, "alice", "10.0.0.1"); w != 0 {
+			t.Fatalf("locked after %d failures", i)
+		}
+		fail(t, th, "alice", "10.0.0.1")
+	}
+	if w := wait(t, th, "alice", "10.0.0.9"); w != time.Minute {
+		t.Fatalf("wait = %v, want 1m", w)
+	}
+
+	now = now.Add(time.Minute)
+	fail(t, th, "alice", "10.0.0.1")
+	if w := wait(t, th, "alice", "10.0.0.1"); w != 2*time.Minute {
+		t.Errorf("wait after third failure = %v, want 2m", w)
+	}
+	if w := wait(t, th, "bob", "10.0.0.2"); w != 0 {
+		t.Errorf("other user and address throttled: %v", w)
+	}
+
+	now = now.Add(2 * time.Minute)
+	a, _, _ := th.Begin("alice", "10.0.0.2")
+	if err := a.Succeed(); err != nil {
+		t.Fatal(err)
+	}
+	fail(t, th, "alice", "10.0.0.2")
+	if w := wait(t, th, "alice", "10.0.0.2"); w != 0 {
+		t.Errorf("failures not forgotten after success: %v", w)
+	}
+}
+
+func TestThrottlerIPAcrossUsers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	th := New(newMemStore(), DefaultUserPolicy, Policy{Free: 3, Base: time.Minute, Max: time.Hour, Window: time.Hour})
+	th.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		fail(t, th, fmt.Sprintf("user%d", i), "10.0.0.1")
+	}
+	if w := wait(t, th, "someone", "10.0.0.1"); w != time.Minute {
+		t.Errorf("address not throttled across accounts: %v", w)
+	}
+}
+
+func TestThrottlerConcurrentFailures(t *testing.T) {
+	store := newMemStore()
+	th := New(store, Policy{Free: 1000, Base: time.Second, Max: time.Hour, Window: time.Hour}, DefaultIPPolicy)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.Begin("alice", fmt.Sprintf("10.0.0.%d", i))
+		}()
+	}
+	wg.Wait()
+	if got := store.entries[UserPrefix+"alice"].failures; got != 50 {
+		t.Errorf("failures = %d, want 50", got)
+	}
+}
+
+func TestThrottlerConcurrentAttempts(t *testing.T) {
+	th := New(newMemStore(), Policy{Free: 5, Base: time.Minute, Max: time.Hour, Window: time.Hour}, DefaultIPPolicy)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, wait, err := th.Begin("alice", "10.0.0.1")
+			if err != nil || (attempt == nil) == (wait == 0) {
+				t.Errorf("Begin = %v, %v, %v", attempt, wait, err)
+				return
+			}
+			if attempt != nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Errorf("%d concurrent attempts allowed, want 5", allowed)
+	}
+}
+
+func TestAttemptSucceedAndRefund(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newMemStore()
+	th := New(store, Policy{Free: 2, Base: time.Minute, Max: time.Hour, Window: time.Hour},
+		Policy{Free: 2, Base: time.Minute, Max: time.Hour, Window: time.Hour})
+	th.now = func() time.Time { return now }
+
+	// A wrong password is already counted
+	if a, _, _ := th.Begin("alice", "10.0.0.1"); a == nil {
+		t.Fatal("first attempt refused")
+	}
+	// The second attempt locks the account and the address, but a right
+	// password lifts both
+	a, _, _ := th.Begin("alice", "10.0.0.1")
+	if w := wait(t, th, "alice", "10.0.0.2"); w != time.Minute {
+		t.Fatalf("reserved attempt did not lock: %v", w)
+	}
+	if err := a.Succeed(); err != nil {
+		t.Fatal(err)
+	}
+	if w := wait(t, th, "alice", "10.0.0.1"); w != 0 {
+		t.Errorf("locked after a successful attempt: %v", w)
+	}
+	if got := store.entries[IPPrefix+"10.0.0.1"].failures; got != 1 {
+		t.Errorf("address failures = %d, want 1", got)
+	}
+
+	// Another attempt from the address locks it again; a refund lifts it
+	a, _, _ = th.Begin("bob", "10.0.0.1")
+	a2, _, _ := th.Begin("carol", "10.0.0.1")
+	if a2 != nil {
+		t.Fatal("address not locked after its second failure")
+	}
+	if err := a.Refund(); err != nil {
+		t.Fatal(err)
+	}
+	if w := wait(t, th, "bob", "10.0.0.1"); w != 0 {
+		t.Errorf("refunded attempt left a lockout: %v", w)
+	}
+	if got := store.entries[UserPrefix+"bob"].failures; got != 0 {
+		t.Errorf("refunded user failures = %d, want 0", got)
+	}
+
+	// A refund does not lift a lockout imposed by a later failure
+	now = now.Add(time.Hour / 2)
+	a, _, _ = th.Begin("dave", "10.0.0.3")
+	fail(t, th, "dave", "10.0.0.3")
+	a.Refund()
+	if w := wait(t, th, "dave", "10.0.0.3"); w == 0 {
+		t.Errorf("refund lifted a later lockout")
+	}
+}
@@ -0,0 +1,68 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from RFC 6238 appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now))
+
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("current code rejected")
+	}
+	if _, ok := Validate(secret, code, now.Add(Period)); !ok {
+		t.Errorf("code from the previous period rejected")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Errorf("stale code accepted")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Errorf("short code accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Klondike Developers", "alice", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Klondike%20Developers:alice?") {
+		t.Errorf("unexpected label: %s", uri)
+	}
+	for _, part := range []string{"secret=" + rfcSecret, "issuer=Klondike+Developers", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("%s missing from %s", part, uri)
+		}
+	}
+}
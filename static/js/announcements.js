@@ -0,0 +1,15 @@
+// Site-wide announcements: the close button on a banner dismisses it for the
+// signed-in user, so it does not come back on other pages.
+document.querySelectorAll('[data-dismiss-announcement]').forEach(function(button) {
+    button.addEventListener('click', function() {
+        const banner = this.closest('.announcement');
+        fetch('/announcements/dismiss?id=' + this.dataset.dismissAnnouncement, {
+            method: 'POST',
+            headers: { 'X-CSRF-Token': this.dataset.csrfToken }
+        }).then(function(res) {
+            if (res.ok) {
+                banner.remove();
+            }
+        });
+    });
+});
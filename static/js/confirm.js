@@ -0,0 +1,9 @@
+// Asks before submitting forms and buttons marked with data-confirm. Inline
+// onclick/onsubmit handlers are blocked by the Content-Security-Policy.
+document.addEventListener('submit', function(event) {
+    const button = event.submitter;
+    const message = (button && button.dataset.confirm) || event.target.dataset.confirm;
+    if (message && !confirm(message)) {
+        event.preventDefault();
+    }
+});
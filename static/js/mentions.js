@@ -0,0 +1,95 @@
+// @mention autocomplete for textareas marked with data-mentions. Typing "@"
+// followed by a few characters suggests matching usernames; click or press
+// Enter/Tab to insert one.
+(function() {
+    const MENTION_BEFORE_CARET = /(^|[^\w@])@([\p{L}\p{N}_.-]{1,30})$/u;
+
+    function setup(textarea) {
+        const wrapper = document.createElement('div');
+        wrapper.className = 'position-relative';
+        textarea.parentNode.insertBefore(wrapper, textarea);
+        wrapper.appendChild(textarea);
+
+        const menu = document.createElement('ul');
+        menu.className = 'list-group position-absolute shadow-sm d-none mention-menu';
+        menu.style.zIndex = 1000;
+        menu.style.left = '0';
+        menu.style.top = '100%';
+        wrapper.appendChild(menu);
+
+        let items = [];
+        let active = 0;
+        let query = null;
+        let timer = null;
+
+        function close() {
+            menu.classList.add('d-none');
+            items = [];
+        }
+
+        function render() {
+            menu.innerHTML = '';
+            items.forEach(function(name, i) {
+                const li = document.createElement('li');
+                li.className = 'list-group-item list-group-item-action py-1' + (i === active ? ' active' : '');
+                li.textContent = '@' + name;
+                li.addEventListener('mousedown', function(e) {
+                    e.preventDefault();
+                    insert(name);
+                });
+                menu.appendChild(li);
+            });
+            menu.classList.toggle('d-none', items.length === 0);
+        }
+
+        function insert(name) {
+            const caret = textarea.selectionStart;
+            const before = textarea.value.slice(0, caret).replace(/@[\p{L}\p{N}_.-]*$/u, '@' + name + ' ');
+            textarea.value = before + textarea.value.slice(caret);
+            textarea.selectionStart = textarea.selectionEnd = before.length;
+            textarea.dispatchEvent(new Event('input'));
+            close();
+        }
+
+        textarea.addEventListener('input', function() {
+            const match = textarea.value.slice(0, textarea.selectionStart).match(MENTION_BEFORE_CARET);
+            if (!match) {
+                query = null;
+                close();
+                return;
+            }
+            query = match[2];
+            clearTimeout(timer);
+            timer = setTimeout(function() {
+                const q = query;
+                fetch('/api/v1/users/autocomplete?q=' + encodeURIComponent(q))
+                    .then(res => res.ok ? res.json() : { data: [] })
+                    .then(body => {
+                        if (q !== query) return; // a newer query is pending
+                        items = body.data.map(u => u.username);
+                        active = 0;
+                        render();
+                    })
+                    .catch(close);
+            }, 150);
+        });
+
+        textarea.addEventListener('keydown', function(e) {
+            if (items.length === 0) return;
+            if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+                e.preventDefault();
+                active = (active + (e.key === 'ArrowDown' ? 1 : items.length - 1)) % items.length;
+                render();
+            } else if (e.key === 'Enter' || e.key === 'Tab') {
+                e.preventDefault();
+                insert(items[active]);
+            } else if (e.key === 'Escape') {
+                close();
+            }
+        });
+
+        textarea.addEventListener('blur', close);
+    }
+
+    document.querySelectorAll('textarea[data-mentions]').forEach(setup);
+})();
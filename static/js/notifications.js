@@ -0,0 +1,23 @@
+// Live notifications: keeps every .notif-count badge in sync with the
+// unread count and lets pages react to new notifications through the
+// "forum:notification" DOM event.
+(function() {
+    if (!window.EventSource || !document.querySelector('.notif-count')) return;
+
+    function setCount(count) {
+        document.querySelectorAll('.notif-count').forEach(function(el) {
+            el.textContent = count;
+            el.classList.toggle('d-none', count === 0);
+        });
+    }
+
+    const source = new EventSource('/notifications/stream');
+    source.addEventListener('unread', function(e) {
+        setCount(JSON.parse(e.data));
+    });
+    source.addEventListener('notification', function(e) {
+        const payload = JSON.parse(e.data);
+        setCount(payload.unread_count);
+        document.dispatchEvent(new CustomEvent('forum:notification', { detail: payload.notification }));
+    });
+})();